@@ -3,7 +3,15 @@ module github.com/hortonworks/salt-bootstrap
 go 1.24.0
 
 require (
+	github.com/ProtonMail/go-crypto v1.3.0
 	github.com/gorilla/mux v1.8.1
+	github.com/klauspost/compress v1.18.0
 	github.com/tredoe/osutil v1.5.0
+	golang.org/x/crypto v0.43.0
 	gopkg.in/yaml.v2 v2.4.0
 )
+
+require (
+	github.com/cloudflare/circl v1.6.0 // indirect
+	golang.org/x/sys v0.37.0 // indirect
+)
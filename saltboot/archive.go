@@ -0,0 +1,520 @@
+package saltboot
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ARCHIVE_ZIP      = "zip"
+	ARCHIVE_TAR      = "tar"
+	ARCHIVE_TAR_GZ   = "tar.gz"
+	ARCHIVE_TAR_ZSTD = "tar.zst"
+
+	EXTRACT_DETECT = "detect"
+	EXTRACT_ALWAYS = "always"
+	EXTRACT_NEVER  = "never"
+
+	tarBlockSize   = 512
+	tarMagicOffset = 257
+
+	archiveMaxSizeKey    = "SALTBOOT_ARCHIVE_MAX_SIZE"
+	archiveMaxEntriesKey = "SALTBOOT_ARCHIVE_MAX_ENTRIES"
+	archiveMaxRatioKey   = "SALTBOOT_ARCHIVE_MAX_RATIO"
+
+	defaultArchiveMaxSize    = 1 << 30
+	defaultArchiveMaxEntries = 10000
+	defaultArchiveMaxRatio   = 100
+
+	maxSymlinkHops = 40
+)
+
+var (
+	// archives are extracted if they are detected by default, never stores them as they are
+	extractModes = []string{"", EXTRACT_DETECT, EXTRACT_ALWAYS, EXTRACT_NEVER}
+
+	zipMagic      = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06")
+	gzipMagic     = []byte{0x1f, 0x8b}
+	zstdMagic     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic      = []byte("ustar")
+)
+
+func isTarHeader(header []byte) bool {
+	return len(header) >= tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic)
+}
+
+// decompressor returns the reader of the decompressed stream for the compressed tar archives
+func decompressor(archiveType string, r io.Reader) (io.Reader, func(), error) {
+	switch archiveType {
+	case ARCHIVE_TAR_GZ:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, func() { closeIt(gz) }, nil
+	case ARCHIVE_TAR_ZSTD:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	}
+	return r, func() {}, nil
+}
+
+// detectArchiveType sniffs the content of the file, compressed streams are archives only if they contain a tar,
+// an empty string is returned for any other content
+func detectArchiveType(file io.ReaderAt, size int64) (string, error) {
+	header := make([]byte, tarBlockSize)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	var compressed string
+	switch {
+	case bytes.HasPrefix(header, zipMagic) || bytes.HasPrefix(header, zipEmptyMagic):
+		return ARCHIVE_ZIP, nil
+	case isTarHeader(header):
+		return ARCHIVE_TAR, nil
+	case bytes.HasPrefix(header, gzipMagic):
+		compressed = ARCHIVE_TAR_GZ
+	case bytes.HasPrefix(header, zstdMagic):
+		compressed = ARCHIVE_TAR_ZSTD
+	default:
+		return "", nil
+	}
+
+	r, closeReader, err := decompressor(compressed, io.NewSectionReader(file, 0, size))
+	if err != nil {
+		return "", nil
+	}
+	defer closeReader()
+	decompressed, _ := bufio.NewReaderSize(r, tarBlockSize).Peek(tarBlockSize)
+	if isTarHeader(decompressed) {
+		return compressed, nil
+	}
+	return "", nil
+}
+
+// archiveLimits protect the node from archive bombs
+type archiveLimits struct {
+	// total size of the extracted files in bytes
+	maxSize    int64
+	maxEntries int
+	// total size of the extracted files compared to the size of the archive
+	maxRatio int64
+}
+
+func determineArchiveLimit(key string, defaultValue int64) int64 {
+	if value, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil && value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+func determineArchiveLimits() archiveLimits {
+	return archiveLimits{
+		maxSize:    determineArchiveLimit(archiveMaxSizeKey, defaultArchiveMaxSize),
+		maxEntries: int(determineArchiveLimit(archiveMaxEntriesKey, defaultArchiveMaxEntries)),
+		maxRatio:   determineArchiveLimit(archiveMaxRatioKey, defaultArchiveMaxRatio),
+	}
+}
+
+// archiveExtractor writes the entries of an archive under the destination, the entries and the targets of the
+// symlinks and hardlinks must stay under the destination
+type archiveExtractor struct {
+	dest     string
+	dirModes map[string]fs.FileMode
+	// the requested ownership of the upload, applied to every entry before its content is written
+	attributes *uploadAttributes
+	// the extracted size may not exceed either the size or the ratio limit
+	maxSize    int64
+	maxEntries int
+	written    int64
+	entries    int
+	// the created symlinks are checked again at the end, as later entries may change what their targets resolve to
+	symlinks map[string]string
+}
+
+func newArchiveExtractor(dest string, archiveSize int64, attributes *uploadAttributes) (*archiveExtractor, error) {
+	if err := attributes.mkdirAll(dest, 0755); err != nil {
+		return nil, err
+	}
+	realDest, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return nil, err
+	}
+	limits := determineArchiveLimits()
+	maxSize := limits.maxSize
+	if archiveSize > 0 && archiveSize <= maxSize/limits.maxRatio {
+		maxSize = archiveSize * limits.maxRatio
+	}
+	return &archiveExtractor{dest: realDest, dirModes: make(map[string]fs.FileMode), symlinks: make(map[string]string), attributes: attributes, maxSize: maxSize,
+		maxEntries: limits.maxEntries}, nil
+}
+
+// validateEntryName rejects the absolute names and the names with parent directory references
+func validateEntryName(name string) error {
+	if len(name) == 0 || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") || filepath.IsAbs(name) {
+		return fmt.Errorf("illegal absolute path of archive entry: %s", name)
+	}
+	for _, element := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if element == ".." {
+			return fmt.Errorf("illegal path traversal in archive entry: %s", name)
+		}
+	}
+	return nil
+}
+
+func (e *archiveExtractor) countEntry() error {
+	e.entries++
+	if e.entries > e.maxEntries {
+		return fmt.Errorf("archive has more than %d entries", e.maxEntries)
+	}
+	return nil
+}
+
+func (e *archiveExtractor) contains(path string) bool {
+	return path == e.dest || strings.HasPrefix(path, e.dest+string(os.PathSeparator))
+}
+
+// entryPath returns the path of the entry, the parent directories are created and resolved, so an entry cannot be
+// written through a symlink pointing outside of the destination
+func (e *archiveExtractor) entryPath(name string) (string, error) {
+	if err := e.countEntry(); err != nil {
+		return "", err
+	}
+	if err := validateEntryName(name); err != nil {
+		return "", err
+	}
+	path := filepath.Join(e.dest, name)
+	if !e.contains(path) || path == e.dest {
+		return "", fmt.Errorf("illegal path of archive entry: %s", name)
+	}
+	parent := filepath.Dir(path)
+	if err := e.attributes.mkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	realParent, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return "", err
+	}
+	if !e.contains(realParent) {
+		return "", fmt.Errorf("archive entry %s is outside of the destination", name)
+	}
+	return filepath.Join(realParent, filepath.Base(path)), nil
+}
+
+// removeExisting removes a previously extracted file or symlink, so the new entry is not written through a symlink
+func removeExisting(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+func (e *archiveExtractor) extractDir(name string, mode fs.FileMode) error {
+	if filepath.Clean(name) == "." {
+		// the root entry of archives created with tar -C dir .
+		return e.countEntry()
+	}
+	path, err := e.entryPath(name)
+	if err != nil {
+		return err
+	}
+	if err := removeExisting(path); err != nil {
+		return err
+	}
+	if err := e.attributes.mkdirAll(path, 0755); err != nil {
+		return err
+	}
+	if err := e.attributes.applyOwnership(path); err != nil {
+		return err
+	}
+	// applied at the end, so a read-only directory does not prevent the extraction of its content
+	e.dirModes[path] = mode.Perm()
+	return nil
+}
+
+func (e *archiveExtractor) extractFile(name string, mode fs.FileMode, content io.Reader) error {
+	path, err := e.entryPath(name)
+	if err != nil {
+		return err
+	}
+	if err := removeExisting(path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if err := e.attributes.applyOwnership(path); err != nil {
+		closeIt(f)
+		return err
+	}
+	// the declared sizes of the entries cannot be trusted, the written bytes are counted
+	remaining := e.maxSize - e.written
+	n, err := io.Copy(f, io.LimitReader(content, remaining+1))
+	e.written += n
+	if err == nil && n > remaining {
+		err = fmt.Errorf("archive exceeds the extracted size limit of %d bytes", e.maxSize)
+	}
+	if err != nil {
+		closeIt(f)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(path, mode.Perm())
+}
+
+// resolvePath resolves the target relative to the directory the way the kernel does, following every symlink on the
+// way, so a target like link/../.. is resolved from where the link points and not lexically. The missing part of the
+// path is kept as it is, but a parent reference after it cannot be resolved.
+func resolvePath(dir string, target string) (string, error) {
+	resolved := dir
+	pending := strings.Split(target, "/")
+	missing := false
+	hops := 0
+	if filepath.IsAbs(target) {
+		resolved = "/"
+	}
+	for len(pending) > 0 {
+		element := pending[0]
+		pending = pending[1:]
+		if len(element) == 0 || element == "." {
+			continue
+		}
+		if element == ".." {
+			if missing {
+				return "", fmt.Errorf("unable to resolve %s, it refers to the parent of a missing path", target)
+			}
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, element)
+		if missing {
+			resolved = next
+			continue
+		}
+		info, err := os.Lstat(next)
+		if os.IsNotExist(err) {
+			missing = true
+			resolved = next
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if hops++; hops > maxSymlinkHops {
+			return "", fmt.Errorf("unable to resolve %s, too many levels of symbolic links", target)
+		}
+		link, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			resolved = "/"
+		}
+		pending = append(strings.Split(link, "/"), pending...)
+	}
+	return resolved, nil
+}
+
+// checkSymlink checks where the symlink points on the filesystem, as a chain of symlinks can escape the destination
+// even if each target looks contained
+func (e *archiveExtractor) checkSymlink(name string, path string, target string) error {
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("symlink %s points outside of the destination: %s", name, target)
+	}
+	resolved, err := resolvePath(filepath.Dir(path), target)
+	if err != nil {
+		return fmt.Errorf("symlink %s cannot be resolved: %s", name, err.Error())
+	}
+	if !e.contains(resolved) {
+		return fmt.Errorf("symlink %s points outside of the destination: %s", name, target)
+	}
+	return nil
+}
+
+func (e *archiveExtractor) extractSymlink(name string, target string) error {
+	path, err := e.entryPath(name)
+	if err != nil {
+		return err
+	}
+	if err := e.checkSymlink(name, path, target); err != nil {
+		return err
+	}
+	if err := removeExisting(path); err != nil {
+		return err
+	}
+	if err := os.Symlink(target, path); err != nil {
+		return err
+	}
+	e.symlinks[path] = target
+	return e.attributes.applyLinkOwnership(path)
+}
+
+func (e *archiveExtractor) extractHardlink(name string, target string) error {
+	path, err := e.entryPath(name)
+	if err != nil {
+		return err
+	}
+	if err := validateEntryName(target); err != nil {
+		return fmt.Errorf("hardlink %s points outside of the destination: %s", name, target)
+	}
+	source, err := filepath.EvalSymlinks(filepath.Join(e.dest, target))
+	if err != nil {
+		return fmt.Errorf("target of hardlink %s does not exist: %s", name, target)
+	}
+	if !e.contains(source) {
+		return fmt.Errorf("hardlink %s points outside of the destination: %s", name, target)
+	}
+	if info, err := os.Lstat(source); err != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("target of hardlink %s is not a regular file: %s", name, target)
+	}
+	if err := removeExisting(path); err != nil {
+		return err
+	}
+	return os.Link(source, path)
+}
+
+func (e *archiveExtractor) finish() error {
+	for path, target := range e.symlinks {
+		if err := e.checkSymlink(strings.TrimPrefix(path, e.dest+string(os.PathSeparator)), path, target); err != nil {
+			// the symlink is not left behind pointing outside of the destination
+			if removeErr := os.Remove(path); removeErr != nil {
+				log.Printf("[finish] [ERROR] unable to remove symlink %s: %s", path, removeErr.Error())
+			}
+			return err
+		}
+	}
+	for path, mode := range e.dirModes {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZip(file io.ReaderAt, size int64, dest string, attributes *uploadAttributes) error {
+	r, err := zip.NewReader(file, size)
+	if err != nil {
+		return err
+	}
+	e, err := newArchiveExtractor(dest, size, attributes)
+	if err != nil {
+		return err
+	}
+	for _, f := range r.File {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = e.extractDir(f.Name, mode)
+		case mode&fs.ModeSymlink != 0:
+			err = extractZipSymlink(e, f)
+		case mode.IsRegular():
+			err = extractZipFile(e, f)
+		default:
+			log.Printf("[extractZip] skip unsupported entry %s: %s", f.Name, mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return e.finish()
+}
+
+func extractZipFile(e *archiveExtractor, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer closeIt(rc)
+	return e.extractFile(f.Name, f.Mode(), rc)
+}
+
+func extractZipSymlink(e *archiveExtractor, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer closeIt(rc)
+	target, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	return e.extractSymlink(f.Name, string(target))
+}
+
+func extractTar(r io.Reader, archiveSize int64, dest string, attributes *uploadAttributes) error {
+	e, err := newArchiveExtractor(dest, archiveSize, attributes)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = e.extractDir(hdr.Name, hdr.FileInfo().Mode())
+		case tar.TypeReg:
+			err = e.extractFile(hdr.Name, hdr.FileInfo().Mode(), tr)
+		case tar.TypeSymlink:
+			err = e.extractSymlink(hdr.Name, hdr.Linkname)
+		case tar.TypeLink:
+			err = e.extractHardlink(hdr.Name, hdr.Linkname)
+		default:
+			log.Printf("[extractTar] skip unsupported entry %s: %c", hdr.Name, hdr.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return e.finish()
+}
+
+// extractArchive streams the archive into the destination directory
+func extractArchive(archiveType string, file io.ReaderAt, size int64, dest string, attributes *uploadAttributes) error {
+	log.Printf("[extractArchive] extract %s archive to %s", archiveType, dest)
+	if archiveType == ARCHIVE_ZIP {
+		return extractZip(file, size, dest, attributes)
+	}
+	r, closeReader, err := decompressor(archiveType, io.NewSectionReader(file, 0, size))
+	if err != nil {
+		return err
+	}
+	defer closeReader()
+	return extractTar(r, size, dest, attributes)
+}
@@ -0,0 +1,231 @@
+package saltboot
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	content  string
+	linkname string
+}
+
+func buildTar(entries []tarEntry) []byte {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, entry := range entries {
+		tw.WriteHeader(&tar.Header{Name: entry.name, Typeflag: entry.typeflag, Mode: entry.mode, Size: int64(len(entry.content)), Linkname: entry.linkname, Format: tar.FormatPAX})
+		tw.Write([]byte(entry.content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func compressArchive(archiveType string, content []byte) []byte {
+	buf := new(bytes.Buffer)
+	var w io.WriteCloser
+	switch archiveType {
+	case ARCHIVE_TAR_GZ:
+		w = gzip.NewWriter(buf)
+	case ARCHIVE_TAR_ZSTD:
+		w, _ = zstd.NewWriter(buf)
+	default:
+		return content
+	}
+	w.Write(content)
+	w.Close()
+	return buf.Bytes()
+}
+
+var testTarEntries = []tarEntry{
+	{name: "salt/", typeflag: tar.TypeDir, mode: 0750},
+	{name: "salt/top.sls", typeflag: tar.TypeReg, mode: 0640, content: "base"},
+	{name: "salt/bin/run.sh", typeflag: tar.TypeReg, mode: 0755, content: "#!/bin/sh"},
+	{name: "salt/current", typeflag: tar.TypeSymlink, linkname: "top.sls"},
+}
+
+func TestDetectArchiveType(t *testing.T) {
+	zipBuf := new(bytes.Buffer)
+	zw := zip.NewWriter(zipBuf)
+	zw.Create("test.txt")
+	zw.Close()
+	tarContent := buildTar(testTarEntries)
+
+	for expected, content := range map[string][]byte{
+		ARCHIVE_ZIP:      zipBuf.Bytes(),
+		ARCHIVE_TAR:      tarContent,
+		ARCHIVE_TAR_GZ:   compressArchive(ARCHIVE_TAR_GZ, tarContent),
+		ARCHIVE_TAR_ZSTD: compressArchive(ARCHIVE_TAR_ZSTD, tarContent),
+		"":               compressArchive(ARCHIVE_TAR_GZ, []byte("plain compressed file")),
+	} {
+		archiveType, err := detectArchiveType(bytes.NewReader(content), int64(len(content)))
+		if err != nil || archiveType != expected {
+			t.Errorf("archive type not match %s == %s, %v", expected, archiveType, err)
+		}
+	}
+	if archiveType, _ := detectArchiveType(bytes.NewReader([]byte("text")), 4); archiveType != "" {
+		t.Errorf("text is not expected to be an archive: %s", archiveType)
+	}
+}
+
+func TestExtractTarArchives(t *testing.T) {
+	for _, archiveType := range []string{ARCHIVE_TAR, ARCHIVE_TAR_GZ, ARCHIVE_TAR_ZSTD} {
+		dest, _ := os.MkdirTemp("", "archivetest")
+		defer os.RemoveAll(dest)
+		content := compressArchive(archiveType, buildTar(testTarEntries))
+
+		if err := extractArchive(archiveType, bytes.NewReader(content), int64(len(content)), dest, nil); err != nil {
+			t.Fatalf("unable to extract %s: %s", archiveType, err)
+		}
+
+		for file, mode := range map[string]os.FileMode{"salt": 0750, "salt/top.sls": 0640, "salt/bin/run.sh": 0755} {
+			if info, err := os.Stat(filepath.Join(dest, file)); err != nil || info.Mode().Perm() != mode {
+				t.Errorf("mode of %s in %s not match %s, %v", file, archiveType, mode, err)
+			}
+		}
+		if target, _ := os.Readlink(filepath.Join(dest, "salt/current")); target != "top.sls" {
+			t.Errorf("symlink in %s not match: %s", archiveType, target)
+		}
+	}
+}
+
+func TestExtractTarSymlinkOutsideOfDestination(t *testing.T) {
+	dest, _ := os.MkdirTemp("", "archivetest")
+	defer os.RemoveAll(dest)
+	content := buildTar([]tarEntry{{name: "passwd", typeflag: tar.TypeSymlink, linkname: "../../etc/passwd"}})
+
+	if err := extractArchive(ARCHIVE_TAR, bytes.NewReader(content), int64(len(content)), dest, nil); err == nil {
+		t.Error("symlink pointing outside of the destination is expected to be rejected")
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "passwd")); !os.IsNotExist(err) {
+		t.Error("symlink is not expected to be created")
+	}
+}
+
+func TestUploadTarGz(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "archivetest")
+	defer os.RemoveAll(tempDirName)
+	content := compressArchive(ARCHIVE_TAR_GZ, buildTar(testTarEntries))
+	writer := httptest.NewRecorder()
+
+	FileUploadHandler(writer, newUploadRequest(tempDirName, "bundle.tgz", content, ""))
+
+	if writer.Code != 201 {
+		t.Errorf("Wrong status code %d == %d", 201, writer.Code)
+	}
+	if b, _ := os.ReadFile(filepath.Join(tempDirName, "salt/top.sls")); string(b) != "base" {
+		t.Errorf("extracted content not match: %s", b)
+	}
+	if _, err := os.Stat(filepath.Join(tempDirName, "bundle.tgz")); !os.IsNotExist(err) {
+		t.Error("archive is not expected to be written")
+	}
+}
+
+func buildZip(entries map[string]string, symlinks map[string]string) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for name, content := range entries {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	for name, target := range symlinks {
+		header := &zip.FileHeader{Name: name}
+		header.SetMode(os.ModeSymlink | 0777)
+		w, _ := zw.CreateHeader(header)
+		w.Write([]byte(target))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestExtractMaliciousArchives(t *testing.T) {
+	bomb := make([]byte, 10<<20)
+	for name, archive := range map[string]struct {
+		archiveType string
+		content     []byte
+	}{
+		"zip traversal":          {ARCHIVE_ZIP, buildZip(map[string]string{"../evil.txt": "evil"}, nil)},
+		"zip absolute path":      {ARCHIVE_ZIP, buildZip(map[string]string{"/tmp/evil.txt": "evil"}, nil)},
+		"zip symlink outside":    {ARCHIVE_ZIP, buildZip(nil, map[string]string{"evil": "/etc/passwd"})},
+		"tar traversal":          {ARCHIVE_TAR, buildTar([]tarEntry{{name: "salt/../../evil.txt", typeflag: tar.TypeReg, mode: 0644, content: "evil"}})},
+		"tar absolute path":      {ARCHIVE_TAR, buildTar([]tarEntry{{name: "/tmp/evil.txt", typeflag: tar.TypeReg, mode: 0644, content: "evil"}})},
+		"tar absolute symlink":   {ARCHIVE_TAR, buildTar([]tarEntry{{name: "evil", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}})},
+		"tar hardlink outside":   {ARCHIVE_TAR, buildTar([]tarEntry{{name: "evil", typeflag: tar.TypeLink, linkname: "../../etc/passwd"}})},
+		"tar hardlink absolute":  {ARCHIVE_TAR, buildTar([]tarEntry{{name: "evil", typeflag: tar.TypeLink, linkname: "/etc/passwd"}})},
+		"tar write through link": {ARCHIVE_TAR, buildTar([]tarEntry{{name: "dir", typeflag: tar.TypeSymlink, linkname: "."}, {name: "dir/evil", typeflag: tar.TypeSymlink, linkname: ".."}})},
+		"tar symlink chain": {ARCHIVE_TAR, buildTar([]tarEntry{{name: "a/b/s2", typeflag: tar.TypeSymlink, linkname: "../.."},
+			{name: "a/b/s3", typeflag: tar.TypeSymlink, linkname: "s2/../../secret"}})},
+		"tar symlink chain retargeted": {ARCHIVE_TAR, buildTar([]tarEntry{{name: "a/b/s2", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "a/b/s3", typeflag: tar.TypeSymlink, linkname: "s2/../../secret"}, {name: "a/b/s2", typeflag: tar.TypeSymlink, linkname: "../.."}})},
+		"tar.gz compression bomb": {ARCHIVE_TAR_GZ, compressArchive(ARCHIVE_TAR_GZ, buildTar([]tarEntry{{name: "zeros", typeflag: tar.TypeReg, mode: 0644, content: string(bomb)}}))},
+	} {
+		parent, _ := os.MkdirTemp("", "archivetest")
+		defer os.RemoveAll(parent)
+		dest := filepath.Join(parent, "a", "b")
+
+		err := extractArchive(archive.archiveType, bytes.NewReader(archive.content), int64(len(archive.content)), dest, nil)
+
+		if err == nil {
+			t.Errorf("%s is expected to be rejected", name)
+		}
+		filepath.Walk(parent, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && !strings.HasPrefix(path, dest) {
+				t.Errorf("%s created a file outside of the destination: %s", name, path)
+			}
+			if err == nil && info.Mode()&os.ModeSymlink != 0 {
+				if target, _ := os.Readlink(path); strings.Contains(target, "secret") {
+					t.Errorf("%s left a symlink behind pointing outside of the destination: %s", name, path)
+				}
+			}
+			return nil
+		})
+	}
+}
+
+func TestExtractHardlinkInsideDestination(t *testing.T) {
+	dest, _ := os.MkdirTemp("", "archivetest")
+	defer os.RemoveAll(dest)
+	content := buildTar([]tarEntry{
+		{name: "salt/top.sls", typeflag: tar.TypeReg, mode: 0644, content: "base"},
+		{name: "top.sls", typeflag: tar.TypeLink, linkname: "salt/top.sls"},
+	})
+
+	if err := extractArchive(ARCHIVE_TAR, bytes.NewReader(content), int64(len(content)), dest, nil); err != nil {
+		t.Fatalf("unable to extract hardlink: %s", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dest, "top.sls")); string(b) != "base" {
+		t.Errorf("hardlink content not match: %s", b)
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	content := buildTar([]tarEntry{
+		{name: "a", typeflag: tar.TypeReg, mode: 0644, content: "aaaa"},
+		{name: "b", typeflag: tar.TypeReg, mode: 0644, content: "bbbb"},
+		{name: "c", typeflag: tar.TypeReg, mode: 0644, content: "cccc"},
+	})
+	for key, value := range map[string]string{archiveMaxEntriesKey: "2", archiveMaxSizeKey: "10"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			dest, _ := os.MkdirTemp("", "archivetest")
+			defer os.RemoveAll(dest)
+
+			if err := extractArchive(ARCHIVE_TAR, bytes.NewReader(content), int64(len(content)), dest, nil); err == nil {
+				t.Errorf("%s=%s is expected to be enforced", key, value)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package saltboot
 import (
 	"bytes"
 	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
@@ -130,6 +131,23 @@ func CheckSignature(rawSign string, pubPem []byte, data []byte) bool {
 	return false
 }
 
+// signPayload signs the data the same way as the orchestrator, so the signature can be checked with CheckSignature
+func signPayload(privateKeyPem []byte, data []byte) (string, error) {
+	privateKey, err := parseRsaPrivateKey(privateKeyPem)
+	if err != nil {
+		return "", err
+	}
+	newHash := crypto.SHA256.New()
+	if _, err := newHash.Write(data); err != nil {
+		return "", err
+	}
+	sign, err := rsa.SignPSS(rand.Reader, privateKey, crypto.SHA256, newHash.Sum(nil), &rsa.PSSOptions{SaltLength: 20})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sign), nil
+}
+
 func GetAuthUserPass(r *http.Request) (string, string) {
 	s := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
 	if len(s) != 2 || s[0] != "Basic" {
@@ -0,0 +1,202 @@
+package saltboot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+const (
+	DECOMMISSION_ACTION = "decommission"
+	MINION_PKI_DIR      = "/etc/salt/pki/minion"
+
+	STEP_DONE    = "done"
+	STEP_SKIPPED = "skipped"
+	STEP_FAILED  = "failed"
+)
+
+type DecommissionStep struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Target    string `json:"target,omitempty"`
+	ErrorText string `json:"errorText,omitempty"`
+}
+
+func (s DecommissionStep) String() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func newDecommissionStep(name string, target string, err error) DecommissionStep {
+	step := DecommissionStep{Name: name, Target: target, Status: STEP_DONE}
+	if err != nil {
+		step.Status = STEP_FAILED
+		step.ErrorText = err.Error()
+	}
+	log.Printf("[newDecommissionStep] %s", step.String())
+	return step
+}
+
+func decommissionStepsFailed(steps []DecommissionStep) bool {
+	for _, step := range steps {
+		if step.Status == STEP_FAILED {
+			return true
+		}
+	}
+	return false
+}
+
+func writeDecommissionReport(w http.ResponseWriter, steps []DecommissionStep) {
+	if decommissionStepsFailed(steps) {
+		model.Response{ErrorText: "decommission failed", StatusCode: http.StatusInternalServerError, Payload: steps}.WriteHttp(w)
+		return
+	}
+	model.Response{Status: "decommissioned", Payload: steps}.WriteHttp(w)
+}
+
+// minionId returns the salt id of the minion: the configured id or the fqdn of the host
+func (saltMinion SaltMinion) minionId() string {
+	if saltMinion.Config != nil && len(saltMinion.Config.Id) > 0 {
+		return saltMinion.Config.Id
+	}
+	if saltMinion.Hostname != nil && len(*saltMinion.Hostname) > 0 {
+		if len(saltMinion.Domain) > 0 {
+			return constructFQDN(*saltMinion.Hostname, saltMinion.Domain)
+		}
+		return *saltMinion.Hostname
+	}
+	return ""
+}
+
+// managedMinionConfigs lists the minion.d files written by saltboot
+func managedMinionConfigs(configDir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(configDir, managedConfigPrefix+"*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	return append(files, filepath.Join(configDir, "master.conf")), nil
+}
+
+func removeAll(paths ...string) error {
+	for _, path := range paths {
+		log.Printf("[removeAll] remove: %s", path)
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decommissionMinionImpl(stopService func(string) (model.Response, error), baseDir string) (steps []DecommissionStep) {
+	_, err := stopService("salt-minion")
+	steps = append(steps, newDecommissionStep("stop-salt-minion", "", err))
+	if err != nil {
+		for _, name := range []string{"remove-pki", "remove-grains", "remove-minion-config"} {
+			steps = append(steps, DecommissionStep{Name: name, Status: STEP_SKIPPED})
+		}
+		return steps
+	}
+
+	steps = append(steps, newDecommissionStep("remove-pki", "", removeAll(baseDir+MINION_PKI_DIR)))
+	steps = append(steps, newDecommissionStep("remove-grains", "", removeAll(baseDir+GRAINS_FILE, baseDir+GRAINS_MANAGED_KEYS_FILE)))
+	configs, err := managedMinionConfigs(baseDir + MINION_CONFIG_DIR)
+	if err == nil {
+		err = removeAll(configs...)
+	}
+	steps = append(steps, newDecommissionStep("remove-minion-config", "", err))
+	return steps
+}
+
+func SaltMinionDecommissionRequestHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMinionDecommissionRequestHandler] execute salt-minion decommission request")
+	steps := decommissionMinionImpl(StopService, req.Header.Get("salt-minion-base-dir"))
+	writeDecommissionReport(w, steps)
+}
+
+func decommissionMinionKeys(pkiDir string, minions []SaltMinion) (steps []DecommissionStep) {
+	for _, minion := range minions {
+		id := minion.minionId()
+		if len(id) == 0 {
+			err := fmt.Errorf("minion id of %s is unknown", minion.Address)
+			steps = append(steps, newDecommissionStep("delete-minion-key", minion.Address, err))
+			continue
+		}
+		result := deleteMinionKey(pkiDir, id)
+		if result.Outcome == KEY_NOT_FOUND {
+			log.Printf("[decommissionMinionKeys] no key found for minion: %s", id)
+			steps = append(steps, DecommissionStep{Name: "delete-minion-key", Target: id, Status: STEP_SKIPPED})
+			continue
+		}
+		var err error
+		if result.Outcome == KEY_FAILED {
+			err = errors.New(result.ErrorText)
+		}
+		steps = append(steps, newDecommissionStep("delete-minion-key", id, err))
+	}
+	return steps
+}
+
+func SaltServerDecommissionRequestHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltServerDecommissionRequestHandler] execute salt master decommission request")
+
+	decoder := json.NewDecoder(req.Body)
+	var saltActionRequest SaltActionRequest
+	if err := decoder.Decode(&saltActionRequest); err != nil {
+		log.Printf("[SaltServerDecommissionRequestHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+
+	pkiDir := req.Header.Get("salt-master-base-dir") + MASTER_PKI_DIR
+	writeDecommissionReport(w, decommissionMinionKeys(pkiDir, saltActionRequest.Minions))
+}
+
+// removeHostsEntries removes the hosts entries written by saltboot for the given addresses, entries without the
+// saltboot marker are kept
+func removeHostsEntries(file string, addresses []string) (removed int, err error) {
+	b, err := readFile(file)
+	if err != nil {
+		return 0, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && containsString(addresses, fields[0]) && strings.HasSuffix(strings.TrimSpace(line), HOSTS_ENTRY_MARKER) {
+			log.Printf("[removeHostsEntries] remove hosts entry: %s", line)
+			removed++
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, writeFile(file, []byte(strings.Join(lines, "\n")), 0644)
+}
+
+func HostsCleanupRequestHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[HostsCleanupRequestHandler] execute hosts cleanup request")
+
+	decoder := json.NewDecoder(req.Body)
+	var saltActionRequest SaltActionRequest
+	if err := decoder.Decode(&saltActionRequest); err != nil {
+		log.Printf("[HostsCleanupRequestHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+
+	var addresses []string
+	for _, minion := range saltActionRequest.Minions {
+		addresses = append(addresses, minion.Address)
+	}
+	removed, err := removeHostsEntries(HOSTS_FILE, addresses)
+	log.Printf("[HostsCleanupRequestHandler] removed %d hosts entries", removed)
+	writeDecommissionReport(w, []DecommissionStep{newDecommissionStep("remove-hosts-entries", HOSTS_FILE, err)})
+}
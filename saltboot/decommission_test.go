@@ -0,0 +1,159 @@
+package saltboot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+func TestDecommissionMinion(t *testing.T) {
+	baseDir, _ := os.MkdirTemp("", "decommissiontest")
+	defer os.RemoveAll(baseDir)
+	pair, _ := generateMinionKeyPair(defaultMinionKeySize)
+	installTestMinionKey(baseDir, pair)
+	os.WriteFile(baseDir+GRAINS_FILE, []byte("roles: [ambari_agent]"), 0644)
+	os.MkdirAll(baseDir+MINION_CONFIG_DIR, 0755)
+	writeMinionConfig(MinionConfig{Id: "minion", LogLevel: "info"}, baseDir+MINION_CONFIG_DIR)
+	os.WriteFile(baseDir+MINION_CONFIG_DIR+"/master.conf", []byte("master: [10.0.0.1]"), 0644)
+	os.WriteFile(baseDir+MINION_CONFIG_DIR+"/custom.conf", []byte("custom: true"), 0644)
+
+	steps := decommissionMinionImpl(func(string) (model.Response, error) { return model.Response{}, nil }, baseDir)
+
+	if len(steps) != 4 || decommissionStepsFailed(steps) {
+		t.Fatalf("all steps are expected to be done: %v", steps)
+	}
+	for _, removed := range []string{baseDir + MINION_PKI_DIR, baseDir + GRAINS_FILE, baseDir + MINION_CONFIG_DIR + "/master.conf",
+		baseDir + MINION_CONFIG_DIR + "/saltboot-id.conf"} {
+		if _, err := os.Stat(removed); !os.IsNotExist(err) {
+			t.Errorf("%s is expected to be removed", removed)
+		}
+	}
+	if _, err := os.Stat(baseDir + MINION_CONFIG_DIR + "/custom.conf"); err != nil {
+		t.Error("unmanaged config is expected to be kept")
+	}
+}
+
+func TestDecommissionMinionStopFailure(t *testing.T) {
+	baseDir, _ := os.MkdirTemp("", "decommissiontest")
+	defer os.RemoveAll(baseDir)
+	os.MkdirAll(baseDir+MINION_PKI_DIR, 0700)
+
+	steps := decommissionMinionImpl(func(string) (model.Response, error) { return model.Response{}, errors.New("failed") }, baseDir)
+
+	if steps[0].Status != STEP_FAILED || steps[1].Status != STEP_SKIPPED {
+		t.Errorf("steps after a failed stop are expected to be skipped: %v", steps)
+	}
+	if _, err := os.Stat(baseDir + MINION_PKI_DIR); err != nil {
+		t.Error("pki is not expected to be removed if the minion is not stopped")
+	}
+}
+
+func TestDecommissionMinionKeys(t *testing.T) {
+	pkiDir, _ := os.MkdirTemp("", "decommissiontest")
+	defer os.RemoveAll(pkiDir)
+	writeMinionKey(t, pkiDir, MINIONS_ACCEPTED_DIR, "node1.example.com")
+	writeMinionKey(t, pkiDir, MINIONS_ACCEPTED_DIR, "custom")
+	hostname := "node1"
+
+	steps := decommissionMinionKeys(pkiDir, []SaltMinion{
+		{Address: "10.0.0.1", Hostname: &hostname, Domain: "example.com"},
+		{Address: "10.0.0.2", Config: &MinionConfig{Id: "custom"}},
+		{Address: "10.0.0.3", Config: &MinionConfig{Id: "missing"}},
+		{Address: "10.0.0.4"},
+	})
+
+	expected := []string{STEP_DONE, STEP_DONE, STEP_SKIPPED, STEP_FAILED}
+	for i, status := range expected {
+		if steps[i].Status != status {
+			t.Errorf("status not match %s == %s", status, steps[i].String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(pkiDir, MINIONS_ACCEPTED_DIR, "node1.example.com")); !os.IsNotExist(err) {
+		t.Error("minion key is expected to be deleted")
+	}
+}
+
+func TestRemoveHostsEntries(t *testing.T) {
+	readFile = func(filename string) ([]byte, error) {
+		return []byte("127.0.0.1 localhost\n10.0.0.1 node1.example.com node1 # saltboot\n10.0.0.1 node1.custom.example\n" +
+			"10.0.0.11 node11.example.com node11 # saltboot\n"), nil
+	}
+	defer func() {
+		readFile = emptyReadFile
+	}()
+
+	var result string
+	writeFile = func(filename string, data []byte, perm os.FileMode) error {
+		result = string(data)
+		return nil
+	}
+	defer func() {
+		writeFile = emptyWriteFile
+	}()
+
+	removed, err := removeHostsEntries("hosts", []string{"10.0.0.1"})
+
+	expected := "127.0.0.1 localhost\n10.0.0.1 node1.custom.example\n10.0.0.11 node11.example.com node11 # saltboot\n"
+	if err != nil || removed != 1 || result != expected {
+		t.Errorf("hosts file not match %s == %s (%d, %v)", expected, result, removed, err)
+	}
+}
+
+func TestRemoveHostsEntriesOfServers(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "decommissiontest")
+	defer os.RemoveAll(tempDirName)
+	hosts := filepath.Join(tempDirName, "hosts")
+	os.WriteFile(hosts, []byte("127.0.0.1 localhost"), 0644)
+	servers := Servers{Path: hosts, Servers: []Server{{Name: "node1.example.com", Address: "10.0.0.1"}, {Name: "node2.example.com", Address: "10.0.0.2"}}}
+	if _, err := servers.WriteToFile(); err != nil {
+		t.Fatalf("unable to write servers: %s", err)
+	}
+	readFile = os.ReadFile
+	writeFile = WriteFile
+	defer func() {
+		readFile = emptyReadFile
+		writeFile = emptyWriteFile
+	}()
+
+	removed, err := removeHostsEntries(hosts, []string{"10.0.0.1"})
+
+	content, _ := os.ReadFile(hosts)
+	expected := "127.0.0.1 localhost\n10.0.0.2 node2.example.com # saltboot"
+	if err != nil || removed != 1 || string(content) != expected {
+		t.Errorf("hosts file not match %s == %s (%d, %v)", expected, content, removed, err)
+	}
+}
+
+func TestDistributeActionImplDecommissionPeers(t *testing.T) {
+	var endpoints []string
+	distributeRequest := func(clients []string, endpoint string, user string, pass string, requestBody RequestBody) <-chan model.Response {
+		endpoints = append(endpoints, endpoint)
+		c := make(chan model.Response, len(clients))
+		for _, client := range clients {
+			c <- model.Response{StatusCode: 200, Address: client}
+		}
+		close(c)
+		return c
+	}
+	request := SaltActionRequest{
+		Action:  "DECOMMISSION",
+		Minions: []SaltMinion{{Address: "10.0.0.1"}},
+		Masters: []SaltMaster{{Address: "10.0.0.2"}},
+		Peers:   []string{"10.0.0.3", "10.0.0.4"},
+	}
+
+	resp := distributeActionImpl(distributeRequest, request, "user", "pass", RequestBody{})
+
+	expected := []string{SaltMinionDecommissionEP, SaltServerDecommissionEP, HostsCleanupEP}
+	if len(resp) != 4 || len(endpoints) != len(expected) {
+		t.Fatalf("unexpected responses: %v %v", resp, endpoints)
+	}
+	for i, endpoint := range expected {
+		if endpoints[i] != endpoint {
+			t.Errorf("endpoint not match %s == %s", endpoint, endpoints[i])
+		}
+	}
+}
@@ -83,7 +83,11 @@ func DistributeRequest(clients []string, endpoint, user, pass string, requestBod
 			if len(requestBody.Signature) > 0 {
 				indexString := strconv.Itoa(index)
 				log.Printf("[DistributeRequest] Send signed request to client: %s with index: %s", client, indexString)
-				req, _ = http.NewRequest("POST", protocol+clientAddr+endpoint+"?index="+indexString, bytes.NewBufferString(requestBody.SignedPayload))
+				separator := "?"
+				if strings.Contains(endpoint, "?") {
+					separator = "&"
+				}
+				req, _ = http.NewRequest("POST", protocol+clientAddr+endpoint+separator+"index="+indexString, bytes.NewBufferString(requestBody.SignedPayload))
 				req.Header.Set(SIGNATURE, requestBody.Signature)
 			} else {
 				log.Printf("[DistributeRequest] Send plain request to client: %s", client)
@@ -123,8 +127,85 @@ func DistributeRequest(clients []string, endpoint, user, pass string, requestBod
 	return c
 }
 
-func DistributeFileUploadRequest(endpoint string, user string, pass string, targets []string, path string,
-	permissions string, file multipart.File, header *multipart.FileHeader, signature string) <-chan model.Response {
+// distributeToTargetsImpl sends the same request to every target and collects the responses
+func distributeToTargetsImpl(distributeRequest func([]string, string, string, string, RequestBody) <-chan model.Response,
+	targets []string, endpoint string, user string, pass string, requestBody RequestBody) (result []model.Response) {
+	log.Printf("[distributeToTargetsImpl] send request to %s on targets: %s", endpoint, targets)
+	for res := range distributeRequest(targets, endpoint, user, pass, requestBody) {
+		result = append(result, res)
+	}
+	return result
+}
+
+// DistributeTargetedRequest sends each target its own request, so a target never receives the payload of another one.
+// The address of the responses is the target as it was requested. Requests carrying key material must not fall back
+// to HTTP.
+func DistributeTargetedRequest(requests map[string]RequestBody, endpoint, user, pass string, allowFallback bool) <-chan model.Response {
+	httpsEnabled := HttpsEnabled()
+	protocol := determineProtocol(httpsEnabled)
+	var wg sync.WaitGroup
+	wg.Add(len(requests))
+	c := make(chan model.Response, len(requests))
+
+	for target, requestBody := range requests {
+		go func(target string, requestBody RequestBody) {
+			defer wg.Done()
+
+			var targetAddress string
+			if strings.Contains(target, ":") {
+				targetAddress = target
+			} else {
+				targetAddress = target + ":" + strconv.Itoa(DetermineBootstrapPort(httpsEnabled))
+			}
+
+			var req *http.Request
+			if len(requestBody.Signature) > 0 {
+				log.Printf("[DistributeTargetedRequest] Send signed request to target: %s", target)
+				req, _ = http.NewRequest("POST", protocol+targetAddress+endpoint, bytes.NewBufferString(requestBody.SignedPayload))
+				req.Header.Set(SIGNATURE, requestBody.Signature)
+			} else {
+				log.Printf("[DistributeTargetedRequest] Send plain request to target: %s", target)
+				req, _ = http.NewRequest("POST", protocol+targetAddress+endpoint, bytes.NewBuffer(requestBody.PlainPayload))
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.SetBasicAuth(user, pass)
+
+			httpClient := getHttpClient(httpsEnabled)
+			var resp *http.Response
+			var err error
+			if allowFallback {
+				_, resp, err = sendRequestWithFallback(httpClient, req, httpsEnabled)
+			} else {
+				resp, err = httpClient.Do(req)
+			}
+			if err != nil {
+				log.Printf("[DistributeTargetedRequest] [ERROR] Failed to send request to: %s, error: %s", target, err.Error())
+				c <- model.Response{StatusCode: http.StatusInternalServerError, ErrorText: err.Error(), Address: target}
+				return
+			}
+			defer closeIt(resp.Body)
+
+			var response model.Response
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				log.Printf("[DistributeTargetedRequest] [ERROR] Failed to decode response, error: %s", err.Error())
+			}
+			response.Address = target
+			if response.StatusCode == 0 {
+				response.StatusCode = resp.StatusCode
+			}
+			log.Printf("[DistributeTargetedRequest] Request to: %s result: %s", target, response.String())
+			c <- response
+		}(target, requestBody)
+	}
+
+	wg.Wait()
+	close(c)
+
+	return c
+}
+
+func DistributeFileUploadRequest(endpoint string, user string, pass string, targets []string, options FileUploadOptions,
+	file multipart.File, header *multipart.FileHeader, signature string) <-chan model.Response {
 
 	httpsEnabled := HttpsEnabled()
 	protocol := determineProtocol(httpsEnabled)
@@ -134,8 +215,7 @@ func DistributeFileUploadRequest(endpoint string, user string, pass string, targ
 
 	bodyBuf := &bytes.Buffer{}
 	bodyWriter := multipart.NewWriter(bodyBuf)
-	_ = bodyWriter.WriteField("path", path)
-	_ = bodyWriter.WriteField("permissions", permissions)
+	_ = options.writeFields(bodyWriter)
 
 	fileWriter, err := bodyWriter.CreateFormFile("file", header.Filename)
 	if err != nil {
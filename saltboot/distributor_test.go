@@ -3,12 +3,14 @@ package saltboot
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"mime/multipart"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strconv"
+	"sync/atomic"
 	"testing"
 )
 
@@ -121,6 +123,84 @@ func TestDistributeRequest_WithSignature(t *testing.T) {
 	}
 }
 
+func TestDistributeRequest_SignedWithQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("hash_type") != "md5" || r.URL.Query().Get("index") != "0" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"statusCode": http.StatusOK})
+	}))
+	defer server.Close()
+
+	reqBody := RequestBody{SignedPayload: `{"key": "value"}`, Signature: "test-signature"}
+	for res := range DistributeRequest([]string{server.Listener.Addr().String()}, "/test-endpoint?hash_type=md5", "user", "pass", reqBody) {
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("index is expected to be appended to the query: %s", res.String())
+		}
+	}
+}
+
+func TestDistributeTargetedRequest(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SIGNATURE) != "signature-of-"+string(body) || len(r.URL.RawQuery) > 0 {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": string(body)})
+	})
+	server1 := httptest.NewServer(handler)
+	defer server1.Close()
+	server2 := httptest.NewServer(handler)
+	defer server2.Close()
+	requests := make(map[string]RequestBody)
+	for _, target := range []string{server1.Listener.Addr().String(), server2.Listener.Addr().String()} {
+		requests[target] = RequestBody{SignedPayload: target, Signature: "signature-of-" + target}
+	}
+
+	var count int
+	for res := range DistributeTargetedRequest(requests, "/test-endpoint", "user", "pass", false) {
+		count++
+		if res.StatusCode != http.StatusOK || res.Status != res.Address {
+			t.Errorf("each target is expected to receive only its own signed request: %s", res.String())
+		}
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 responses, got %d", count)
+	}
+}
+
+func TestDistributeTargetedRequest_HttpsEnabled_Fallback(t *testing.T) {
+	os.Setenv("SALTBOOT_HTTPS_ENABLED", "true")
+	defer os.Unsetenv("SALTBOOT_HTTPS_ENABLED")
+	var received int32
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"StatusCode": http.StatusOK})
+	}))
+	defer httpServer.Close()
+	os.Setenv("SALTBOOT_PORT", strconv.Itoa(httpServer.Listener.Addr().(*net.TCPAddr).Port))
+	defer os.Unsetenv("SALTBOOT_PORT")
+	targets := []string{"127.0.0.1:7071"} //Uses default HTTPS port
+	requests := map[string]RequestBody{targets[0]: {SignedPayload: `{"key": "value"}`, Signature: "test-signature"}}
+
+	for res := range DistributeTargetedRequest(requests, "/test-endpoint", "user", "pass", false) {
+		if res.StatusCode != http.StatusInternalServerError || res.Address != targets[0] {
+			t.Errorf("request is not expected to fall back to HTTP: %s", res.String())
+		}
+	}
+	if atomic.LoadInt32(&received) != 0 {
+		t.Error("request is not expected to be sent over HTTP")
+	}
+
+	for res := range DistributeTargetedRequest(requests, "/test-endpoint", "user", "pass", true) {
+		if res.StatusCode != http.StatusOK || res.Address != targets[0] {
+			t.Errorf("response is expected to be keyed by the target after the fallback: %s", res.String())
+		}
+	}
+}
+
 func TestDistributeRequest_HttpsEnabled(t *testing.T) {
 	os.Setenv("SALTBOOT_HTTPS_ENABLED", "true")
 	defer os.Unsetenv("SALTBOOT_HTTPS_ENABLED")
@@ -251,7 +331,7 @@ func TestDistributeFileUploadRequest(t *testing.T) {
 	file := &ReadSeekCloser{Reader: bytes.NewReader(sampleFileContent)}
 	header := &multipart.FileHeader{Filename: sampleFileName}
 
-	results := DistributeFileUploadRequest("/upload", "user", "pass", targets, "/path", "0644", file, header, "test-signature")
+	results := DistributeFileUploadRequest("/upload", "user", "pass", targets, FileUploadOptions{Path: "/path", Permissions: "0644"}, file, header, "test-signature")
 	var responses []map[string]interface{}
 	for res := range results {
 		responses = append(responses, map[string]interface{}{"StatusCode": res.StatusCode, "Address": res.Address})
@@ -324,7 +404,7 @@ func TestDistributeFileUploadRequest_HttpsEnabled(t *testing.T) {
 	file := &ReadSeekCloser{Reader: bytes.NewReader(sampleFileContent)}
 	header := &multipart.FileHeader{Filename: sampleFileName}
 
-	results := DistributeFileUploadRequest("/upload", "user", "pass", targets, "/path", "0644", file, header, "test-signature")
+	results := DistributeFileUploadRequest("/upload", "user", "pass", targets, FileUploadOptions{Path: "/path", Permissions: "0644"}, file, header, "test-signature")
 	var responses []map[string]interface{}
 	for res := range results {
 		responses = append(responses, map[string]interface{}{"StatusCode": res.StatusCode, "Address": res.Address})
@@ -379,7 +459,7 @@ func TestDistributeFileUploadRequest_HttpsEnabled_FallbackToHttp(t *testing.T) {
 	file := &ReadSeekCloser{Reader: bytes.NewReader(sampleFileContent)}
 	header := &multipart.FileHeader{Filename: sampleFileName}
 
-	results := DistributeFileUploadRequest("/upload", "user", "pass", targets, "/path", "0644", file, header, "test-signature")
+	results := DistributeFileUploadRequest("/upload", "user", "pass", targets, FileUploadOptions{Path: "/path", Permissions: "0644"}, file, header, "test-signature")
 	var responses []map[string]interface{}
 	for res := range results {
 		responses = append(responses, map[string]interface{}{"StatusCode": res.StatusCode, "Address": res.Address})
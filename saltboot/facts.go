@@ -0,0 +1,203 @@
+package saltboot
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+const (
+	KERNEL_RELEASE_FILE = "/proc/sys/kernel/osrelease"
+	MEMINFO_FILE        = "/proc/meminfo"
+	MINION_MASTER_CONF  = "/etc/salt/minion.d/master.conf"
+	GRAINS_FILE         = "/etc/salt/grains"
+)
+
+type FactsRequest struct {
+	Targets []string `json:"targets"`
+}
+
+type NetworkInterface struct {
+	Name         string   `json:"name"`
+	HardwareAddr string   `json:"hardwareAddr,omitempty"`
+	Up           bool     `json:"up"`
+	Addresses    []string `json:"addresses"`
+}
+
+type Facts struct {
+	Os          OsInfo             `json:"os"`
+	Kernel      string             `json:"kernel"`
+	InitSystem  string             `json:"initSystem"`
+	SaltVersion string             `json:"saltVersion,omitempty"`
+	Fqdn        string             `json:"fqdn,omitempty"`
+	Interfaces  []NetworkInterface `json:"interfaces"`
+	CpuCount    int                `json:"cpuCount"`
+	MemoryKb    uint64             `json:"memoryKb"`
+	MasterConf  string             `json:"masterConf,omitempty"`
+	Grains      string             `json:"grains,omitempty"`
+	Errors      map[string]string  `json:"errors,omitempty"`
+}
+
+func (f Facts) String() string {
+	b, _ := json.Marshal(f)
+	return string(b)
+}
+
+func (f *Facts) addError(fact string, err error) {
+	log.Printf("[collectFacts] [ERROR] unable to determine %s: %s", fact, err.Error())
+	if f.Errors == nil {
+		f.Errors = make(map[string]string)
+	}
+	f.Errors[fact] = err.Error()
+}
+
+func collectFacts(baseDir string) Facts {
+	facts := Facts{CpuCount: runtime.NumCPU(), InitSystem: GetInitSystem().Name()}
+
+	if osInfo, err := detectOsFromFiles([]string{baseDir + OS_RELEASE_FILE, baseDir + OS_RELEASE_FILE_FALLBACK}); err != nil {
+		facts.addError("os", err)
+	} else {
+		facts.Os = osInfo
+	}
+
+	if kernel, err := os.ReadFile(baseDir + KERNEL_RELEASE_FILE); err != nil {
+		facts.addError("kernel", err)
+	} else {
+		facts.Kernel = strings.TrimSpace(string(kernel))
+	}
+
+	if saltVersion, err := getSaltVersion(); err != nil {
+		facts.addError("saltVersion", err)
+	} else {
+		facts.SaltVersion = saltVersion
+	}
+
+	if fqdn, err := getFQDN(); err != nil {
+		facts.addError("fqdn", err)
+	} else {
+		facts.Fqdn = fqdn
+	}
+
+	if interfaces, err := getNetworkInterfaces(); err != nil {
+		facts.addError("interfaces", err)
+	} else {
+		facts.Interfaces = interfaces
+	}
+
+	if memory, err := readTotalMemory(baseDir + MEMINFO_FILE); err != nil {
+		facts.addError("memory", err)
+	} else {
+		facts.MemoryKb = memory
+	}
+
+	if masterConf, err := os.ReadFile(baseDir + MINION_MASTER_CONF); err == nil {
+		facts.MasterConf = string(masterConf)
+	} else if !os.IsNotExist(err) {
+		facts.addError("masterConf", err)
+	}
+
+	if grains, err := os.ReadFile(baseDir + GRAINS_FILE); err == nil {
+		facts.Grains = string(grains)
+	} else if !os.IsNotExist(err) {
+		facts.addError("grains", err)
+	}
+
+	return facts
+}
+
+func readTotalMemory(file string) (uint64, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "MemTotal:") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				break
+			}
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, errors.New("MemTotal is missing from " + file)
+}
+
+func getSaltVersion() (string, error) {
+	out, err := ExecCmd("salt-call", "--version")
+	if err != nil {
+		return "", err
+	}
+	// salt-call 3006.1 (Sulfur)
+	fields := strings.Fields(out)
+	if len(fields) >= 2 {
+		return fields[1], nil
+	}
+	return out, nil
+}
+
+func getNetworkInterfaces() ([]NetworkInterface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]NetworkInterface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		networkInterface := NetworkInterface{
+			Name:         iface.Name,
+			HardwareAddr: iface.HardwareAddr.String(),
+			Up:           iface.Flags&net.FlagUp != 0,
+			Addresses:    make([]string, 0),
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			log.Printf("[getNetworkInterfaces] [ERROR] unable to list addresses of %s: %s", iface.Name, err.Error())
+		}
+		for _, addr := range addrs {
+			networkInterface.Addresses = append(networkInterface.Addresses, addr.String())
+		}
+		result = append(result, networkInterface)
+	}
+	return result, nil
+}
+
+func NodeFactsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[NodeFactsHandler] collect node facts")
+	facts := collectFacts("")
+	log.Printf("[NodeFactsHandler] collected facts: %s", facts.String())
+	model.Response{Status: "OK", Payload: facts}.WriteHttp(w)
+}
+
+func NodeFactsDistributeHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[NodeFactsDistributeHandler] distribute request to collect node facts")
+
+	decoder := json.NewDecoder(req.Body)
+	var factsRequest FactsRequest
+	if err := decoder.Decode(&factsRequest); err != nil {
+		log.Printf("[NodeFactsDistributeHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(factsRequest.Targets) == 0 {
+		log.Printf("[NodeFactsDistributeHandler] [ERROR] no targets were specified in the request")
+		model.Response{Status: "no targets were specified in the request"}.WriteBadRequestHttp(w)
+		return
+	}
+
+	user, pass := GetAuthUserPass(req)
+	signedRequestBody := GetSignedRequestBody(req)
+
+	result := distributeToTargetsImpl(DistributeRequest, factsRequest.Targets, FactsEP, user, pass, signedRequestBody)
+	cResp := model.Responses{Responses: result}
+	log.Printf("[NodeFactsDistributeHandler] distribute facts request executed: %s", cResp.String())
+	if err := json.NewEncoder(w).Encode(cResp); err != nil {
+		log.Printf("[NodeFactsDistributeHandler] [ERROR] couldn't encode json: %s", err.Error())
+	}
+}
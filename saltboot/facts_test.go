@@ -0,0 +1,118 @@
+package saltboot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+func writeFactsFixture(t *testing.T, baseDir string, file string, content string) {
+	path := filepath.Join(baseDir, file)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("unable to create fixture dir: %s", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("unable to write fixture: %s", err)
+	}
+}
+
+func TestCollectFacts(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "factstest")
+	defer os.RemoveAll(tempDirName)
+
+	writeFactsFixture(t, tempDirName, OS_RELEASE_FILE, `NAME="Rocky Linux"
+VERSION_ID="9.3"
+ID="rocky"
+ID_LIKE="rhel centos fedora"
+PRETTY_NAME="Rocky Linux 9.3 (Blue Onyx)"
+`)
+	writeFactsFixture(t, tempDirName, KERNEL_RELEASE_FILE, "5.14.0-362.8.1.el9_3.x86_64\n")
+	writeFactsFixture(t, tempDirName, MEMINFO_FILE, "MemTotal:       16129400 kB\nMemFree:         1000000 kB\n")
+	writeFactsFixture(t, tempDirName, MINION_MASTER_CONF, "master:\n- 10.0.0.1\n")
+	writeFactsFixture(t, tempDirName, GRAINS_FILE, "roles:\n- manager_server\n")
+
+	facts := collectFacts(tempDirName)
+
+	if facts.Os.Id != "rocky" || facts.Os.VersionId != "9.3" || facts.Os.Family != OS_FAMILY_RHEL {
+		t.Errorf("os release not match: %v", facts.Os)
+	}
+	if facts.Kernel != "5.14.0-362.8.1.el9_3.x86_64" {
+		t.Errorf("kernel not match: %s", facts.Kernel)
+	}
+	if facts.MemoryKb != 16129400 {
+		t.Errorf("memory not match %d == %d", 16129400, facts.MemoryKb)
+	}
+	if facts.MasterConf != "master:\n- 10.0.0.1\n" {
+		t.Errorf("master conf not match: %s", facts.MasterConf)
+	}
+	if facts.Grains != "roles:\n- manager_server\n" {
+		t.Errorf("grains not match: %s", facts.Grains)
+	}
+	if facts.CpuCount == 0 {
+		t.Error("cpu count is expected to be set")
+	}
+	if len(facts.Errors) != 0 {
+		t.Errorf("no errors expected: %v", facts.Errors)
+	}
+}
+
+func TestCollectFactsInitSystem(t *testing.T) {
+	defer func() {
+		stat = initOk
+	}()
+	tempDirName, _ := os.MkdirTemp("", "factstest")
+	defer os.RemoveAll(tempDirName)
+
+	for expected, mock := range map[string]func(string) (os.FileInfo, error){
+		SYSTEM_D.Name():   statOnly("/bin/systemctl", "/run/systemd/system"),
+		OPEN_RC.Name():    statOnly("/sbin/rc-service", "/sbin/rc-update", "/run/openrc"),
+		SYS_V_INIT.Name(): statOnly("/sbin/service", "/sbin/chkconfig"),
+	} {
+		stat = mock
+
+		facts := collectFacts(tempDirName)
+
+		if facts.InitSystem != expected {
+			t.Errorf("init system not match %s == %s", expected, facts.InitSystem)
+		}
+	}
+}
+
+func TestCollectFactsMissingFiles(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "factstest")
+	defer os.RemoveAll(tempDirName)
+
+	facts := collectFacts(tempDirName)
+
+	for _, fact := range []string{"os", "kernel", "memory"} {
+		if _, ok := facts.Errors[fact]; !ok {
+			t.Errorf("error is expected for %s", fact)
+		}
+	}
+	if _, ok := facts.Errors["grains"]; ok {
+		t.Error("missing grains file is not an error")
+	}
+}
+
+func TestDistributeFactsToTargets(t *testing.T) {
+	distributeRequest := func(clients []string, endpoint string, user string, pass string, requestBody RequestBody) <-chan model.Response {
+		c := make(chan model.Response, len(clients))
+		for _, client := range clients {
+			if endpoint != FactsEP {
+				t.Errorf("endpoint not match %s == %s", FactsEP, endpoint)
+			}
+			c <- model.Response{StatusCode: 200, Address: client, Payload: Facts{Kernel: "kernel"}}
+		}
+		close(c)
+		return c
+	}
+	request := FactsRequest{Targets: []string{"address1", "address2"}}
+
+	resp := distributeToTargetsImpl(distributeRequest, request.Targets, FactsEP, "user", "pass", RequestBody{})
+
+	if len(resp) != len(request.Targets) {
+		t.Errorf("size not match %d == %d", len(request.Targets), len(resp))
+	}
+}
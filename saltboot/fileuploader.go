@@ -1,27 +1,138 @@
 package saltboot
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
 
 	"github.com/hortonworks/salt-bootstrap/saltboot/model"
 )
 
+const (
+	UPLOAD_CREATED   = "created"
+	UPLOAD_UPDATED   = "updated"
+	UPLOAD_UNCHANGED = "unchanged"
+
+	checksumPrefix = "sha256:"
+)
+
+type FileChecksumRequest struct {
+	Paths   []string `json:"paths"`
+	Targets []string `json:"targets,omitempty"`
+}
+
+type FileChecksum struct {
+	Path      string `json:"path"`
+	Exists    bool   `json:"exists"`
+	Checksum  string `json:"checksum,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Mode      string `json:"mode,omitempty"`
+	ErrorText string `json:"errorText,omitempty"`
+}
+
+// fileChecksum streams the file through the hash instead of reading it into the memory
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer closeIt(file)
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verifyChecksum checks the content against the expected hex SHA-256, optionally prefixed with sha256:
+func verifyChecksum(content io.Reader, expected string) error {
+	expected = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(expected), checksumPrefix))
+	if decoded, err := hex.DecodeString(expected); err != nil || len(decoded) != sha256.Size {
+		return fmt.Errorf("invalid checksum: %s", expected)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return fmt.Errorf("checksum mismatch, expected: %s, actual: %s", expected, actual)
+	}
+	return nil
+}
+
+// writeUploadedFile writes the file unless its content is already on the disk
+func writeUploadedFile(file string, content []byte, permissions os.FileMode, attributes *uploadAttributes) (string, error) {
+	outcome := UPLOAD_CREATED
+	current, err := os.ReadFile(file)
+	if err == nil {
+		if bytes.Equal(current, content) {
+			matches, err := attributes.matches(file)
+			if err != nil || matches {
+				log.Printf("[writeUploadedFile] content of %s is unchanged, skip the write", file)
+				return UPLOAD_UNCHANGED, err
+			}
+			log.Printf("[writeUploadedFile] content of %s is unchanged, update its attributes", file)
+			return UPLOAD_UPDATED, attributes.applyFile(file)
+		}
+		outcome = UPLOAD_UPDATED
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	return outcome, writeFileWith(file, content, permissions, attributes.applyFile)
+}
+
+func readFileChecksum(path string) FileChecksum {
+	result := FileChecksum{Path: path}
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		result.ErrorText = "path is not an absolute and clean path"
+		return result
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return result
+	} else if err != nil {
+		result.ErrorText = err.Error()
+		return result
+	}
+	result.Exists = true
+	if info.IsDir() {
+		result.ErrorText = "path is a directory"
+		return result
+	}
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		result.ErrorText = err.Error()
+		return result
+	}
+	result.Checksum = checksum
+	result.Size = info.Size()
+	result.Mode = fmt.Sprintf("%04o", info.Mode().Perm())
+	return result
+}
+
 func FileUploadDistributeHandler(w http.ResponseWriter, req *http.Request) {
 	log.Println("[FileUploadDistributeHandler] execute file distribute")
 
 	targets := req.FormValue("targets")
 	log.Printf("[FileUploadDistributeHandler] requested targets for file distribute: %s", targets)
-	path := req.FormValue("path")
-	permissions := req.FormValue("permissions")
+	options := readFileUploadOptions(req)
 	file, header, err := req.FormFile("file")
+	if err == nil && len(options.Checksum) > 0 {
+		// fail fast rather than on every target
+		if err = verifyChecksum(file, options.Checksum); err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+	}
 	if err != nil {
 		log.Printf("[FileUploadDistributeHandler] [ERROR] form file error: %s", err.Error())
 		resp := model.Responses{Responses: []model.Response{{Status: err.Error(), StatusCode: http.StatusBadRequest}}}
@@ -35,7 +146,7 @@ func FileUploadDistributeHandler(w http.ResponseWriter, req *http.Request) {
 	user, pass := GetAuthUserPass(req)
 	signature := strings.TrimSpace(req.Header.Get(SIGNATURE))
 
-	result := fileDistributeActionImpl(user, pass, strings.Split(targets, ","), path, permissions, file, header, signature)
+	result := fileDistributeActionImpl(user, pass, strings.Split(targets, ","), options, file, header, signature)
 	cResp := model.Responses{Responses: result}
 	log.Printf("[FileUploadDistributeHandler] distribute file upload request executed: %s", cResp.String())
 	if err := json.NewEncoder(w).Encode(cResp); err != nil {
@@ -43,20 +154,24 @@ func FileUploadDistributeHandler(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func fileDistributeActionImpl(user string, pass string, targets []string, path string, permissions string,
-	file multipart.File, header *multipart.FileHeader, signature string) (result []model.Response) {
-	for res := range DistributeFileUploadRequest(UploadEP, user, pass, targets, path, permissions, file, header, signature) {
+func fileDistributeActionImpl(user string, pass string, targets []string, options FileUploadOptions, file multipart.File,
+	header *multipart.FileHeader, signature string) (result []model.Response) {
+	for res := range DistributeFileUploadRequest(UploadEP, user, pass, targets, options, file, header, signature) {
 		result = append(result, res)
 	}
 	return result
 }
 
+// FileUploadHandler writes the uploaded file, or extracts it if it is an archive and the extract field is not never. Plain files are reported unchanged if
+// their content and attributes are already on the disk, archives are always re-extracted, so they are reported either
+// created or updated depending on whether the target directory existed.
 func FileUploadHandler(w http.ResponseWriter, req *http.Request) {
 	log.Println("[FileUploadHandler] execute file upload")
 
 	w.Header().Set("Content-Type", "text/plain")
 
-	path := req.FormValue("path")
+	options := readFileUploadOptions(req)
+	path := options.Path
 	log.Println("[FileUploadHandler] path: " + path)
 
 	file, header, err := req.FormFile("file")
@@ -70,9 +185,63 @@ func FileUploadHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	b, _ := io.ReadAll(file)
+	if len(options.Checksum) > 0 {
+		err := verifyChecksum(file, options.Checksum)
+		if err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			log.Printf("[FileUploadHandler] [ERROR] %s", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			if _, err := w.Write([]byte("400 Bad Request")); err != nil {
+				log.Printf("[FileUploadHandler] [ERROR] couldn't write response: %s", err.Error())
+			}
+			fmt.Fprintln(w, err)
+			return
+		}
+	}
+
+	mode := options.Mode
+	// replace mode swaps the whole directory, it is only allowed under the configured roots
+	if !containsString(uploadModes, mode) || (mode == UPLOAD_MODE_REPLACE && !isReplaceAllowed(path)) {
+		log.Printf("[FileUploadHandler] [ERROR] invalid mode %s for path %s", mode, path)
+		w.WriteHeader(http.StatusBadRequest)
+		if _, err := w.Write([]byte("400 Bad Request")); err != nil {
+			log.Printf("[FileUploadHandler] [ERROR] couldn't write response: %s", err.Error())
+		}
+		fmt.Fprintln(w, "invalid mode "+mode+" for path "+path)
+		return
+	}
+	if !containsString(extractModes, options.Extract) {
+		log.Printf("[FileUploadHandler] [ERROR] invalid extract mode %s", options.Extract)
+		w.WriteHeader(http.StatusBadRequest)
+		if _, err := w.Write([]byte("400 Bad Request")); err != nil {
+			log.Printf("[FileUploadHandler] [ERROR] couldn't write response: %s", err.Error())
+		}
+		fmt.Fprintln(w, "invalid extract mode "+options.Extract)
+		return
+	}
+	attributes, err := options.attributes()
+	if err != nil {
+		log.Printf("[FileUploadHandler] [ERROR] invalid attributes: %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		if _, err := w.Write([]byte("400 Bad Request")); err != nil {
+			log.Printf("[FileUploadHandler] [ERROR] couldn't write response: %s", err.Error())
+		}
+		fmt.Fprintln(w, err)
+		return
+	}
 
-	if err := os.MkdirAll(path, 0744); err != nil {
+	outcome := UPLOAD_UPDATED
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		outcome = UPLOAD_CREATED
+	}
+	dir := path
+	if mode == UPLOAD_MODE_REPLACE {
+		// the directory itself is swapped in with the extracted archive
+		dir = filepath.Dir(filepath.Clean(path))
+	}
+	if err := attributes.mkdirAll(dir, 0744); err != nil {
 		log.Printf("[FileUploadHandler] [ERROR] make dir error: %s", err.Error())
 		w.WriteHeader(http.StatusForbidden)
 		if _, err := w.Write([]byte("403 Forbidden")); err != nil {
@@ -83,27 +252,46 @@ func FileUploadHandler(w http.ResponseWriter, req *http.Request) {
 	}
 
 	permissions := os.FileMode(0644)
-	requestedPermissions := req.FormValue("permissions")
-	if len(requestedPermissions) > 0 {
-		log.Printf("[FileUploadHandler] requested special permissions: %s", requestedPermissions)
-		perm64, _ := strconv.ParseUint(requestedPermissions, 8, 32)
-		permissions = os.FileMode(perm64)
+	if attributes != nil && attributes.perm != 0 {
+		log.Printf("[FileUploadHandler] requested special permissions: %s", options.Permissions)
+		permissions = attributes.perm
 	}
 	log.Printf("[FileUploadHandler] permissions to create the file with: %o", permissions)
 
-	if strings.Contains(header.Filename, ".zip") {
-		log.Println("[FileUploadHandler] unzip file from /tmp")
-		if err := WriteFile("/tmp/"+header.Filename, b, permissions); err != nil {
-			log.Printf("[FileUploadHandler] [ERROR] unable to write file: %s", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			if _, err := w.Write([]byte("500 Internal Server Error")); err != nil {
-				log.Printf("[FileUploadHandler] [ERROR] couldn't write response: %s", err.Error())
-			}
-			fmt.Fprintln(w, err)
-			return
+	var archiveType string
+	if options.Extract != EXTRACT_NEVER {
+		archiveType, err = detectArchiveType(file, header.Size)
+	}
+	if err != nil {
+		log.Printf("[FileUploadHandler] [ERROR] unable to read file: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		if _, err := w.Write([]byte("500 Internal Server Error")); err != nil {
+			log.Printf("[FileUploadHandler] [ERROR] couldn't write response: %s", err.Error())
+		}
+		fmt.Fprintln(w, err)
+		return
+	}
+	if len(archiveType) == 0 && (mode == UPLOAD_MODE_REPLACE || options.Extract == EXTRACT_ALWAYS) {
+		log.Printf("[FileUploadHandler] [ERROR] %s is not extracted as an archive, it cannot be uploaded to %s", header.Filename, path)
+		w.WriteHeader(http.StatusBadRequest)
+		if _, err := w.Write([]byte("400 Bad Request")); err != nil {
+			log.Printf("[FileUploadHandler] [ERROR] couldn't write response: %s", err.Error())
+		}
+		fmt.Fprintln(w, "only extracted archives can be uploaded in replace mode or with extract "+EXTRACT_ALWAYS)
+		return
+	}
+	if len(archiveType) > 0 {
+		// the extracted tree may have been changed locally, so archives are never reported unchanged
+		extract := func(dest string) error {
+			return extractArchive(archiveType, file, header.Size, dest, attributes)
 		}
-		if err := Unzip("/tmp/"+header.Filename, path); err != nil {
-			log.Printf("[FileUploadHandler] [ERROR] unzipt file error: %s", err.Error())
+		if mode == UPLOAD_MODE_REPLACE {
+			err = replaceDirectory(path, attributes, extract)
+		} else {
+			err = extract(path)
+		}
+		if err != nil {
+			log.Printf("[FileUploadHandler] [ERROR] unable to extract %s archive: %s", archiveType, err.Error())
 			w.WriteHeader(http.StatusInternalServerError)
 			if _, err := w.Write([]byte("500 Internal Server Error")); err != nil {
 				log.Printf("[FileUploadHandler] [ERROR] couldn't write response: %s", err.Error())
@@ -113,7 +301,8 @@ func FileUploadHandler(w http.ResponseWriter, req *http.Request) {
 		}
 	} else {
 		log.Println("[FileUploadHandler] FileName: " + header.Filename)
-		if err := WriteFile(path+"/"+header.Filename, b, permissions); err != nil {
+		b, _ := io.ReadAll(file)
+		if outcome, err = writeUploadedFile(path+"/"+header.Filename, b, permissions, attributes); err != nil {
 			log.Printf("[fileUploadHandler] [ERROR] wirte file error: %s", err.Error())
 			w.WriteHeader(http.StatusInternalServerError)
 			if _, err := w.Write([]byte("500 Internal Server Error")); err != nil {
@@ -125,9 +314,59 @@ func FileUploadHandler(w http.ResponseWriter, req *http.Request) {
 	}
 
 	defer closeIt(file)
+	// 201 is kept for every outcome, the clients tell them apart by the body
 	w.WriteHeader(http.StatusCreated)
 	if _, err := w.Write([]byte("201 Created ")); err != nil {
 		log.Printf("[FileUploadHandler] [ERROR] couldn't write response: %s", err.Error())
 	}
-	fmt.Fprintf(w, "File %s uploaded successfully.", header.Filename)
+	fmt.Fprintf(w, "File %s uploaded successfully: %s.", header.Filename, outcome)
+}
+
+func FileChecksumHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[FileChecksumHandler] execute file checksum request")
+
+	decoder := json.NewDecoder(req.Body)
+	var checksumRequest FileChecksumRequest
+	if err := decoder.Decode(&checksumRequest); err != nil {
+		log.Printf("[FileChecksumHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(checksumRequest.Paths) == 0 {
+		log.Printf("[FileChecksumHandler] [ERROR] no paths were specified in the request")
+		model.Response{Status: "no paths were specified in the request"}.WriteBadRequestHttp(w)
+		return
+	}
+
+	checksums := make([]FileChecksum, 0, len(checksumRequest.Paths))
+	for _, path := range checksumRequest.Paths {
+		checksums = append(checksums, readFileChecksum(path))
+	}
+	model.Response{Status: fmt.Sprintf("%d checksums", len(checksums)), Payload: checksums}.WriteHttp(w)
+}
+
+func FileChecksumDistributeHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[FileChecksumDistributeHandler] distribute file checksum request")
+
+	decoder := json.NewDecoder(req.Body)
+	var checksumRequest FileChecksumRequest
+	if err := decoder.Decode(&checksumRequest); err != nil {
+		log.Printf("[FileChecksumDistributeHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(checksumRequest.Targets) == 0 || len(checksumRequest.Paths) == 0 {
+		err := errors.New("no targets or paths were specified in the request")
+		log.Printf("[FileChecksumDistributeHandler] [ERROR] %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+
+	user, pass := GetAuthUserPass(req)
+	result := distributeToTargetsImpl(DistributeRequest, checksumRequest.Targets, FileChecksumEP, user, pass, GetSignedRequestBody(req))
+	cResp := model.Responses{Responses: result}
+	log.Printf("[FileChecksumDistributeHandler] distribute file checksum request executed: %s", cResp.String())
+	if err := json.NewEncoder(w).Encode(cResp); err != nil {
+		log.Printf("[FileChecksumDistributeHandler] [ERROR] couldn't encode json: %s", err.Error())
+	}
 }
@@ -3,11 +3,16 @@ package saltboot
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"io"
 	"mime/multipart"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -87,3 +92,152 @@ func TestUploadZip(t *testing.T) {
 		t.Errorf("Not match %s == %s", string(expected), string(content))
 	}
 }
+
+func newUploadRequest(path string, name string, content []byte, checksum string) *http.Request {
+	body := &bytes.Buffer{}
+	multiWriter := multipart.NewWriter(body)
+	if len(checksum) > 0 {
+		multiWriter.WriteField("checksum", checksum)
+	}
+	part, _ := multiWriter.CreateFormFile("file", name)
+	part.Write(content)
+	multiWriter.Close()
+	req := httptest.NewRequest("POST", "http://fileupload?path="+path, body)
+	req.Header.Set("Content-Type", multiWriter.FormDataContentType())
+	return req
+}
+
+func TestUploadOutcome(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "fileuploadertest")
+	defer os.RemoveAll(tempDirName)
+
+	for _, upload := range []struct {
+		content string
+		code    int
+		outcome string
+	}{
+		{"v1", 201, UPLOAD_CREATED},
+		{"v1", 201, UPLOAD_UNCHANGED},
+		{"v2", 201, UPLOAD_UPDATED},
+	} {
+		sum := sha256.Sum256([]byte(upload.content))
+		writer := httptest.NewRecorder()
+
+		FileUploadHandler(writer, newUploadRequest(tempDirName, "test.txt", []byte(upload.content), "sha256:"+hex.EncodeToString(sum[:])))
+
+		if writer.Code != upload.code || !strings.HasSuffix(writer.Body.String(), upload.outcome+".") {
+			t.Errorf("upload of %s not match %d %s == %d %s", upload.content, upload.code, upload.outcome, writer.Code, writer.Body.String())
+		}
+	}
+	content, _ := os.ReadFile(filepath.Join(tempDirName, "test.txt"))
+	if string(content) != "v2" {
+		t.Errorf("content not match %s == %s", "v2", content)
+	}
+}
+
+func TestUploadArchiveIsNeverUnchanged(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "fileuploadertest")
+	defer os.RemoveAll(tempDirName)
+	buf := new(bytes.Buffer)
+	zipWriter := zip.NewWriter(buf)
+	zipEntry, _ := zipWriter.Create("test.txt")
+	zipEntry.Write([]byte("content"))
+	zipWriter.Close()
+	dest := filepath.Join(tempDirName, "dest")
+
+	for _, outcome := range []string{UPLOAD_CREATED, UPLOAD_UPDATED} {
+		writer := httptest.NewRecorder()
+
+		FileUploadHandler(writer, newUploadRequest(dest, "test.zip", buf.Bytes(), ""))
+
+		if writer.Code != 201 || !strings.HasSuffix(writer.Body.String(), outcome+".") {
+			t.Errorf("archive upload not match %d %s == %d %s", 201, outcome, writer.Code, writer.Body.String())
+		}
+	}
+}
+
+func TestUploadArchiveVerbatim(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "fileuploadertest")
+	defer os.RemoveAll(tempDirName)
+	buf := new(bytes.Buffer)
+	zipWriter := zip.NewWriter(buf)
+	zipEntry, _ := zipWriter.Create("test.txt")
+	zipEntry.Write([]byte("content"))
+	zipWriter.Close()
+	req := newUploadRequest(tempDirName, "test.zip", buf.Bytes(), "")
+	req.URL.RawQuery += "&extract=" + EXTRACT_NEVER
+	writer := httptest.NewRecorder()
+
+	FileUploadHandler(writer, req)
+
+	content, _ := os.ReadFile(filepath.Join(tempDirName, "test.zip"))
+	if writer.Code != 201 || !bytes.Equal(content, buf.Bytes()) {
+		t.Errorf("archive is expected to be stored as it is: %d %s", writer.Code, writer.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(tempDirName, "test.txt")); !os.IsNotExist(err) {
+		t.Error("archive is not expected to be extracted")
+	}
+}
+
+func TestUploadExtractAlwaysRequiresArchive(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "fileuploadertest")
+	defer os.RemoveAll(tempDirName)
+	req := newUploadRequest(tempDirName, "test.txt", []byte("content"), "")
+	req.URL.RawQuery += "&extract=" + EXTRACT_ALWAYS
+	writer := httptest.NewRecorder()
+
+	FileUploadHandler(writer, req)
+
+	if writer.Code != 400 {
+		t.Errorf("Wrong status code %d == %d", 400, writer.Code)
+	}
+}
+
+func TestUploadChecksumMismatch(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "fileuploadertest")
+	defer os.RemoveAll(tempDirName)
+	sum := sha256.Sum256([]byte("expected"))
+
+	for _, checksum := range []string{hex.EncodeToString(sum[:]), "not-a-checksum"} {
+		writer := httptest.NewRecorder()
+
+		FileUploadHandler(writer, newUploadRequest(tempDirName, "test.txt", []byte("tampered"), checksum))
+
+		if writer.Code != 400 {
+			t.Errorf("Wrong status code %d == %d", 400, writer.Code)
+		}
+		if _, err := os.Stat(filepath.Join(tempDirName, "test.txt")); !os.IsNotExist(err) {
+			t.Errorf("file is not expected to be written on checksum mismatch")
+		}
+	}
+}
+
+func TestFileChecksumHandler(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "fileuploadertest")
+	defer os.RemoveAll(tempDirName)
+	file := filepath.Join(tempDirName, "test.txt")
+	os.WriteFile(file, []byte("content"), 0640)
+	sum := sha256.Sum256([]byte("content"))
+
+	body, _ := json.Marshal(FileChecksumRequest{Paths: []string{file, filepath.Join(tempDirName, "missing"), tempDirName + "/../etc"}})
+	req := httptest.NewRequest("POST", FileChecksumEP, bytes.NewReader(body))
+	writer := httptest.NewRecorder()
+
+	FileChecksumHandler(writer, req)
+
+	var resp struct{ Payload []FileChecksum }
+	json.NewDecoder(writer.Body).Decode(&resp)
+	expected := []FileChecksum{
+		{Path: file, Exists: true, Checksum: hex.EncodeToString(sum[:]), Size: 7, Mode: "0640"},
+		{Path: filepath.Join(tempDirName, "missing")},
+		{Path: tempDirName + "/../etc", ErrorText: "path is not an absolute and clean path"},
+	}
+	if writer.Code != 200 || len(resp.Payload) != len(expected) {
+		t.Fatalf("checksums not match: %d %v", writer.Code, resp.Payload)
+	}
+	for i := range expected {
+		if resp.Payload[i] != expected[i] {
+			t.Errorf("checksum not match %v == %v", expected[i], resp.Payload[i])
+		}
+	}
+}
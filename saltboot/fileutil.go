@@ -1,26 +1,112 @@
 package saltboot
 
 import (
+	"errors"
 	"io/fs"
+	"log"
 	"os"
+	"path/filepath"
+	"syscall"
 )
 
+// the file operations used by WriteFile, tests replace them to inject failures
+var (
+	createTempFile = os.CreateTemp
+	writeTempFile  = func(f *os.File, data []byte) (int, error) { return f.Write(data) }
+	syncFile       = func(f *os.File) error { return f.Sync() }
+	renameFile     = os.Rename
+)
+
+// WriteFile replaces the file atomically: the data is written to a temporary file in the same directory, which is
+// synced and renamed to the target, then the directory is synced, so a crash or a full disk leaves either the old
+// or the new content. The mode, the ownership and the SELinux label of an existing file are preserved, perm is
+// applied to new files only.
 func WriteFile(filename string, data []byte, perm fs.FileMode) error {
+	return writeFileWith(filename, data, perm, nil)
+}
+
+// writeFileWith is WriteFile with a prepare step, which is called with the temporary file before it is renamed, so
+// the attributes it sets are in place when the file becomes visible
+func writeFileWith(filename string, data []byte, perm fs.FileMode, prepare func(tmp string) error) error {
+	// replace the target of a symlink rather than the link itself
+	if resolved, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = resolved
+	}
+	current, err := os.Stat(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	dir := filepath.Dir(filename)
+	tmp, err := createTempFile(dir, "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err := writeTempFile(tmp, data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := syncFile(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if current != nil {
+		err = preserveFileAttributes(filename, current, tmp.Name())
+	} else {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil && prepare != nil {
+		err = prepare(tmp.Name())
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := renameFile(tmp.Name(), filename); err != nil {
+		if errors.Is(err, syscall.EBUSY) {
+			// bind mounted files, like /etc/hosts of a container, cannot be replaced, only rewritten
+			log.Printf("[writeFileWith] %s is busy, write it in place", filename)
+			if err := writeFileInPlace(filename, data, perm); err != nil || prepare == nil {
+				return err
+			}
+			return prepare(filename)
+		}
+		return err
+	}
+	renamed = true
+	return syncDir(dir)
+}
+
+func writeFileInPlace(filename string, data []byte, perm fs.FileMode) error {
 	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
 		return err
 	}
-	_, err2 := f.Write(data)
-	if err2 != nil {
-		return err2
+	if _, err := writeTempFile(f, data); err != nil {
+		f.Close()
+		return err
 	}
-	err3 := f.Sync()
-	if err3 != nil {
-		return err3
+	if err := syncFile(f); err != nil {
+		f.Close()
+		return err
 	}
-	err4 := f.Close()
-	if err4 != nil {
-		return err4
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
 	}
-	return nil
+	defer closeIt(d)
+	return syncFile(d)
 }
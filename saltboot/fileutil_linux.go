@@ -0,0 +1,105 @@
+//go:build linux
+
+package saltboot
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	selinuxXattr = "security.selinux"
+
+	// renameExchange is RENAME_EXCHANGE of linux/fs.h
+	renameExchange = 2
+)
+
+// atFdcwd is AT_FDCWD of fcntl.h, the paths are resolved relative to the working directory
+var atFdcwd = -100
+
+// preserveFileAttributes copies the mode, the ownership and the SELinux label of the file to its replacement
+func preserveFileAttributes(filename string, current fs.FileInfo, replacement string) error {
+	// chown clears the setuid and setgid bits, so the mode is set after the ownership
+	if stat, ok := current.Sys().(*syscall.Stat_t); ok {
+		if err := os.Chown(replacement, int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(replacement, current.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+		return err
+	}
+	label, err := getXattr(filename, selinuxXattr)
+	if err != nil || label == nil {
+		return err
+	}
+	return syscall.Setxattr(replacement, selinuxXattr, label, 0)
+}
+
+// getXattr returns the value of the extended attribute, nil if it is not set or not supported
+func getXattr(filename string, attr string) ([]byte, error) {
+	size, err := syscall.Getxattr(filename, attr, nil)
+	if isXattrMissing(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	value := make([]byte, size)
+	size, err = syscall.Getxattr(filename, attr, value)
+	if isXattrMissing(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
+
+// fileOwner returns the owner and the group of the file
+func fileOwner(info fs.FileInfo) (int, int, bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid), true
+	}
+	return 0, 0, false
+}
+
+func getSELinuxContext(filename string) (string, error) {
+	label, err := getXattr(filename, selinuxXattr)
+	return strings.TrimRight(string(label), "\x00"), err
+}
+
+// setSELinuxContext sets the label like libselinux does, with a terminating NUL
+func setSELinuxContext(filename string, context string) error {
+	if err := syscall.Setxattr(filename, selinuxXattr, append([]byte(context), 0), 0); err != nil {
+		return fmt.Errorf("unable to set SELinux context of %s: %w", filename, err)
+	}
+	return nil
+}
+
+func isXattrMissing(err error) bool {
+	return errors.Is(err, syscall.ENODATA) || errors.Is(err, syscall.ENOTSUP)
+}
+
+// exchangePaths swaps the two paths atomically, so readers see either the old or the new tree
+func exchangePaths(oldPath string, newPath string) error {
+	if sysRenameat2 == 0 {
+		return syscall.ENOSYS
+	}
+	oldPtr, err := syscall.BytePtrFromString(oldPath)
+	if err != nil {
+		return err
+	}
+	newPtr, err := syscall.BytePtrFromString(newPath)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(uintptr(sysRenameat2), uintptr(atFdcwd), uintptr(unsafe.Pointer(oldPtr)),
+		uintptr(atFdcwd), uintptr(unsafe.Pointer(newPtr)), renameExchange, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+//go:build linux && !amd64 && !arm64 && !ppc64le
+
+package saltboot
+
+// sysRenameat2 is not known on the other architectures, the atomic exchange is reported as not supported
+const sysRenameat2 = 0
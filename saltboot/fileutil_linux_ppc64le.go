@@ -0,0 +1,4 @@
+package saltboot
+
+// sysRenameat2 is the number of the renameat2 system call, the syscall package does not define it on every architecture
+const sysRenameat2 = 357
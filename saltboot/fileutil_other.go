@@ -0,0 +1,42 @@
+//go:build unix && !linux
+
+package saltboot
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// preserveFileAttributes copies the mode and the ownership of the file to its replacement
+func preserveFileAttributes(filename string, current fs.FileInfo, replacement string) error {
+	// chown clears the setuid and setgid bits, so the mode is set after the ownership
+	if stat, ok := current.Sys().(*syscall.Stat_t); ok {
+		if err := os.Chown(replacement, int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(replacement, current.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+}
+
+// fileOwner returns the owner and the group of the file
+func fileOwner(info fs.FileInfo) (int, int, bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid), true
+	}
+	return 0, 0, false
+}
+
+func getSELinuxContext(filename string) (string, error) {
+	return "", nil
+}
+
+func setSELinuxContext(filename string, context string) error {
+	return fmt.Errorf("unable to set SELinux context of %s: %w", filename, syscall.ENOTSUP)
+}
+
+// exchangePaths is not supported, the caller falls back to two renames
+func exchangePaths(oldPath string, newPath string) error {
+	return syscall.ENOTSUP
+}
@@ -0,0 +1,179 @@
+package saltboot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func newWriteFileTestDir(t *testing.T) (string, string) {
+	dir, _ := os.MkdirTemp("", "fileutiltest")
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	file := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(file, []byte("old"), 0640); err != nil {
+		t.Fatalf("unable to write file: %s", err)
+	}
+	return dir, file
+}
+
+func checkWriteFileResult(t *testing.T, dir string, file string, expected string) {
+	content, _ := os.ReadFile(file)
+	if string(content) != expected {
+		t.Errorf("content not match %s == %s", expected, content)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("temporary files are expected to be removed: %v", entries)
+	}
+}
+
+func TestWriteFileNewFile(t *testing.T) {
+	dir, _ := newWriteFileTestDir(t)
+	file := filepath.Join(dir, "grains")
+
+	if err := WriteFile(file, []byte("new"), 0600); err != nil {
+		t.Fatalf("unable to write file: %s", err)
+	}
+
+	content, _ := os.ReadFile(file)
+	info, _ := os.Stat(file)
+	if string(content) != "new" || info.Mode().Perm() != 0600 {
+		t.Errorf("file not match: %s, %s", content, info.Mode())
+	}
+}
+
+func TestWriteFilePreservesMode(t *testing.T) {
+	dir, file := newWriteFileTestDir(t)
+
+	if err := WriteFile(file, []byte("new"), 0644); err != nil {
+		t.Fatalf("unable to write file: %s", err)
+	}
+
+	checkWriteFileResult(t, dir, file, "new")
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0640 {
+		t.Errorf("mode is expected to be preserved: %s", info.Mode())
+	}
+}
+
+func TestWriteFilePreservesSetuid(t *testing.T) {
+	dir, file := newWriteFileTestDir(t)
+	if err := os.Chmod(file, os.ModeSetuid|os.ModeSetgid|0755); err != nil {
+		t.Fatalf("unable to set mode: %s", err)
+	}
+
+	if err := WriteFile(file, []byte("new"), 0644); err != nil {
+		t.Fatalf("unable to write file: %s", err)
+	}
+
+	checkWriteFileResult(t, dir, file, "new")
+	if info, _ := os.Stat(file); info.Mode() != os.ModeSetuid|os.ModeSetgid|0755 {
+		t.Errorf("setuid and setgid are expected to be preserved: %s", info.Mode())
+	}
+}
+
+func TestWriteFileReplacesSymlinkTarget(t *testing.T) {
+	dir, file := newWriteFileTestDir(t)
+	link := filepath.Join(dir, "link")
+	os.Symlink(file, link)
+
+	if err := WriteFile(link, []byte("new"), 0644); err != nil {
+		t.Fatalf("unable to write file: %s", err)
+	}
+
+	if info, _ := os.Lstat(link); info.Mode()&os.ModeSymlink == 0 {
+		t.Error("symlink is expected to be kept")
+	}
+	if content, _ := os.ReadFile(file); string(content) != "new" {
+		t.Errorf("content of the symlink target not match: %s", content)
+	}
+}
+
+func TestWriteFileFailures(t *testing.T) {
+	failure := errors.New("no space left on device")
+	for name, inject := range map[string]func() func(){
+		"write": func() func() {
+			writeTempFile = func(f *os.File, data []byte) (int, error) {
+				f.Write(data[:1])
+				return 1, failure
+			}
+			return func() { writeTempFile = func(f *os.File, data []byte) (int, error) { return f.Write(data) } }
+		},
+		"sync": func() func() {
+			syncFile = func(f *os.File) error { return failure }
+			return func() { syncFile = func(f *os.File) error { return f.Sync() } }
+		},
+		"rename": func() func() {
+			renameFile = func(string, string) error { return failure }
+			return func() { renameFile = os.Rename }
+		},
+		"create": func() func() {
+			createTempFile = func(string, string) (*os.File, error) { return nil, failure }
+			return func() { createTempFile = os.CreateTemp }
+		},
+	} {
+		dir, file := newWriteFileTestDir(t)
+		restore := inject()
+
+		err := WriteFile(file, []byte("new content"), 0644)
+
+		restore()
+		if !errors.Is(err, failure) {
+			t.Errorf("%s failure is expected to be returned: %v", name, err)
+		}
+		checkWriteFileResult(t, dir, file, "old")
+	}
+}
+
+func TestWriteFileDirSyncFailure(t *testing.T) {
+	dir, file := newWriteFileTestDir(t)
+	failure := errors.New("io error")
+	syncFile = func(f *os.File) error {
+		if info, _ := f.Stat(); info.IsDir() {
+			return failure
+		}
+		return f.Sync()
+	}
+	defer func() { syncFile = func(f *os.File) error { return f.Sync() } }()
+
+	err := WriteFile(file, []byte("new"), 0644)
+
+	if !errors.Is(err, failure) {
+		t.Errorf("directory sync failure is expected to be returned: %v", err)
+	}
+	checkWriteFileResult(t, dir, file, "new")
+}
+
+func TestWriteFileBusyTarget(t *testing.T) {
+	dir, file := newWriteFileTestDir(t)
+	renameFile = func(string, string) error { return &os.LinkError{Op: "rename", Err: syscall.EBUSY} }
+	defer func() { renameFile = os.Rename }()
+
+	if err := WriteFile(file, []byte("new"), 0644); err != nil {
+		t.Fatalf("busy file is expected to be written in place: %s", err)
+	}
+
+	checkWriteFileResult(t, dir, file, "new")
+}
+
+func TestExchangePaths(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "fileutiltest")
+	defer os.RemoveAll(dir)
+	os.WriteFile(filepath.Join(dir, "old"), []byte("old"), 0644)
+	os.WriteFile(filepath.Join(dir, "new"), []byte("new"), 0644)
+
+	err := exchangePaths(filepath.Join(dir, "new"), filepath.Join(dir, "old"))
+
+	if errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EINVAL) {
+		t.Skipf("atomic exchange is not supported: %s", err)
+	} else if err != nil {
+		t.Fatalf("unable to exchange paths: %s", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "old")); string(content) != "new" {
+		t.Errorf("content not match %s == %s", "new", content)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "new")); string(content) != "old" {
+		t.Errorf("content not match %s == %s", "old", content)
+	}
+}
@@ -0,0 +1,31 @@
+package saltboot
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// preserveFileAttributes copies the mode of the file to its replacement, the ownership is not managed on Windows
+func preserveFileAttributes(filename string, current fs.FileInfo, replacement string) error {
+	return os.Chmod(replacement, current.Mode()&fs.ModePerm)
+}
+
+// fileOwner is not known on Windows
+func fileOwner(info fs.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
+
+func getSELinuxContext(filename string) (string, error) {
+	return "", nil
+}
+
+func setSELinuxContext(filename string, context string) error {
+	return fmt.Errorf("unable to set SELinux context of %s: %w", filename, syscall.ENOTSUP)
+}
+
+// exchangePaths is not supported, the caller falls back to two renames
+func exchangePaths(oldPath string, newPath string) error {
+	return syscall.ENOTSUP
+}
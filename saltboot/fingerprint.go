@@ -1,24 +1,24 @@
 package saltboot
 
 import (
-	"crypto/sha256"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"strings"
 
 	"github.com/hortonworks/salt-bootstrap/saltboot/model"
 )
 
 const (
-	MinionKey    = "/etc/salt/pki/minion/minion.pem"
-	SaltLocation = "/opt/"
+	MinionKey       = "/etc/salt/pki/minion/minion.pem"
+	MinionPublicKey = "/etc/salt/pki/minion/minion.pub"
+	SaltLocation    = "/opt/"
 )
 
 type FingerprintsRequest struct {
@@ -95,13 +95,13 @@ func convertToNilIfEmpty(value string) *string {
 	return &value
 }
 
-func (r FingerprintsRequest) distributeRequest(user string, pass string, signedRequestBody RequestBody) []Fingerprint {
+func (r FingerprintsRequest) distributeRequest(hashType string, user string, pass string, signedRequestBody RequestBody) []Fingerprint {
 	log.Print("[distributeRequest] distribute fingerprint request to targets")
-	return distributeFingerprintImpl(DistributeRequest, r, user, pass, signedRequestBody)
+	return distributeFingerprintImpl(DistributeRequest, r, hashType, user, pass, signedRequestBody)
 }
 
 func distributeFingerprintImpl(distributeRequest func([]string, string, string, string, RequestBody) <-chan model.Response,
-	request FingerprintsRequest, user string, pass string, requestBody RequestBody) (result []Fingerprint) {
+	request FingerprintsRequest, hashType string, user string, pass string, requestBody RequestBody) (result []Fingerprint) {
 
 	var targets []string
 	for _, minion := range request.Minions {
@@ -109,70 +109,93 @@ func distributeFingerprintImpl(distributeRequest func([]string, string, string,
 	}
 
 	log.Printf("[distributeFingerprintImpl] send fingerprint request to minions: %s", targets)
-	for res := range distributeRequest(targets, SaltMinionKeyEP, user, pass, requestBody) {
+	endpoint := SaltMinionKeyEP
+	if len(hashType) > 0 {
+		endpoint += "?hash_type=" + url.QueryEscape(hashType)
+	}
+	for res := range distributeRequest(targets, endpoint, user, pass, requestBody) {
 		result = append(result, newFingerprint(res))
 	}
 
 	return result
 }
 
-func getMinionFingerprintFromPrivateKey() model.Response {
-	keyLocation := MinionKey
-	log.Println("[getMinionFingerprintFromPrivateKey] generate the fingerprint from the minion's private key: " + keyLocation)
-
-	privateKeyText, err := os.ReadFile(keyLocation)
-	if err != nil {
-		return createErrorResponse(fmt.Sprintf("unable to load file %s", keyLocation), err)
-	}
+func getMinionFingerprint(hashType string) model.Response {
+	return minionFingerprint(MinionKey, MinionPublicKey, hashType)
+}
 
-	privateKeyPem, _ := pem.Decode(privateKeyText)
-	if privateKeyPem == nil {
-		return createErrorResponse("Invalid key", errors.New("cannot decode private key"))
+// minionFingerprint computes the fingerprint as salt-key -F does from the public key derived from the minion's
+// private key, or from the public key itself if the private key is not readable
+func minionFingerprint(privateKeyLocation string, publicKeyLocation string, hashType string) model.Response {
+	if _, ok := fingerprintHashes[hashType]; !ok {
+		return model.Response{StatusCode: http.StatusBadRequest, ErrorText: "unsupported hash type: " + hashType}
 	}
 
-	privateKeyDer, err := x509.ParsePKCS1PrivateKey(privateKeyPem.Bytes)
-	if err != nil {
-		return createErrorResponse("cannot parse private key to DER format", err)
+	var publicKeyPem []byte
+	privateKeyText, err := os.ReadFile(privateKeyLocation)
+	if err == nil {
+		log.Println("[minionFingerprint] generate the fingerprint from the minion's private key: " + privateKeyLocation)
+		publicKeyPem, err = publicKeyPemFromPrivateKey(privateKeyText)
+		if err != nil {
+			return createErrorResponse("cannot derive the public key", err)
+		}
+	} else {
+		log.Printf("[minionFingerprint] unable to read the private key: %s, use the public key: %s", err.Error(), publicKeyLocation)
+		publicKeyPem, err = os.ReadFile(publicKeyLocation)
+		if err != nil {
+			return createErrorResponse(fmt.Sprintf("unable to load file %s", publicKeyLocation), err)
+		}
 	}
+	log.Printf("[minionFingerprint] public key in PEM format \n%s", publicKeyPem)
 
-	publicKeyDer, err := x509.MarshalPKIXPublicKey(&privateKeyDer.PublicKey)
+	fingerprint, err := pemFingerprintWithHash(publicKeyPem, hashType)
 	if err != nil {
-		return createErrorResponse("cannot marshal to public key", err)
-	}
-
-	pubKeyBlock := pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: publicKeyDer,
+		return createErrorResponse("cannot compute the fingerprint", err)
 	}
-
-	publicKeyPem := string(pem.EncodeToMemory(&pubKeyBlock))
-	log.Printf("[getMinionFingerprintFromPrivateKey] public key in PEM format \n%s", publicKeyPem)
-
-	fingerprint := getFingerprint(publicKeyPem)
-	log.Printf("[getMinionFingerprintFromPrivateKey] calculated fiingerprint: %s", fingerprint)
+	log.Printf("[minionFingerprint] calculated %s fingerprint: %s", hashType, fingerprint)
 
 	return model.Response{Status: fingerprint}
 }
 
-func getFingerprint(publicKey string) string {
-	publicKeyWithoutFirstLine := strings.Replace(publicKey, "-----BEGIN PUBLIC KEY-----\n", "", 1)
-	publicKeyWithoutFirstAndLastLine := strings.Replace(publicKeyWithoutFirstLine, "-----END PUBLIC KEY-----\n", "", 1)
-	hasher := sha256.New()
-	io.WriteString(hasher, publicKeyWithoutFirstAndLastLine)
-	sum := hasher.Sum(nil)
-	fingerprint := ""
-	for i, b := range sum {
-		fingerprint += fmt.Sprintf("%02x", b)
-		if i < len(sum)-1 {
-			fingerprint += ":"
+// publicKeyPemFromPrivateKey supports PKCS1 (RSA PRIVATE KEY) and PKCS8 (PRIVATE KEY) encoded RSA keys
+func publicKeyPemFromPrivateKey(privateKeyText []byte) ([]byte, error) {
+	privateKeyPem, _ := pem.Decode(privateKeyText)
+	if privateKeyPem == nil {
+		return nil, errors.New("cannot decode private key")
+	}
+
+	var privateKey *rsa.PrivateKey
+	switch privateKeyPem.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(privateKeyPem.Bytes)
+		if err != nil {
+			return nil, err
 		}
+		privateKey = key
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(privateKeyPem.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("private key is not an RSA key")
+		}
+		privateKey = rsaKey
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %s", privateKeyPem.Type)
+	}
+
+	publicKeyDer, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
 	}
-	return fingerprint
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDer}), nil
 }
 
 func createErrorResponse(message string, err error) model.Response {
 	errorMessage := fmt.Sprintf("%s, err: %s", message, err.Error())
-	log.Printf("[getMinionFingerprintFromPrivateKey] [ERROR] %s", message)
+	log.Printf("[minionFingerprint] [ERROR] %s", message)
 	return model.Response{
 		StatusCode: http.StatusInternalServerError,
 		ErrorText:  errorMessage,
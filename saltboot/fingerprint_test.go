@@ -0,0 +1,65 @@
+package saltboot
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+// generated by salt.utils.crypt.pem_finger for testdata/pki/minion.pub, the same as salt-key -F prints
+var saltKeyFingerprints = map[string]string{
+	"md5":    "c5:1d:f7:74:73:ad:00:67:0d:aa:d1:91:97:cd:16:e6",
+	"sha1":   "67:db:c5:c1:c1:4a:b7:a3:79:d5:a3:70:78:86:18:e3:ce:fd:02:08",
+	"sha256": "a6:e5:e6:ce:47:e3:b9:8c:d6:c9:08:95:0d:85:bc:af:05:ab:9d:f3:99:6b:61:5c:96:50:af:12:34:d3:7a:d0",
+	"sha512": "3f:66:fc:6b:87:21:08:33:bc:dd:72:51:93:79:20:20:82:d6:77:b3:f5:c6:8f:d3:38:24:cb:60:2a:59:81:f9:88:56:d3:d8:4e:7c:24:d7:ef:1e:53:ca:19:d7:c0:ba:3b:34:4b:ea:08:42:39:66:22:a6:62:07:65:5e:81:90",
+}
+
+func TestMinionFingerprintMatchesSaltKey(t *testing.T) {
+	inputs := map[string][2]string{
+		"pkcs1":       {"testdata/pki/minion-pkcs1.pem", "testdata/pki/missing.pub"},
+		"pkcs8":       {"testdata/pki/minion-pkcs8.pem", "testdata/pki/missing.pub"},
+		"public only": {"testdata/pki/missing.pem", "testdata/pki/minion.pub"},
+	}
+	for name, input := range inputs {
+		for hashType, expected := range saltKeyFingerprints {
+			resp := minionFingerprint(input[0], input[1], hashType)
+			if resp.Status != expected {
+				t.Errorf("%s %s fingerprint not match %s == %s (%s)", name, hashType, expected, resp.Status, resp.ErrorText)
+			}
+		}
+	}
+}
+
+func TestMinionFingerprintErrors(t *testing.T) {
+	if resp := minionFingerprint("testdata/pki/minion-pkcs1.pem", "", "crc32"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status code not match %d == %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	if resp := minionFingerprint("testdata/pki/missing.pem", "testdata/pki/missing.pub", DEFAULT_HASH_TYPE); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status code not match %d == %d", http.StatusInternalServerError, resp.StatusCode)
+	}
+	if resp := minionFingerprint("testdata/pki/minion.pub", "", DEFAULT_HASH_TYPE); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("public key is not expected to be accepted as private key: %s", resp.String())
+	}
+}
+
+func TestDistributeFingerprintWithHashType(t *testing.T) {
+	var endpoints []string
+	distributeRequest := func(clients []string, endpoint string, user string, pass string, requestBody RequestBody) <-chan model.Response {
+		endpoints = append(endpoints, endpoint)
+		c := make(chan model.Response, len(clients))
+		for _, client := range clients {
+			c <- model.Response{StatusCode: 200, Status: "aa:bb", Address: client}
+		}
+		close(c)
+		return c
+	}
+	request := FingerprintsRequest{Minions: []SaltMinion{{Address: "10.0.0.1"}}}
+
+	distributeFingerprintImpl(distributeRequest, request, "md5", "user", "pass", RequestBody{})
+	distributeFingerprintImpl(distributeRequest, request, "", "user", "pass", RequestBody{})
+
+	if endpoints[0] != SaltMinionKeyEP+"?hash_type=md5" || endpoints[1] != SaltMinionKeyEP {
+		t.Errorf("endpoints not match: %s", endpoints)
+	}
+}
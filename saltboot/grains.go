@@ -0,0 +1,359 @@
+package saltboot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	PREWARMED_ROLES_FILE     = "/etc/salt/prewarmed_roles"
+	GRAINS_MANAGED_KEYS_FILE = "/etc/salt/saltboot_managed_grains"
+
+	GRAINS_SET     = "set"
+	GRAINS_MERGE   = "merge"
+	GRAINS_REMOVE  = "remove"
+	GRAINS_REPLACE = "replace"
+
+	rolesGrain = "roles"
+)
+
+var (
+	grainKeyPattern  = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*$`)
+	grainsOperations = []string{GRAINS_SET, GRAINS_MERGE, GRAINS_REMOVE, GRAINS_REPLACE}
+)
+
+type GrainsRequest struct {
+	// one of set, merge, remove and replace
+	Operation string                 `json:"operation"`
+	Grains    map[string]interface{} `json:"grains,omitempty"`
+	// keys to remove, used by the remove operation only
+	Keys    []string `json:"keys,omitempty"`
+	Targets []string `json:"targets,omitempty"`
+}
+
+type GrainsResult struct {
+	Changed bool                   `json:"changed"`
+	Managed []string               `json:"managed"`
+	Grains  map[string]interface{} `json:"grains"`
+	// prewarmed roles added to the roles grain
+	PrewarmedRoles []string `json:"prewarmedRoles,omitempty"`
+}
+
+func (r GrainsRequest) Validate() error {
+	if !containsString(grainsOperations, r.Operation) {
+		return fmt.Errorf("invalid operation: %s, supported operations: %s", r.Operation, strings.Join(grainsOperations, ", "))
+	}
+	if r.Operation == GRAINS_REMOVE {
+		if len(r.Keys) == 0 {
+			return errors.New("no keys were specified to remove")
+		}
+	} else if len(r.Grains) == 0 && r.Operation != GRAINS_REPLACE {
+		return errors.New("no grains were specified in the request")
+	}
+	for key := range r.Grains {
+		if !grainKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid grain key: %s", key)
+		}
+	}
+	for _, key := range r.Keys {
+		if !grainKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid grain key: %s", key)
+		}
+	}
+	return nil
+}
+
+// normalizeGrainValue converts the values decoded from yaml or json to the same representation
+func normalizeGrainValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeGrainValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = normalizeGrainValue(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			l = append(l, normalizeGrainValue(item))
+		}
+		return l
+	case []string:
+		l := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			l = append(l, item)
+		}
+		return l
+	case json.Number:
+		// the requests are decoded with UseNumber, so that integers are kept as yaml decodes them
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
+
+// mergeGrainValue merges maps recursively and lists as a union, other values are overwritten
+func mergeGrainValue(current interface{}, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if c, ok := current.(map[string]interface{}); ok {
+			for key, item := range v {
+				c[key] = mergeGrainValue(c[key], item)
+			}
+			return c
+		}
+	case []interface{}:
+		if c, ok := current.([]interface{}); ok {
+			for _, item := range v {
+				if !containsGrainValue(c, item) {
+					c = append(c, item)
+				}
+			}
+			return c
+		}
+	}
+	return value
+}
+
+func containsGrainValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func readGrains(file string) (map[string]interface{}, []byte, error) {
+	content, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return make(map[string]interface{}), nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	var grains map[string]interface{}
+	if err := yaml.Unmarshal(content, &grains); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse grains file %s: %s", file, err.Error())
+	}
+	if grains == nil {
+		grains = make(map[string]interface{})
+	}
+	return normalizeGrainValue(grains).(map[string]interface{}), content, nil
+}
+
+func readManagedGrainKeys(file string) ([]string, []byte, error) {
+	content, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	var keys []string
+	if err := yaml.Unmarshal(content, &keys); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse managed grains file %s: %s", file, err.Error())
+	}
+	return keys, content, nil
+}
+
+func readPrewarmedRoles(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var roles []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if s := strings.TrimSpace(scanner.Text()); len(s) > 0 {
+			roles = append(roles, s)
+		}
+	}
+	return roles, scanner.Err()
+}
+
+func addManagedGrainKey(managed []string, key string) []string {
+	if containsString(managed, key) {
+		return managed
+	}
+	return append(managed, key)
+}
+
+func removeManagedGrainKey(managed []string, key string) (result []string) {
+	for _, k := range managed {
+		if k != key {
+			result = append(result, k)
+		}
+	}
+	return result
+}
+
+// applyGrains updates the grains file of the minion, only the keys managed by saltboot are removed or replaced,
+// the managed keys are tracked in a separate file next to the grains file. The prewarmed roles are merged into the
+// roles of the request if withPrewarmedRoles is set, so that set and replace can drop them.
+func applyGrains(baseDir string, request GrainsRequest, withPrewarmedRoles bool) (GrainsResult, error) {
+	grainsFile := baseDir + GRAINS_FILE
+	managedKeysFile := baseDir + GRAINS_MANAGED_KEYS_FILE
+	grains, currentGrains, err := readGrains(grainsFile)
+	if err != nil {
+		return GrainsResult{}, err
+	}
+	managed, currentManaged, err := readManagedGrainKeys(managedKeysFile)
+	if err != nil {
+		return GrainsResult{}, err
+	}
+
+	if request.Operation == GRAINS_REPLACE {
+		for _, key := range managed {
+			delete(grains, key)
+		}
+		managed = nil
+	}
+	switch request.Operation {
+	case GRAINS_SET, GRAINS_REPLACE:
+		for key, value := range request.Grains {
+			grains[key] = normalizeGrainValue(value)
+			managed = addManagedGrainKey(managed, key)
+		}
+	case GRAINS_MERGE:
+		for key, value := range request.Grains {
+			grains[key] = mergeGrainValue(grains[key], normalizeGrainValue(value))
+			managed = addManagedGrainKey(managed, key)
+		}
+	case GRAINS_REMOVE:
+		for _, key := range request.Keys {
+			if !containsString(managed, key) {
+				log.Printf("[applyGrains] grain %s is not managed by saltboot, keep it", key)
+				continue
+			}
+			delete(grains, key)
+			managed = removeManagedGrainKey(managed, key)
+		}
+	}
+
+	var prewarmedRoles []string
+	if _, ok := request.Grains[rolesGrain]; ok && withPrewarmedRoles {
+		if prewarmedRoles, err = readPrewarmedRoles(baseDir + PREWARMED_ROLES_FILE); err != nil {
+			return GrainsResult{}, err
+		}
+		if len(prewarmedRoles) > 0 {
+			log.Printf("[applyGrains] add prewarmed roles: %s", prewarmedRoles)
+			grains[rolesGrain] = mergeGrainValue(grains[rolesGrain], normalizeGrainValue(prewarmedRoles))
+		}
+	}
+
+	sort.Strings(managed)
+	result := GrainsResult{Managed: managed, Grains: grains, PrewarmedRoles: prewarmedRoles}
+	if result.Managed == nil {
+		result.Managed = []string{}
+	}
+	grainsContent, err := yaml.Marshal(grains)
+	if err != nil {
+		return result, err
+	}
+	managedContent, err := yaml.Marshal(result.Managed)
+	if err != nil {
+		return result, err
+	}
+	managedContent = append([]byte(MANAGED_CONFIG_HEADER), managedContent...)
+
+	if !bytes.Equal(grainsContent, currentGrains) {
+		log.Printf("[applyGrains] write grains file: %s", grainsFile)
+		if err := WriteFile(grainsFile, grainsContent, 0644); err != nil {
+			return result, err
+		}
+		result.Changed = true
+	}
+	if !bytes.Equal(managedContent, currentManaged) {
+		if err := WriteFile(managedKeysFile, managedContent, 0644); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func SaltMinionGrainsRequestHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMinionGrainsRequestHandler] execute salt-minion grains request")
+
+	decoder := json.NewDecoder(req.Body)
+	decoder.UseNumber()
+	var grainsRequest GrainsRequest
+	if err := decoder.Decode(&grainsRequest); err != nil {
+		log.Printf("[SaltMinionGrainsRequestHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if err := grainsRequest.Validate(); err != nil {
+		log.Printf("[SaltMinionGrainsRequestHandler] [ERROR] invalid grains request: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+
+	result, err := applyGrains(req.Header.Get("salt-minion-base-dir"), grainsRequest, grainsRequest.Operation == GRAINS_MERGE)
+	if err != nil {
+		log.Printf("[SaltMinionGrainsRequestHandler] [ERROR] unable to update grains: %s", err.Error())
+		model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}.WriteHttp(w)
+		return
+	}
+	// salt-minion picks up the grains file on saltutil.refresh_grains, no restart is needed
+	status := "grains are unchanged"
+	if result.Changed {
+		status = "grains are updated"
+	}
+	log.Printf("[SaltMinionGrainsRequestHandler] %s, managed keys: %s", status, result.Managed)
+	model.Response{Status: status, Payload: result}.WriteHttp(w)
+}
+
+func SaltMinionGrainsDistributeHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMinionGrainsDistributeHandler] distribute salt-minion grains request")
+
+	decoder := json.NewDecoder(req.Body)
+	var grainsRequest GrainsRequest
+	if err := decoder.Decode(&grainsRequest); err != nil {
+		log.Printf("[SaltMinionGrainsDistributeHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(grainsRequest.Targets) == 0 {
+		log.Printf("[SaltMinionGrainsDistributeHandler] [ERROR] no targets were specified in the request")
+		model.Response{Status: "no targets were specified in the request"}.WriteBadRequestHttp(w)
+		return
+	}
+	if err := grainsRequest.Validate(); err != nil {
+		log.Printf("[SaltMinionGrainsDistributeHandler] [ERROR] invalid grains request: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+
+	user, pass := GetAuthUserPass(req)
+	result := distributeToTargetsImpl(DistributeRequest, grainsRequest.Targets, SaltMinionGrainsEP, user, pass, GetSignedRequestBody(req))
+	cResp := model.Responses{Responses: result}
+	log.Printf("[SaltMinionGrainsDistributeHandler] distribute salt-minion grains request executed: %s", cResp.String())
+	if err := json.NewEncoder(w).Encode(cResp); err != nil {
+		log.Printf("[SaltMinionGrainsDistributeHandler] [ERROR] couldn't encode json: %s", err.Error())
+	}
+}
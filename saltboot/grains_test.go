@@ -0,0 +1,170 @@
+package saltboot
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func newGrainsTestDir(t *testing.T, grains string) string {
+	baseDir, _ := os.MkdirTemp("", "grainstest")
+	os.MkdirAll(baseDir+"/etc/salt", 0755)
+	if len(grains) > 0 {
+		os.WriteFile(baseDir+GRAINS_FILE, []byte(grains), 0644)
+	}
+	return baseDir
+}
+
+func readGrainsFixture(t *testing.T, baseDir string) map[string]interface{} {
+	grains, _, err := readGrains(baseDir + GRAINS_FILE)
+	if err != nil {
+		t.Fatalf("unable to read grains: %s", err)
+	}
+	return grains
+}
+
+func TestApplyGrainsKeepsUnmanagedKeys(t *testing.T) {
+	baseDir := newGrainsTestDir(t, "datacenter: dc1\nroles:\n- manual\n")
+	defer os.RemoveAll(baseDir)
+
+	result, err := applyGrains(baseDir, GrainsRequest{Operation: GRAINS_SET, Grains: map[string]interface{}{"roles": []interface{}{"a"}}}, false)
+	if err != nil || !result.Changed {
+		t.Fatalf("grains are expected to be changed: %v %v", result, err)
+	}
+	if _, err := applyGrains(baseDir, GrainsRequest{Operation: GRAINS_REMOVE, Keys: []string{"roles", "datacenter"}}, false); err != nil {
+		t.Fatalf("unable to remove grains: %s", err)
+	}
+
+	grains := readGrainsFixture(t, baseDir)
+	if _, ok := grains["roles"]; ok {
+		t.Error("managed key is expected to be removed")
+	}
+	if grains["datacenter"] != "dc1" {
+		t.Errorf("unmanaged key is expected to be kept: %v", grains)
+	}
+}
+
+func TestApplyGrainsMerge(t *testing.T) {
+	baseDir := newGrainsTestDir(t, "")
+	defer os.RemoveAll(baseDir)
+
+	applyGrains(baseDir, GrainsRequest{Operation: GRAINS_SET, Grains: map[string]interface{}{
+		"roles":  []interface{}{"a", "b"},
+		"labels": map[string]interface{}{"tier": "web", "zone": "1"},
+	}}, false)
+	applyGrains(baseDir, GrainsRequest{Operation: GRAINS_MERGE, Grains: map[string]interface{}{
+		"roles":  []interface{}{"b", "c"},
+		"labels": map[string]interface{}{"zone": "2"},
+	}}, false)
+
+	grains := readGrainsFixture(t, baseDir)
+	if !reflect.DeepEqual(grains["roles"], []interface{}{"a", "b", "c"}) {
+		t.Errorf("roles are expected to be merged: %v", grains["roles"])
+	}
+	if !reflect.DeepEqual(grains["labels"], map[string]interface{}{"tier": "web", "zone": "2"}) {
+		t.Errorf("labels are expected to be merged: %v", grains["labels"])
+	}
+}
+
+func TestApplyGrainsReplace(t *testing.T) {
+	baseDir := newGrainsTestDir(t, "datacenter: dc1\n")
+	defer os.RemoveAll(baseDir)
+
+	applyGrains(baseDir, GrainsRequest{Operation: GRAINS_SET, Grains: map[string]interface{}{"roles": []interface{}{"a"}, "hostgroup": "master"}}, false)
+	result, _ := applyGrains(baseDir, GrainsRequest{Operation: GRAINS_REPLACE, Grains: map[string]interface{}{"roles": []interface{}{"b"}}}, false)
+
+	expected := map[string]interface{}{"datacenter": "dc1", "roles": []interface{}{"b"}}
+	if grains := readGrainsFixture(t, baseDir); !reflect.DeepEqual(grains, expected) {
+		t.Errorf("grains not match %v == %v", expected, grains)
+	}
+	if !reflect.DeepEqual(result.Managed, []string{"roles"}) {
+		t.Errorf("managed keys not match: %v", result.Managed)
+	}
+
+	if result, _ := applyGrains(baseDir, GrainsRequest{Operation: GRAINS_REPLACE, Grains: map[string]interface{}{"roles": []interface{}{"b"}}}, false); result.Changed {
+		t.Error("unchanged grains are reported as changed")
+	}
+}
+
+func TestApplyGrainsPrewarmedRoles(t *testing.T) {
+	baseDir := newGrainsTestDir(t, "")
+	defer os.RemoveAll(baseDir)
+	os.WriteFile(baseDir+PREWARMED_ROLES_FILE, []byte("prewarmed\n\n a \n"), 0644)
+
+	applyGrains(baseDir, GrainsRequest{Operation: GRAINS_SET, Grains: map[string]interface{}{"roles": []string{"a", "b"}}}, true)
+
+	if roles := readGrainsFixture(t, baseDir)["roles"]; !reflect.DeepEqual(roles, []interface{}{"a", "b", "prewarmed"}) {
+		t.Errorf("prewarmed roles are expected to be added: %v", roles)
+	}
+
+	applyGrains(baseDir, GrainsRequest{Operation: GRAINS_REPLACE, Grains: map[string]interface{}{"roles": []string{"b"}}}, false)
+
+	if roles := readGrainsFixture(t, baseDir)["roles"]; !reflect.DeepEqual(roles, []interface{}{"b"}) {
+		t.Errorf("replaced roles are expected to drop the prewarmed roles: %v", roles)
+	}
+}
+
+func TestGrainsRequestValidate(t *testing.T) {
+	invalid := map[string]GrainsRequest{
+		"operation":   {Operation: "append", Grains: map[string]interface{}{"a": 1}},
+		"empty set":   {Operation: GRAINS_SET},
+		"empty keys":  {Operation: GRAINS_REMOVE},
+		"grain key":   {Operation: GRAINS_SET, Grains: map[string]interface{}{"a b": 1}},
+		"removed key": {Operation: GRAINS_REMOVE, Keys: []string{"../a"}},
+	}
+	for name, request := range invalid {
+		if err := request.Validate(); err == nil {
+			t.Errorf("invalid %s is accepted", name)
+		}
+	}
+	if err := (GrainsRequest{Operation: GRAINS_REPLACE}).Validate(); err != nil {
+		t.Errorf("replace with no grains is rejected: %s", err)
+	}
+}
+
+func TestSaltMinionGrainsRequestHandler(t *testing.T) {
+	baseDir := newGrainsTestDir(t, "")
+	defer os.RemoveAll(baseDir)
+
+	body, _ := json.Marshal(GrainsRequest{Operation: GRAINS_SET, Grains: map[string]interface{}{"roles": []string{"a"}}})
+	req := httptest.NewRequest("POST", SaltMinionGrainsEP, bytes.NewReader(body))
+	req.Header.Set("salt-minion-base-dir", baseDir)
+	w := httptest.NewRecorder()
+
+	SaltMinionGrainsRequestHandler(w, req)
+
+	if w.Code != 200 {
+		t.Errorf("status code not match %d == %d: %s", 200, w.Code, w.Body.String())
+	}
+	content, _ := os.ReadFile(baseDir + GRAINS_FILE)
+	var grains GrainConfig
+	if err := yaml.Unmarshal(content, &grains); err != nil || len(grains.Roles) != 1 {
+		t.Errorf("grains not match: %s", content)
+	}
+}
+
+func TestSaltMinionGrainsRequestHandlerKeepsIntegers(t *testing.T) {
+	baseDir := newGrainsTestDir(t, "")
+	defer os.RemoveAll(baseDir)
+
+	body := []byte(`{"operation": "set", "grains": {"rack": 12, "weight": 1.5, "ports": [8080]}}`)
+	req := httptest.NewRequest("POST", SaltMinionGrainsEP, bytes.NewReader(body))
+	req.Header.Set("salt-minion-base-dir", baseDir)
+	w := httptest.NewRecorder()
+
+	SaltMinionGrainsRequestHandler(w, req)
+
+	grains := readGrainsFixture(t, baseDir)
+	if grains["rack"] != 12 || grains["weight"] != 1.5 || !reflect.DeepEqual(grains["ports"], []interface{}{8080}) {
+		t.Errorf("number types are expected to be kept: %v", grains)
+	}
+	content, _ := os.ReadFile(baseDir + GRAINS_FILE)
+	if !bytes.Contains(content, []byte("rack: 12\n")) {
+		t.Errorf("integer is expected to be written as integer: %s", content)
+	}
+}
@@ -10,12 +10,16 @@ import (
 
 const EXAMPLE_DOMAIN = "example.com"
 const HOSTS_FILE = "/etc/hosts"
+
+// HOSTS_ENTRY_MARKER marks the hosts entries written by saltboot, only these are removed on decommission
+const HOSTS_ENTRY_MARKER = "# saltboot"
+
 const NETWORK_SYSCONFIG_FILE = "/etc/sysconfig/network"
 const NETWORK_SYSCONFIG_FILE_SUSE = "/etc/sysconfig/network/config"
 const HOSTNAME_FILE = "/etc/hostname"
 
 var readFile = os.ReadFile
-var writeFile = os.WriteFile
+var writeFile = WriteFile
 
 func getFQDN() (string, error) {
 	return ExecCmd("hostname", "-f")
@@ -96,7 +100,7 @@ func ensureHostIsResolvable(customHostname *string, customDomain string, ipv4add
 		return err
 	}
 	networkSysConfig := NETWORK_SYSCONFIG_FILE
-	if isOs(os, SUSE, SLES12) {
+	if DetectOs(os).IsFamily(OS_FAMILY_SUSE) {
 		networkSysConfig = NETWORK_SYSCONFIG_FILE_SUSE
 	}
 	if err := updateSysConfig(hostName, domain, networkSysConfig); err != nil {
@@ -119,7 +123,7 @@ func updateHostsFile(hostName, domain string, file string, ipv4address string) e
 	hostsFile := string(b)
 	log.Printf("[updateHostsFile] original hosts file: %s", hostsFile)
 
-	ipv4HostString := fmt.Sprintf("%s %s %s", ipv4address, constructFQDN(hostName, domain), getShortHostName(hostName, domain))
+	ipv4HostString := fmt.Sprintf("%s %s %s %s", ipv4address, constructFQDN(hostName, domain), getShortHostName(hostName, domain), HOSTS_ENTRY_MARKER)
 	log.Printf("[updateHostsFile] ipv4HostString: %s", ipv4HostString)
 
 	lines := strings.Split(hostsFile, "\n")
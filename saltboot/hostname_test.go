@@ -47,7 +47,7 @@ func TestHostsFileWriteRemoveExistingIp(t *testing.T) {
 255.255.255.255	broadcasthost
 ::1             localhost
 
-10.0.0.1 hostname-1.example.com hostname-1
+10.0.0.1 hostname-1.example.com hostname-1 # saltboot
 `
 
 	if expected != result {
@@ -87,7 +87,7 @@ func TestHostsFileWriteRemoveExistingIpNotLastLine(t *testing.T) {
 ::1             localhost
 10.0.0.2 hostname-2.compute.internal hostname-2
 
-10.0.0.1 hostname-1.example.com hostname-1
+10.0.0.1 hostname-1.example.com hostname-1 # saltboot
 `
 
 	if expected != result {
@@ -129,7 +129,7 @@ func TestHostsFileWriteRemoveExistingIpMiddleLastLine(t *testing.T) {
 10.0.0.2 hostname-2.compute.internal hostname-2
 10.0.0.3 hostname-3.compute.internal hostname-3
 
-10.0.0.1 hostname-1.example.com hostname-1
+10.0.0.1 hostname-1.example.com hostname-1 # saltboot
 `
 
 	if expected != result {
@@ -170,7 +170,7 @@ func TestHostsFileWriteIpNotPresent(t *testing.T) {
 10.0.0.2 hostname-2
 10.0.0.3 hostname-3
 
-10.0.0.1 hostname-1.example.com hostname-1
+10.0.0.1 hostname-1.example.com hostname-1 # saltboot
 `
 
 	if expected != result {
@@ -212,7 +212,7 @@ func TestHostsFileWriteExistingWithDefaultDomain(t *testing.T) {
 10.0.0.2 hostname-2.compute.internal hostname-2
 10.0.0.3 hostname-3.compute.internal hostname-3
 
-10.0.0.1 hostname-1.compute.internal hostname-1
+10.0.0.1 hostname-1.compute.internal hostname-1 # saltboot
 `
 
 	if expected != result {
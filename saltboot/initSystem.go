@@ -1,35 +1,122 @@
 package saltboot
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-type InitSystem struct {
-	Start           string
-	Stop            string
-	Restart         string
-	Enable          string
-	Disable         string
-	ActionBin       string
-	StateBin        string
-	CommandOrderASC bool
+type InitSystem interface {
+	Name() string
+	Action(service string, action string) (string, error)
+	SetEnabled(service string, enable bool) (string, error)
+	Status(service string) (ServiceStatus, error)
+}
+
+type InitSystemBackend struct {
+	Name string
+	// backends without Detect are never detected, they are used only if they are set in SALTBOOT_INIT_SYSTEM
+	Detect func() bool
+	Create func() InitSystem
 }
 
 const (
 	START_ACTION   = "start"
 	STOP_ACTION    = "stop"
 	RESTART_ACTION = "restart"
+
+	initSystemKey = "SALTBOOT_INIT_SYSTEM"
 )
 
 var stat = os.Stat
 
 var (
-	SYSTEM_D   = InitSystem{ActionBin: "/bin/systemctl", StateBin: "/bin/systemctl", Start: START_ACTION, Stop: STOP_ACTION, Restart: RESTART_ACTION, Enable: "enable", Disable: "disable", CommandOrderASC: true}
-	SYS_V_INIT = InitSystem{ActionBin: "/sbin/service", StateBin: "/sbin/chkconfig", Start: START_ACTION, Stop: STOP_ACTION, Restart: RESTART_ACTION, Enable: "on", Disable: "off", CommandOrderASC: false}
+	SYSTEM_D   = SystemdInitSystem{CommandInitSystem{SystemName: "systemd", ActionBin: "/bin/systemctl", StateBin: "/bin/systemctl", Start: START_ACTION, Stop: STOP_ACTION, Restart: RESTART_ACTION, Enable: "enable", Disable: "disable", CommandOrderASC: true}}
+	SYS_V_INIT = SysVInitSystem{CommandInitSystem{SystemName: "sysvinit", ActionBin: "/sbin/service", StateBin: "/sbin/chkconfig", Start: START_ACTION, Stop: STOP_ACTION, Restart: RESTART_ACTION, Enable: "on", Disable: "off", CommandOrderASC: false}}
+	OPEN_RC    = OpenRCInitSystem{CommandInitSystem{SystemName: "openrc", ActionBin: "/sbin/rc-service", StateBin: "/sbin/rc-update", Start: START_ACTION, Stop: STOP_ACTION, Restart: RESTART_ACTION, Enable: "add", Disable: "del", CommandOrderASC: false}}
 )
 
-func (system InitSystem) ActionCommand(service string, action string) []string {
+var (
+	initSystemBackendsLock sync.Mutex
+	initSystemBackends     []InitSystemBackend
+)
+
+func init() {
+	RegisterInitSystem(InitSystemBackend{Name: SYSTEM_D.Name(), Detect: detectSystemd, Create: createSystemd})
+	RegisterInitSystem(InitSystemBackend{Name: OPEN_RC.Name(), Detect: detectOpenRC, Create: createOpenRC})
+	RegisterInitSystem(InitSystemBackend{Name: SYS_V_INIT.Name(), Detect: detectSysVInit, Create: createSysVInit})
+	// the children of the built-in supervisor do not survive a restart of salt-bootstrap, so it has to be opted in
+	RegisterInitSystem(InitSystemBackend{Name: SUPERVISOR_INIT_SYSTEM, Create: func() InitSystem { return processSupervisor }})
+}
+
+// RegisterInitSystem adds an init system backend. Backends are detected in registration order.
+func RegisterInitSystem(backend InitSystemBackend) {
+	initSystemBackendsLock.Lock()
+	defer initSystemBackendsLock.Unlock()
+	initSystemBackends = append(initSystemBackends, backend)
+}
+
+func GetInitSystem() InitSystem {
+	initSystemBackendsLock.Lock()
+	backends := initSystemBackends
+	initSystemBackendsLock.Unlock()
+
+	if forced := strings.TrimSpace(os.Getenv(initSystemKey)); len(forced) > 0 {
+		for _, backend := range backends {
+			if backend.Name == forced {
+				log.Printf("[GetInitSystem] %s is forced by %s", forced, initSystemKey)
+				return backend.Create()
+			}
+		}
+		log.Printf("[GetInitSystem] [ERROR] unknown init system is set in %s: %s, fallback to detection", initSystemKey, forced)
+	}
+
+	for _, backend := range backends {
+		if backend.Detect != nil && backend.Detect() {
+			log.Printf("[GetInitSystem] %s init system detected", backend.Name)
+			return backend.Create()
+		}
+	}
+	log.Println("[GetInitSystem] no init system detected, assume sysv init")
+	return SYS_V_INIT
+}
+
+func fileExists(path string) bool {
+	_, err := stat(path)
+	return err == nil
+}
+
+func findExecutable(paths ...string) (string, bool) {
+	for _, path := range paths {
+		if fileExists(path) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// CommandInitSystem drives services through the command line tools of the init system
+type CommandInitSystem struct {
+	SystemName      string
+	Start           string
+	Stop            string
+	Restart         string
+	Enable          string
+	Disable         string
+	ActionBin       string
+	StateBin        string
+	CommandOrderASC bool
+}
+
+func (system CommandInitSystem) Name() string {
+	return system.SystemName
+}
+
+func (system CommandInitSystem) ActionCommand(service string, action string) []string {
 	if action == START_ACTION || action == RESTART_ACTION {
 		if action == START_ACTION {
 			if system.CommandOrderASC {
@@ -48,7 +135,7 @@ func (system InitSystem) ActionCommand(service string, action string) []string {
 	return []string{system.ActionBin, service, system.Stop}
 }
 
-func (system InitSystem) StateCommand(service string, enable bool) []string {
+func (system CommandInitSystem) StateCommand(service string, enable bool) []string {
 	if enable {
 		if system.CommandOrderASC {
 			return []string{system.StateBin, system.Enable, service}
@@ -61,15 +148,168 @@ func (system InitSystem) StateCommand(service string, enable bool) []string {
 	return []string{system.StateBin, service, system.Disable}
 }
 
-func (system InitSystem) Error() string {
-	return "Failed to determine init system"
+func (system CommandInitSystem) Action(service string, action string) (string, error) {
+	command := system.ActionCommand(service, action)
+	return ExecCmd(command[0], command[1:]...)
+}
+
+func (system CommandInitSystem) SetEnabled(service string, enable bool) (string, error) {
+	command := system.StateCommand(service, enable)
+	return ExecCmd(command[0], command[1:]...)
+}
+
+type SystemdInitSystem struct {
+	CommandInitSystem
 }
 
-func GetInitSystem() (system InitSystem) {
-	if _, err := stat("/bin/systemctl"); err == nil {
-		log.Println("[GetInitSystem] /bin/systemctl found, assume systemd")
-		return SYSTEM_D
+func detectSystemd() bool {
+	_, found := findExecutable("/bin/systemctl", "/usr/bin/systemctl")
+	// the systemctl binary is also present in containers where systemd is not running
+	return found && fileExists("/run/systemd/system")
+}
+
+func createSystemd() InitSystem {
+	system := SYSTEM_D
+	if systemctl, found := findExecutable("/bin/systemctl", "/usr/bin/systemctl"); found {
+		system.ActionBin = systemctl
+		system.StateBin = systemctl
+	}
+	return system
+}
+
+func (system SystemdInitSystem) Status(service string) (ServiceStatus, error) {
+	status := ServiceStatus{Name: service, InitSystem: system.Name()}
+	out, err := ExecCmd(system.StateBin, "show", service, "--property="+strings.Join(systemdStatusProperties, ","))
+	if err != nil {
+		return status, err
+	}
+	properties := parseKeyValueFile(out)
+	if properties["LoadState"] == "not-found" {
+		return status, fmt.Errorf("service %s is not found", service)
+	}
+	status.State = properties["ActiveState"]
+	status.SubState = properties["SubState"]
+	status.Active = status.State == "active"
+	status.Enabled = strings.HasPrefix(properties["UnitFileState"], "enabled")
+	if pid, err := strconv.Atoi(properties["MainPID"]); err == nil {
+		status.Pid = pid
+	}
+	if exitCode, err := strconv.Atoi(properties["ExecMainStatus"]); err == nil {
+		status.LastExitCode = &exitCode
+	}
+	if status.Active {
+		if since, err := time.Parse(SYSTEMD_TIMESTAMP_LAYOUT, properties["ActiveEnterTimestamp"]); err == nil {
+			status.UptimeSeconds = int64(time.Since(since).Seconds())
+		}
+	}
+	return status, nil
+}
+
+type SysVInitSystem struct {
+	CommandInitSystem
+}
+
+func detectSysVInit() bool {
+	_, found := findExecutable("/sbin/service", "/usr/sbin/service")
+	return found
+}
+
+func createSysVInit() InitSystem {
+	system := SYS_V_INIT
+	if service, found := findExecutable("/sbin/service", "/usr/sbin/service"); found {
+		system.ActionBin = service
+	}
+	if chkconfig, found := findExecutable("/sbin/chkconfig", "/usr/sbin/chkconfig"); found {
+		system.StateBin = chkconfig
+	}
+	return system
+}
+
+func (system SysVInitSystem) Status(service string) (ServiceStatus, error) {
+	status := ServiceStatus{Name: service, InitSystem: system.Name()}
+	if _, err := ExecCmd(system.ActionBin, service, "status"); err == nil {
+		status.Active = true
+		status.State = "active"
+	} else {
+		log.Printf("[SysVInitSystem.Status] %s is not running: %s", service, err.Error())
+		status.State = "inactive"
+	}
+	if out, err := ExecCmd(system.StateBin, "--list", service); err == nil {
+		status.Enabled = strings.Contains(out, ":on")
+	}
+	if status.Active {
+		fillStatusFromPidFile(&status, PID_FILE_LOCATION+service+".pid")
+	}
+	return status, nil
+}
+
+type OpenRCInitSystem struct {
+	CommandInitSystem
+}
+
+func detectOpenRC() bool {
+	_, found := findExecutable("/sbin/rc-service", "/usr/sbin/rc-service", "/bin/rc-service")
+	return found && fileExists("/run/openrc")
+}
+
+func createOpenRC() InitSystem {
+	system := OPEN_RC
+	if rcService, found := findExecutable("/sbin/rc-service", "/usr/sbin/rc-service", "/bin/rc-service"); found {
+		system.ActionBin = rcService
+	}
+	if rcUpdate, found := findExecutable("/sbin/rc-update", "/usr/sbin/rc-update", "/bin/rc-update"); found {
+		system.StateBin = rcUpdate
+	}
+	return system
+}
+
+func (system OpenRCInitSystem) SetEnabled(service string, enable bool) (string, error) {
+	if enable {
+		return ExecCmd(system.StateBin, system.Enable, service, "default")
+	}
+	return ExecCmd(system.StateBin, system.Disable, service, "default")
+}
+
+func (system OpenRCInitSystem) Status(service string) (ServiceStatus, error) {
+	status := ServiceStatus{Name: service, InitSystem: system.Name()}
+	out, err := ExecCmd(system.ActionBin, service, "status")
+	if err == nil {
+		status.Active = true
+	} else {
+		log.Printf("[OpenRCInitSystem.Status] %s is not running: %s", service, err.Error())
+	}
+	// * status: started
+	if idx := strings.Index(out, "status:"); idx >= 0 {
+		status.State = strings.TrimSpace(out[idx+len("status:"):])
+	} else if status.Active {
+		status.State = "started"
+	} else {
+		status.State = "stopped"
+	}
+	if out, err := ExecCmd(system.StateBin, "show", "default"); err == nil {
+		for _, line := range strings.Split(out, "\n") {
+			if strings.TrimSpace(strings.SplitN(line, "|", 2)[0]) == service {
+				status.Enabled = true
+			}
+		}
+	}
+	if status.Active {
+		fillStatusFromPidFile(&status, "/run/"+service+".pid")
+	}
+	return status, nil
+}
+
+func fillStatusFromPidFile(status *ServiceStatus, pidFileLocation string) {
+	pidFile, err := os.ReadFile(pidFileLocation)
+	if err != nil {
+		return
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidFile)))
+	if err != nil {
+		return
+	}
+	status.Pid = pid
+	if proc, err := os.Stat(fmt.Sprintf("/proc/%d", pid)); err == nil {
+		status.UptimeSeconds = int64(time.Since(proc.ModTime()).Seconds())
 	}
-	log.Println("[GetInitSystem] /bin/systemctl not found, assume sysv init")
-	return SYS_V_INIT
 }
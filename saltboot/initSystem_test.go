@@ -19,7 +19,7 @@ func init() {
 }
 
 func TestActionCommandRunCommandOrderASC(t *testing.T) {
-	s := InitSystem{
+	s := CommandInitSystem{
 		ActionBin:       "bin",
 		Start:           "start",
 		CommandOrderASC: true,
@@ -34,7 +34,7 @@ func TestActionCommandRunCommandOrderASC(t *testing.T) {
 }
 
 func TestActionCommandRunCommandOrderDESC(t *testing.T) {
-	s := InitSystem{
+	s := CommandInitSystem{
 		ActionBin:       "bin",
 		Start:           "start",
 		CommandOrderASC: false,
@@ -49,7 +49,7 @@ func TestActionCommandRunCommandOrderDESC(t *testing.T) {
 }
 
 func TestActionCommandCommandOrderASC(t *testing.T) {
-	s := InitSystem{
+	s := CommandInitSystem{
 		ActionBin:       "bin",
 		Stop:            "stop",
 		CommandOrderASC: true,
@@ -64,7 +64,7 @@ func TestActionCommandCommandOrderASC(t *testing.T) {
 }
 
 func TestActionCommandCommandOrderDESC(t *testing.T) {
-	s := InitSystem{
+	s := CommandInitSystem{
 		ActionBin:       "bin",
 		Stop:            "stop",
 		CommandOrderASC: false,
@@ -79,7 +79,7 @@ func TestActionCommandCommandOrderDESC(t *testing.T) {
 }
 
 func TestStateCommandRunCommandOrderASC(t *testing.T) {
-	s := InitSystem{
+	s := CommandInitSystem{
 		StateBin:        "bin",
 		Enable:          "enable",
 		CommandOrderASC: true,
@@ -94,7 +94,7 @@ func TestStateCommandRunCommandOrderASC(t *testing.T) {
 }
 
 func TestStateCommandRunCommandOrderDESC(t *testing.T) {
-	s := InitSystem{
+	s := CommandInitSystem{
 		StateBin:        "bin",
 		Enable:          "enable",
 		CommandOrderASC: false,
@@ -109,7 +109,7 @@ func TestStateCommandRunCommandOrderDESC(t *testing.T) {
 }
 
 func TestStateCommandCommandOrderASC(t *testing.T) {
-	s := InitSystem{
+	s := CommandInitSystem{
 		StateBin:        "bin",
 		Disable:         "disable",
 		CommandOrderASC: true,
@@ -124,7 +124,7 @@ func TestStateCommandCommandOrderASC(t *testing.T) {
 }
 
 func TestStateCommandCommandOrderDESC(t *testing.T) {
-	s := InitSystem{
+	s := CommandInitSystem{
 		StateBin:        "bin",
 		Disable:         "disable",
 		CommandOrderASC: false,
@@ -138,15 +138,82 @@ func TestStateCommandCommandOrderDESC(t *testing.T) {
 	}
 }
 
+func statOnly(existing ...string) func(name string) (os.FileInfo, error) {
+	return func(name string) (os.FileInfo, error) {
+		for _, e := range existing {
+			if e == name {
+				return nil, nil
+			}
+		}
+		return nil, errors.New("file not found")
+	}
+}
+
 func TestGetInitSystemSystemD(t *testing.T) {
 	resp := GetInitSystem()
 
 	if resp != SYSTEM_D {
-		t.Errorf("wrong init system found %s == %s", SYSTEM_D, resp)
+		t.Errorf("wrong init system found %v == %v", SYSTEM_D, resp)
+	}
+}
+
+func TestGetInitSystemSystemDMergedUsr(t *testing.T) {
+	stat = statOnly("/usr/bin/systemctl", "/run/systemd/system")
+	defer func() {
+		stat = initOk
+	}()
+
+	resp := GetInitSystem()
+
+	systemd, ok := resp.(SystemdInitSystem)
+	if !ok {
+		t.Fatalf("wrong init system found %s == %s", SYSTEM_D.Name(), resp.Name())
+	}
+	if systemd.ActionBin != "/usr/bin/systemctl" || systemd.StateBin != "/usr/bin/systemctl" {
+		t.Errorf("systemctl location not match %s == %s", "/usr/bin/systemctl", systemd.ActionBin)
+	}
+}
+
+func TestGetInitSystemSystemctlWithoutRunningSystemd(t *testing.T) {
+	stat = statOnly("/usr/bin/systemctl")
+	defer func() {
+		stat = initOk
+	}()
+
+	resp := GetInitSystem()
+
+	if resp != SYS_V_INIT {
+		t.Errorf("wrong init system found %v == %v", SYS_V_INIT, resp)
 	}
 }
 
 func TestGetInitSystemSystemV(t *testing.T) {
+	stat = statOnly("/sbin/service", "/sbin/chkconfig")
+	defer func() {
+		stat = initOk
+	}()
+
+	resp := GetInitSystem()
+
+	if resp != SYS_V_INIT {
+		t.Errorf("wrong init system found %v == %v", SYS_V_INIT, resp)
+	}
+}
+
+func TestGetInitSystemOpenRC(t *testing.T) {
+	stat = statOnly("/sbin/rc-service", "/sbin/rc-update", "/run/openrc", "/sbin/service")
+	defer func() {
+		stat = initOk
+	}()
+
+	resp := GetInitSystem()
+
+	if resp != OPEN_RC {
+		t.Errorf("wrong init system found %v == %v", OPEN_RC, resp)
+	}
+}
+
+func TestGetInitSystemWithoutInit(t *testing.T) {
 	stat = initError
 	defer func() {
 		stat = initOk
@@ -155,6 +222,71 @@ func TestGetInitSystemSystemV(t *testing.T) {
 	resp := GetInitSystem()
 
 	if resp != SYS_V_INIT {
-		t.Errorf("wrong init system found %s == %s", SYS_V_INIT, resp)
+		t.Errorf("wrong init system found %v == %v", SYS_V_INIT, resp)
+	}
+}
+
+func TestGetInitSystemForced(t *testing.T) {
+	os.Setenv(initSystemKey, OPEN_RC.Name())
+	defer os.Unsetenv(initSystemKey)
+
+	resp := GetInitSystem()
+
+	if resp.Name() != OPEN_RC.Name() {
+		t.Errorf("wrong init system found %s == %s", OPEN_RC.Name(), resp.Name())
+	}
+}
+
+func TestGetInitSystemForcedSupervisor(t *testing.T) {
+	os.Setenv(initSystemKey, SUPERVISOR_INIT_SYSTEM)
+	defer os.Unsetenv(initSystemKey)
+
+	resp := GetInitSystem()
+
+	if resp.Name() != SUPERVISOR_INIT_SYSTEM {
+		t.Errorf("wrong init system found %s == %s", SUPERVISOR_INIT_SYSTEM, resp.Name())
 	}
 }
+
+func TestGetInitSystemForcedUnknown(t *testing.T) {
+	os.Setenv(initSystemKey, "upstart")
+	defer os.Unsetenv(initSystemKey)
+
+	resp := GetInitSystem()
+
+	if resp != SYSTEM_D {
+		t.Errorf("wrong init system found %v == %v", SYSTEM_D, resp)
+	}
+}
+
+func TestOpenRCServiceState(t *testing.T) {
+	watchCommands = true
+	defer func() { watchCommands = false }()
+
+	go func() {
+		OPEN_RC.Action("salt-minion", START_ACTION)
+		OPEN_RC.SetEnabled("salt-minion", true)
+		OPEN_RC.SetEnabled("salt-minion", false)
+	}()
+
+	checkExecutedCommands([]string{
+		"/sbin/rc-service salt-minion start",
+		"/sbin/rc-update add salt-minion default",
+		"/sbin/rc-update del salt-minion default",
+	}, t)
+}
+
+func TestOpenRCServiceStatus(t *testing.T) {
+	outputs := map[string]string{
+		"/sbin/rc-service salt-minion status": " * status: started",
+		"/sbin/rc-update show default":        "          salt-minion | default\n        sshd | default",
+	}
+
+	withCommandOutput(outputs, nil, func() {
+		status, _ := OPEN_RC.Status("salt-minion")
+
+		if !status.Active || !status.Enabled || status.State != "started" {
+			t.Errorf("status not match: %s", status.String())
+		}
+	})
+}
@@ -0,0 +1,468 @@
+package saltboot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+const (
+	KEY_PRESEEDED = "preseeded"
+
+	defaultMinionKeySize = 2048
+	maxMinionKeySize     = 8192
+)
+
+// MinionKeyPreseed is the key of a minion, if the private key is not given, the master generates the key pair
+type MinionKeyPreseed struct {
+	Id         string `json:"id"`
+	Address    string `json:"address"`
+	PrivateKey string `json:"privateKey,omitempty"`
+	PublicKey  string `json:"publicKey,omitempty"`
+	// MinionKeyGrant of the orchestrator signed on its own, the master forwards it unchanged to the minion
+	Grant SignedPayload `json:"grant"`
+}
+
+// MinionKeyGrant allows the master with the fingerprint to install a key of the minion on the node with the address.
+// It does not contain key material, so the minion can check it without receiving the keys of the other minions.
+type MinionKeyGrant struct {
+	Id                string `json:"id"`
+	Address           string `json:"address"`
+	MasterFingerprint string `json:"masterFingerprint"`
+}
+
+// KeyPreseedRequest is only sent to the master, each minion receives its own grant and its key signed by the master
+type KeyPreseedRequest struct {
+	Minions []MinionKeyPreseed `json:"minions"`
+	KeySize int                `json:"keySize,omitempty"`
+}
+
+func (r KeyPreseedRequest) keySize() int {
+	if r.KeySize == 0 {
+		return defaultMinionKeySize
+	}
+	return r.KeySize
+}
+
+// MinionKeyInstallRequest is sent by the master to a single minion. The key is a MinionKeyPreseed signed with the key of
+// the master, the minion trusts the master public key only if its fingerprint is the one in the grant.
+type MinionKeyInstallRequest struct {
+	Grant           SignedPayload `json:"grant"`
+	Key             SignedPayload `json:"key"`
+	MasterPublicKey string        `json:"masterPublicKey"`
+}
+
+type MinionKeyInstallResult struct {
+	Id          string `json:"id"`
+	PublicKey   string `json:"publicKey"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type minionKeyPair struct {
+	privateKeyPem []byte
+	publicKeyPem  []byte
+	fingerprint   string
+}
+
+func generateMinionKeyPair(keySize int) (minionKeyPair, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return minionKeyPair{}, err
+	}
+	return newMinionKeyPair(privateKey)
+}
+
+func newMinionKeyPair(privateKey *rsa.PrivateKey) (minionKeyPair, error) {
+	publicKeyDer, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return minionKeyPair{}, err
+	}
+	pair := minionKeyPair{
+		privateKeyPem: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}),
+		publicKeyPem:  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDer}),
+	}
+	pair.fingerprint, err = pemFingerprint(pair.publicKeyPem)
+	return pair, err
+}
+
+// parseRsaPrivateKey parses a PKCS1 or PKCS8 RSA private key
+func parseRsaPrivateKey(privateKeyPem []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKeyPem)
+	if block == nil {
+		return nil, errors.New("cannot decode private key")
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		if key, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes); pkcs8Err == nil {
+			if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+				privateKey, err = rsaKey, nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key: %s", err.Error())
+	}
+	return privateKey, nil
+}
+
+// parseMinionKeyPair parses a PKCS1 or PKCS8 RSA private key and checks that the public key belongs to it, if it is given
+func parseMinionKeyPair(privateKeyPem string, publicKeyPem string) (minionKeyPair, error) {
+	privateKey, err := parseRsaPrivateKey([]byte(privateKeyPem))
+	if err != nil {
+		return minionKeyPair{}, err
+	}
+	pair, err := newMinionKeyPair(privateKey)
+	if err != nil {
+		return pair, err
+	}
+	if len(publicKeyPem) > 0 {
+		fingerprint, err := pemFingerprint([]byte(publicKeyPem))
+		if err != nil {
+			return pair, fmt.Errorf("cannot parse public key: %s", err.Error())
+		}
+		if fingerprint != pair.fingerprint {
+			return pair, errors.New("public key does not belong to the private key")
+		}
+	}
+	return pair, nil
+}
+
+func installAcceptedKey(pkiDir string, id string, pair minionKeyPair) error {
+	acceptedKey := filepath.Join(pkiDir, MINIONS_ACCEPTED_DIR, id)
+	if err := os.MkdirAll(filepath.Dir(acceptedKey), 0700); err != nil {
+		return err
+	}
+	for _, dir := range []string{MINIONS_PENDING_DIR, MINIONS_REJECTED_DIR} {
+		staleKey := filepath.Join(pkiDir, dir, id)
+		if err := os.Remove(staleKey); err == nil {
+			log.Printf("[installAcceptedKey] removed stale key: %s", staleKey)
+		}
+	}
+	log.Printf("[installAcceptedKey] install accepted key of minion: %s", id)
+	return WriteFile(acceptedKey, pair.publicKeyPem, 0644)
+}
+
+func checkAcceptedKeyConflict(pkiDir string, id string, pair minionKeyPair) error {
+	fingerprint, err := keyFingerprintOf(filepath.Join(pkiDir, MINIONS_ACCEPTED_DIR, id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fingerprint != pair.fingerprint {
+		return errors.New("a different key is already accepted for the minion")
+	}
+	return nil
+}
+
+func minionKeyInstallResultOf(resp model.Response) (MinionKeyInstallResult, error) {
+	var result MinionKeyInstallResult
+	payload, err := json.Marshal(resp.Payload)
+	if err == nil {
+		err = json.Unmarshal(payload, &result)
+	}
+	return result, err
+}
+
+type masterKey struct {
+	privateKeyPem []byte
+	publicKeyPem  []byte
+	fingerprint   string
+}
+
+func readMasterKey(pkiDir string) (masterKey, error) {
+	var key masterKey
+	var err error
+	if key.privateKeyPem, err = os.ReadFile(filepath.Join(pkiDir, MASTER_PRIVATE_KEY)); err != nil {
+		return key, err
+	}
+	if key.publicKeyPem, err = os.ReadFile(filepath.Join(pkiDir, MASTER_PUBLIC_KEY)); err != nil {
+		return key, err
+	}
+	key.fingerprint, err = pemFingerprint(key.publicKeyPem)
+	return key, err
+}
+
+// resolvePreseedKeyPair returns the requested key pair or generates a new one, the grant has to be issued to this master
+func resolvePreseedKeyPair(pkiDir string, master masterKey, minion MinionKeyPreseed, keySize int) (minionKeyPair, error) {
+	var grant MinionKeyGrant
+	if err := json.Unmarshal([]byte(minion.Grant.Payload), &grant); err != nil {
+		return minionKeyPair{}, fmt.Errorf("invalid grant: %s", err.Error())
+	}
+	if grant.Id != minion.Id || grant.Address != minion.Address {
+		return minionKeyPair{}, errors.New("grant is issued to a different minion")
+	}
+	if grant.MasterFingerprint != master.fingerprint {
+		return minionKeyPair{}, errors.New("grant is issued to a different master")
+	}
+	var pair minionKeyPair
+	var err error
+	if len(minion.PrivateKey) > 0 {
+		pair, err = parseMinionKeyPair(minion.PrivateKey, minion.PublicKey)
+	} else if len(minion.PublicKey) > 0 {
+		err = errors.New("public key is given without the private key")
+	} else {
+		log.Printf("[resolvePreseedKeyPair] generate %d bit key pair for minion: %s", keySize, minion.Id)
+		pair, err = generateMinionKeyPair(keySize)
+	}
+	if err != nil {
+		return pair, err
+	}
+	return pair, checkAcceptedKeyConflict(pkiDir, minion.Id, pair)
+}
+
+// minionKeyInstallRequestOf signs the key pair of the minion with the key of the master
+func minionKeyInstallRequestOf(master masterKey, minion MinionKeyPreseed, pair minionKeyPair) (RequestBody, error) {
+	key, err := json.Marshal(MinionKeyPreseed{Id: minion.Id, Address: minion.Address, PrivateKey: string(pair.privateKeyPem), PublicKey: string(pair.publicKeyPem)})
+	if err != nil {
+		return RequestBody{}, err
+	}
+	signature, err := signPayload(master.privateKeyPem, key)
+	if err != nil {
+		return RequestBody{}, err
+	}
+	payload, err := json.Marshal(MinionKeyInstallRequest{Grant: minion.Grant, Key: SignedPayload{Payload: string(key), Signature: signature}, MasterPublicKey: string(master.publicKeyPem)})
+	return RequestBody{PlainPayload: payload}, err
+}
+
+// acceptInstalledKey checks that the minion installed the key pair and accepts its public key on the master
+func acceptInstalledKey(pkiDir string, minion MinionKeyPreseed, pair minionKeyPair, resp model.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to install the key on the minion: %s", resp.ErrorText)
+	}
+	result, err := minionKeyInstallResultOf(resp)
+	if err != nil {
+		return fmt.Errorf("invalid response of the minion: %s", err.Error())
+	}
+	if result.Id != minion.Id {
+		return fmt.Errorf("minion installed the key of %s", result.Id)
+	}
+	if fingerprint, err := pemFingerprint([]byte(result.PublicKey)); err != nil || fingerprint != pair.fingerprint {
+		return errors.New("minion installed a different key than requested")
+	}
+	if err := checkAcceptedKeyConflict(pkiDir, minion.Id, pair); err != nil {
+		return err
+	}
+	return installAcceptedKey(pkiDir, minion.Id, pair)
+}
+
+func preseedMinionKeysImpl(distributeRequest func(map[string]RequestBody, string, string, string, bool) <-chan model.Response,
+	pkiDir string, request KeyPreseedRequest, user string, pass string) []KeyResult {
+
+	results := make([]KeyResult, len(request.Minions))
+	pairs := make(map[string]minionKeyPair)
+	indexByAddress := make(map[string]int)
+	requests := make(map[string]RequestBody)
+	master, masterErr := readMasterKey(pkiDir)
+	for i, minion := range request.Minions {
+		results[i] = KeyResult{Id: minion.Id, Outcome: KEY_FAILED}
+		if masterErr != nil {
+			results[i].ErrorText = "unable to read the key of the master: " + masterErr.Error()
+			continue
+		}
+		if !minionIdPattern.MatchString(minion.Id) {
+			results[i].ErrorText = "invalid minion id"
+			continue
+		}
+		if _, ok := indexByAddress[minion.Address]; ok || len(minion.Address) == 0 {
+			results[i].ErrorText = "missing or duplicated minion address"
+			continue
+		}
+		pair, err := resolvePreseedKeyPair(pkiDir, master, minion, request.keySize())
+		var requestBody RequestBody
+		if err == nil {
+			requestBody, err = minionKeyInstallRequestOf(master, minion, pair)
+		}
+		if err != nil {
+			results[i].ErrorText = err.Error()
+			continue
+		}
+		pairs[minion.Id] = pair
+		indexByAddress[minion.Address] = i
+		requests[minion.Address] = requestBody
+	}
+
+	log.Printf("[preseedMinionKeysImpl] send the key of %d minions", len(requests))
+	for resp := range distributeRequest(requests, SaltMinionKeyInstallEP, user, pass, false) {
+		i, ok := indexByAddress[resp.Address]
+		if !ok {
+			log.Printf("[preseedMinionKeysImpl] [ERROR] response from unknown address: %s", resp.String())
+			continue
+		}
+		pair := pairs[results[i].Id]
+		if err := acceptInstalledKey(pkiDir, request.Minions[i], pair, resp); err != nil {
+			results[i].ErrorText = err.Error()
+			continue
+		}
+		results[i].Fingerprint = pair.fingerprint
+		results[i].Outcome = KEY_PRESEEDED
+	}
+	for i := range results {
+		if results[i].Outcome == KEY_FAILED && len(results[i].ErrorText) == 0 {
+			results[i].ErrorText = "no response from the minion"
+		}
+	}
+	return results
+}
+
+func SaltMasterKeyPreseedHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMasterKeyPreseedHandler] execute preseed minion keys request")
+
+	decoder := json.NewDecoder(req.Body)
+	var preseedRequest KeyPreseedRequest
+	if err := decoder.Decode(&preseedRequest); err != nil {
+		log.Printf("[SaltMasterKeyPreseedHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(preseedRequest.Minions) == 0 {
+		log.Printf("[SaltMasterKeyPreseedHandler] [ERROR] no minions were specified in the request")
+		model.Response{Status: "no minions were specified in the request"}.WriteBadRequestHttp(w)
+		return
+	}
+	if preseedRequest.KeySize != 0 && (preseedRequest.KeySize < defaultMinionKeySize || preseedRequest.KeySize > maxMinionKeySize) {
+		model.Response{Status: fmt.Sprintf("invalid key size: %d", preseedRequest.KeySize)}.WriteBadRequestHttp(w)
+		return
+	}
+	if !HttpsEnabled() {
+		log.Printf("[SaltMasterKeyPreseedHandler] [ERROR] minion keys are only distributed over HTTPS")
+		model.Response{Status: "minion keys are only distributed over HTTPS"}.WriteBadRequestHttp(w)
+		return
+	}
+
+	user, pass := GetAuthUserPass(req)
+	pkiDir := req.Header.Get("salt-master-base-dir") + MASTER_PKI_DIR
+	results := preseedMinionKeysImpl(DistributeTargetedRequest, pkiDir, preseedRequest, user, pass)
+
+	var preseeded int
+	for _, result := range results {
+		if result.Outcome == KEY_PRESEEDED {
+			preseeded++
+		}
+	}
+	status := fmt.Sprintf("%d of %d minion keys are preseeded", preseeded, len(results))
+	log.Printf("[SaltMasterKeyPreseedHandler] %s", status)
+	model.Response{Status: status, Payload: results}.WriteHttp(w)
+}
+
+// verifyMinionKeyInstall checks the grant against the sign verify key and the key against the master public key of the
+// grant, and returns the key if both are issued to this node
+func verifyMinionKeyInstall(signVerifyKey []byte, request MinionKeyInstallRequest) (MinionKeyPreseed, error) {
+	var grant MinionKeyGrant
+	var key MinionKeyPreseed
+	if !CheckSignature(request.Grant.Signature, signVerifyKey, []byte(request.Grant.Payload)) {
+		return key, errors.New("invalid signature of the grant")
+	}
+	if err := json.Unmarshal([]byte(request.Grant.Payload), &grant); err != nil {
+		return key, fmt.Errorf("invalid grant: %s", err.Error())
+	}
+	if err := checkLocalTarget(grant.Address); err != nil {
+		return key, err
+	}
+	if fingerprint, err := pemFingerprint([]byte(request.MasterPublicKey)); err != nil || fingerprint != grant.MasterFingerprint {
+		return key, errors.New("key is not sent by the master of the grant")
+	}
+	if !CheckSignature(request.Key.Signature, []byte(request.MasterPublicKey), []byte(request.Key.Payload)) {
+		return key, errors.New("invalid signature of the key")
+	}
+	if err := json.Unmarshal([]byte(request.Key.Payload), &key); err != nil {
+		return key, fmt.Errorf("invalid key: %s", err.Error())
+	}
+	if key.Id != grant.Id || key.Address != grant.Address || !minionIdPattern.MatchString(key.Id) {
+		return key, errors.New("key is not issued to the minion of the grant")
+	}
+	return key, nil
+}
+
+// installMinionKeyImpl installs the key pair of the minion, a running salt-minion is stopped while its key is replaced
+func installMinionKeyImpl(isServiceRunning func(string) (bool, string), stopService func(string) (model.Response, error),
+	launchService func(string) (model.Response, error), baseDir string, minion MinionKeyPreseed) (minionKeyPair, error) {
+
+	privateKeyFile := baseDir + MinionKey
+	publicKeyFile := baseDir + MinionPublicKey
+	pair, err := parseMinionKeyPair(minion.PrivateKey, minion.PublicKey)
+	if err != nil {
+		return pair, err
+	}
+	pkiDir := filepath.Dir(privateKeyFile)
+	if err := os.MkdirAll(pkiDir, 0700); err != nil {
+		return pair, err
+	}
+	if err := os.Chmod(pkiDir, 0700); err != nil {
+		return pair, err
+	}
+	if current, err := os.ReadFile(privateKeyFile); err == nil && bytes.Equal(current, pair.privateKeyPem) {
+		log.Printf("[installMinionKeyImpl] minion key is already installed: %s", privateKeyFile)
+		return pair, nil
+	}
+
+	running, status := isServiceRunning("salt-minion")
+	if running {
+		log.Printf("[installMinionKeyImpl] salt-minion is running %s, stop it while its key is replaced", status)
+		if _, err := stopService("salt-minion"); err != nil {
+			return pair, fmt.Errorf("unable to stop salt-minion: %s", err.Error())
+		}
+	}
+	// the private key is read-only, so it has to be removed before it can be replaced
+	if err := os.Remove(privateKeyFile); err != nil && !os.IsNotExist(err) {
+		return pair, err
+	}
+	if err := WriteFile(privateKeyFile, pair.privateKeyPem, 0400); err != nil {
+		return pair, err
+	}
+	if err := WriteFile(publicKeyFile, pair.publicKeyPem, 0644); err != nil {
+		return pair, err
+	}
+	log.Printf("[installMinionKeyImpl] minion key is installed for %s with fingerprint: %s", minion.Id, pair.fingerprint)
+	if running {
+		if _, err := launchService("salt-minion"); err != nil {
+			return pair, fmt.Errorf("unable to start salt-minion: %s", err.Error())
+		}
+	}
+	return pair, nil
+}
+
+func SaltMinionKeyInstallHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMinionKeyInstallHandler] execute install minion key request")
+
+	decoder := json.NewDecoder(req.Body)
+	var installRequest MinionKeyInstallRequest
+	if err := decoder.Decode(&installRequest); err != nil {
+		log.Printf("[SaltMinionKeyInstallHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	securityConfig, err := DetermineSecurityDetails(os.Getenv, defaultSecurityConfigLoc)
+	if err != nil {
+		log.Printf("[SaltMinionKeyInstallHandler] [ERROR] failed to get security config: %s", err.Error())
+		model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}.WriteHttp(w)
+		return
+	}
+	minion, err := verifyMinionKeyInstall([]byte(securityConfig.SignVerifyKey), installRequest)
+	if err != nil {
+		log.Printf("[SaltMinionKeyInstallHandler] [ERROR] key is refused: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+
+	pair, err := installMinionKeyImpl(IsServiceRunning, StopService, LaunchService, req.Header.Get("salt-minion-base-dir"), minion)
+	if err != nil {
+		log.Printf("[SaltMinionKeyInstallHandler] [ERROR] unable to install minion key: %s", err.Error())
+		model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}.WriteHttp(w)
+		return
+	}
+	result := MinionKeyInstallResult{Id: minion.Id, PublicKey: string(pair.publicKeyPem), Fingerprint: pair.fingerprint}
+	model.Response{Status: "minion key is installed", Payload: result}.WriteHttp(w)
+}
@@ -0,0 +1,299 @@
+package saltboot
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+type keyPreseedFixture struct {
+	pkiDir       string
+	orchestrator minionKeyPair
+	master       minionKeyPair
+}
+
+func newKeyPreseedFixture(t *testing.T) keyPreseedFixture {
+	pkiDir := t.TempDir()
+	orchestrator, _ := generateMinionKeyPair(defaultMinionKeySize)
+	master, _ := generateMinionKeyPair(defaultMinionKeySize)
+	os.WriteFile(filepath.Join(pkiDir, MASTER_PRIVATE_KEY), master.privateKeyPem, 0400)
+	os.WriteFile(filepath.Join(pkiDir, MASTER_PUBLIC_KEY), master.publicKeyPem, 0644)
+	return keyPreseedFixture{pkiDir: pkiDir, orchestrator: orchestrator, master: master}
+}
+
+func signedTestPayload(privateKeyPem []byte, value interface{}) SignedPayload {
+	payload, _ := json.Marshal(value)
+	signature, _ := signPayload(privateKeyPem, payload)
+	return SignedPayload{Payload: string(payload), Signature: signature}
+}
+
+func (f keyPreseedFixture) minion(id string, address string) MinionKeyPreseed {
+	grant := MinionKeyGrant{Id: id, Address: address, MasterFingerprint: f.master.fingerprint}
+	return MinionKeyPreseed{Id: id, Address: address, Grant: signedTestPayload(f.orchestrator.privateKeyPem, grant)}
+}
+
+// minions verifies and installs the key sent to each target as the minion would, except the failing ones
+func (f keyPreseedFixture) minions(failing map[string]bool, installed map[string]MinionKeyPreseed) func(map[string]RequestBody, string, string, string, bool) <-chan model.Response {
+	return func(requests map[string]RequestBody, endpoint string, user string, pass string, allowFallback bool) <-chan model.Response {
+		c := make(chan model.Response, len(requests))
+		for target, requestBody := range requests {
+			var request MinionKeyInstallRequest
+			json.Unmarshal(requestBody.PlainPayload, &request)
+			minion, err := verifyMinionKeyInstall(f.orchestrator.publicKeyPem, request)
+			if failing[target] || allowFallback || endpoint != SaltMinionKeyInstallEP || err != nil {
+				c <- model.Response{StatusCode: http.StatusInternalServerError, ErrorText: "failed", Address: target}
+				continue
+			}
+			installed[target] = minion
+			pair, _ := parseMinionKeyPair(minion.PrivateKey, minion.PublicKey)
+			result := MinionKeyInstallResult{Id: minion.Id, PublicKey: string(pair.publicKeyPem), Fingerprint: pair.fingerprint}
+			c <- model.Response{StatusCode: http.StatusOK, Address: target, Payload: result}
+		}
+		close(c)
+		return c
+	}
+}
+
+func TestPreseedMinionKeys(t *testing.T) {
+	f := newKeyPreseedFixture(t)
+	fakeLocalNode(t, "minion", "10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5")
+	provided, _ := generateMinionKeyPair(defaultMinionKeySize)
+	writeMinionKey(t, f.pkiDir, MINIONS_PENDING_DIR, "generated")
+
+	providedMinion := f.minion("provided", "10.0.0.2:7070")
+	providedMinion.PrivateKey = string(provided.privateKeyPem)
+	otherMaster := f.minion("other-master", "10.0.0.5")
+	otherMaster.Grant = signedTestPayload(f.orchestrator.privateKeyPem, MinionKeyGrant{Id: "other-master", Address: "10.0.0.5", MasterFingerprint: provided.fingerprint})
+	request := KeyPreseedRequest{Minions: []MinionKeyPreseed{
+		f.minion("generated", "10.0.0.1"),
+		providedMinion,
+		f.minion("unreachable", "10.0.0.3"),
+		f.minion("../invalid", "10.0.0.4"),
+		otherMaster,
+	}}
+
+	installed := make(map[string]MinionKeyPreseed)
+	results := preseedMinionKeysImpl(f.minions(map[string]bool{"10.0.0.3": true}, installed), f.pkiDir, request, "user", "pass")
+
+	expected := []string{KEY_PRESEEDED, KEY_PRESEEDED, KEY_FAILED, KEY_FAILED, KEY_FAILED}
+	for i, outcome := range expected {
+		if results[i].Outcome != outcome {
+			t.Errorf("outcome not match %s == %s", outcome, results[i].String())
+		}
+	}
+	if results[1].Fingerprint != provided.fingerprint || installed["10.0.0.2:7070"].PrivateKey != string(provided.privateKeyPem) {
+		t.Errorf("provided key is expected to be installed: %s", results[1].String())
+	}
+	if installed["10.0.0.1"].Id != "generated" || len(installed["10.0.0.1"].PrivateKey) == 0 {
+		t.Errorf("key generated by the master is expected to be installed: %v", installed["10.0.0.1"])
+	}
+	for _, result := range results[:2] {
+		fingerprint, err := keyFingerprintOf(filepath.Join(f.pkiDir, MINIONS_ACCEPTED_DIR, result.Id))
+		if err != nil || fingerprint != result.Fingerprint {
+			t.Errorf("accepted key of %s not match: %v", result.Id, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(f.pkiDir, MINIONS_PENDING_DIR, "generated")); !os.IsNotExist(err) {
+		t.Error("stale pending key is expected to be removed")
+	}
+	for _, id := range []string{"unreachable", "other-master"} {
+		if _, err := os.Stat(filepath.Join(f.pkiDir, MINIONS_ACCEPTED_DIR, id)); !os.IsNotExist(err) {
+			t.Errorf("key of %s is not expected to be accepted", id)
+		}
+	}
+	if len(installed) != 2 {
+		t.Errorf("key is not expected to be sent to an invalid minion: %v", installed)
+	}
+}
+
+func TestPreseedMinionKeysWithoutMasterKey(t *testing.T) {
+	f := newKeyPreseedFixture(t)
+	os.Remove(filepath.Join(f.pkiDir, MASTER_PRIVATE_KEY))
+	installed := make(map[string]MinionKeyPreseed)
+
+	results := preseedMinionKeysImpl(f.minions(nil, installed), f.pkiDir, KeyPreseedRequest{Minions: []MinionKeyPreseed{f.minion("minion", "10.0.0.1")}}, "user", "pass")
+
+	if results[0].Outcome != KEY_FAILED || len(installed) != 0 {
+		t.Errorf("key is not expected to be sent without the key of the master: %s", results[0].String())
+	}
+}
+
+func TestPreseedMinionKeysConflict(t *testing.T) {
+	f := newKeyPreseedFixture(t)
+	writeMinionKey(t, f.pkiDir, MINIONS_ACCEPTED_DIR, "minion")
+	accepted, _ := keyFingerprintOf(filepath.Join(f.pkiDir, MINIONS_ACCEPTED_DIR, "minion"))
+
+	installed := make(map[string]MinionKeyPreseed)
+	request := KeyPreseedRequest{Minions: []MinionKeyPreseed{f.minion("minion", "10.0.0.1")}}
+	results := preseedMinionKeysImpl(f.minions(nil, installed), f.pkiDir, request, "user", "pass")
+
+	if results[0].Outcome != KEY_FAILED || len(installed) != 0 {
+		t.Errorf("key is not expected to be replaced: %s", results[0].String())
+	}
+	if fingerprint, _ := keyFingerprintOf(filepath.Join(f.pkiDir, MINIONS_ACCEPTED_DIR, "minion")); fingerprint != accepted {
+		t.Error("accepted key is not expected to change")
+	}
+}
+
+func TestPreseedMinionKeysRejectsDifferentKey(t *testing.T) {
+	f := newKeyPreseedFixture(t)
+	other, _ := generateMinionKeyPair(defaultMinionKeySize)
+	request := KeyPreseedRequest{Minions: []MinionKeyPreseed{f.minion("minion", "10.0.0.1")}}
+	distributeRequest := func(requests map[string]RequestBody, endpoint string, user string, pass string, allowFallback bool) <-chan model.Response {
+		c := make(chan model.Response, 1)
+		c <- model.Response{StatusCode: http.StatusOK, Address: "10.0.0.1", Payload: MinionKeyInstallResult{Id: "minion", PublicKey: string(other.publicKeyPem)}}
+		close(c)
+		return c
+	}
+
+	results := preseedMinionKeysImpl(distributeRequest, f.pkiDir, request, "user", "pass")
+
+	if results[0].Outcome != KEY_FAILED {
+		t.Errorf("key different from the requested one is not expected to be accepted: %s", results[0].String())
+	}
+	if _, err := os.Stat(filepath.Join(f.pkiDir, MINIONS_ACCEPTED_DIR, "minion")); !os.IsNotExist(err) {
+		t.Error("key is not expected to be accepted")
+	}
+}
+
+func TestVerifyMinionKeyInstall(t *testing.T) {
+	f := newKeyPreseedFixture(t)
+	fakeLocalNode(t, "minion", "10.0.0.1")
+	attacker, _ := generateMinionKeyPair(defaultMinionKeySize)
+	pair, _ := generateMinionKeyPair(defaultMinionKeySize)
+	requestOf := func(minion MinionKeyPreseed) MinionKeyInstallRequest {
+		body, _ := minionKeyInstallRequestOf(masterKey{privateKeyPem: f.master.privateKeyPem, publicKeyPem: f.master.publicKeyPem}, minion, pair)
+		var request MinionKeyInstallRequest
+		json.Unmarshal(body.PlainPayload, &request)
+		return request
+	}
+
+	request := requestOf(f.minion("minion", "10.0.0.1"))
+	if minion, err := verifyMinionKeyInstall(f.orchestrator.publicKeyPem, request); err != nil || minion.PrivateKey != string(pair.privateKeyPem) {
+		t.Fatalf("key is expected to be accepted: %v", err)
+	}
+
+	replayed := requestOf(f.minion("other", "10.0.0.2"))
+	unsignedGrant := requestOf(f.minion("minion", "10.0.0.1"))
+	unsignedGrant.Grant = signedTestPayload(attacker.privateKeyPem, MinionKeyGrant{Id: "minion", Address: "10.0.0.1", MasterFingerprint: attacker.fingerprint})
+	otherMaster := requestOf(f.minion("minion", "10.0.0.1"))
+	otherMaster.Key = signedTestPayload(attacker.privateKeyPem, MinionKeyPreseed{Id: "minion", Address: "10.0.0.1", PrivateKey: string(attacker.privateKeyPem)})
+	otherMaster.MasterPublicKey = string(attacker.publicKeyPem)
+	otherKey := requestOf(f.minion("minion", "10.0.0.1"))
+	otherKey.Key = signedTestPayload(attacker.privateKeyPem, MinionKeyPreseed{Id: "minion", Address: "10.0.0.1", PrivateKey: string(attacker.privateKeyPem)})
+	otherMinion := requestOf(f.minion("minion", "10.0.0.1"))
+	otherMinion.Key = requestOf(f.minion("other", "10.0.0.1")).Key
+	for name, request := range map[string]MinionKeyInstallRequest{
+		"grant of another node":         replayed,
+		"grant of another signer":       unsignedGrant,
+		"key of another master":         otherMaster,
+		"key with an invalid signature": otherKey,
+		"key of another minion":         otherMinion,
+	} {
+		if _, err := verifyMinionKeyInstall(f.orchestrator.publicKeyPem, request); err == nil {
+			t.Errorf("%s is not expected to be accepted", name)
+		}
+	}
+}
+
+func TestParseMinionKeyPairMismatch(t *testing.T) {
+	pair1, _ := generateMinionKeyPair(defaultMinionKeySize)
+	pair2, _ := generateMinionKeyPair(defaultMinionKeySize)
+
+	if _, err := parseMinionKeyPair(string(pair1.privateKeyPem), string(pair2.publicKeyPem)); err == nil {
+		t.Error("error is expected for a public key of a different private key")
+	}
+	if _, err := parseMinionKeyPair(string(pair1.privateKeyPem), string(pair1.publicKeyPem)); err != nil {
+		t.Errorf("matching key pair is rejected: %s", err)
+	}
+}
+
+type fakeMinionService struct {
+	running bool
+	actions []string
+}
+
+func (s *fakeMinionService) isRunning(string) (bool, string) {
+	return s.running, ""
+}
+
+func (s *fakeMinionService) stop(string) (model.Response, error) {
+	s.running = false
+	s.actions = append(s.actions, STOP_ACTION)
+	return model.Response{}, nil
+}
+
+func (s *fakeMinionService) start(string) (model.Response, error) {
+	s.running = true
+	s.actions = append(s.actions, START_ACTION)
+	return model.Response{}, nil
+}
+
+func installTestMinionKey(baseDir string, pair minionKeyPair) {
+	service := &fakeMinionService{}
+	installMinionKeyImpl(service.isRunning, service.stop, service.start, baseDir, MinionKeyPreseed{Id: "minion", PrivateKey: string(pair.privateKeyPem)})
+}
+
+func TestInstallMinionKey(t *testing.T) {
+	baseDir, _ := os.MkdirTemp("", "keyinstalltest")
+	defer os.RemoveAll(baseDir)
+	pair, _ := generateMinionKeyPair(defaultMinionKeySize)
+	minion := MinionKeyPreseed{Id: "minion", Address: "10.0.0.1", PrivateKey: string(pair.privateKeyPem), PublicKey: string(pair.publicKeyPem)}
+	service := &fakeMinionService{}
+
+	installed, err := installMinionKeyImpl(service.isRunning, service.stop, service.start, baseDir, minion)
+	if err != nil || installed.fingerprint != pair.fingerprint {
+		t.Fatalf("key is expected to be installed: %v", err)
+	}
+
+	expectedModes := map[string]os.FileMode{
+		filepath.Dir(baseDir + MinionKey): os.ModeDir | 0700,
+		baseDir + MinionKey:               0400,
+		baseDir + MinionPublicKey:         0644,
+	}
+	for file, mode := range expectedModes {
+		if info, err := os.Stat(file); err != nil || info.Mode() != mode {
+			t.Errorf("mode of %s not match %s == %v", file, mode, info)
+		}
+	}
+
+	service.running = true
+	if _, err := installMinionKeyImpl(service.isRunning, service.stop, service.start, baseDir, minion); err != nil || len(service.actions) != 0 {
+		t.Errorf("running minion is not expected to be restarted for the same key: %v, %v", service.actions, err)
+	}
+
+	other, _ := generateMinionKeyPair(defaultMinionKeySize)
+	minion = MinionKeyPreseed{Id: "minion", Address: "10.0.0.1", PrivateKey: string(other.privateKeyPem)}
+	if _, err := installMinionKeyImpl(service.isRunning, service.stop, service.start, baseDir, minion); err != nil {
+		t.Fatalf("read-only key is expected to be replaced: %s", err)
+	}
+	if fingerprint, _ := keyFingerprintOf(baseDir + MinionPublicKey); fingerprint != other.fingerprint {
+		t.Error("public key is expected to be replaced")
+	}
+	if len(service.actions) != 2 || service.actions[0] != STOP_ACTION || service.actions[1] != START_ACTION || !service.running {
+		t.Errorf("running minion is expected to be stopped while its key is replaced: %v", service.actions)
+	}
+}
+
+func TestSaltMinionKeyInstallHandlerInvalidGrant(t *testing.T) {
+	t.Setenv(configLocKey, "testdata/.salt-bootstrap/security-config.yml")
+	f := newKeyPreseedFixture(t)
+	pair, _ := generateMinionKeyPair(defaultMinionKeySize)
+	requestBody, _ := minionKeyInstallRequestOf(masterKey{privateKeyPem: f.master.privateKeyPem, publicKeyPem: f.master.publicKeyPem},
+		f.minion("minion", "10.0.0.1"), pair)
+	req := httptest.NewRequest("POST", SaltMinionKeyInstallEP, bytes.NewReader(requestBody.PlainPayload))
+	req.Header.Set("salt-minion-base-dir", t.TempDir())
+	writer := httptest.NewRecorder()
+
+	SaltMinionKeyInstallHandler(writer, req)
+
+	if writer.Code != http.StatusBadRequest {
+		t.Errorf("request is expected to be rejected for a grant not signed by the sign key: %d", writer.Code)
+	}
+}
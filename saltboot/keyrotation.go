@@ -0,0 +1,218 @@
+package saltboot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+const (
+	MINION_KEY_ARCHIVE_DIR = "/var/lib/saltboot/minion-key-archive"
+	MINIONS_DENIED_DIR     = "minions_denied"
+
+	KEY_DELETED = "deleted"
+)
+
+type KeyRotateRequest struct {
+	Targets []string `json:"targets"`
+}
+
+type KeyRotateResult struct {
+	HashType    string `json:"hashType"`
+	Fingerprint string `json:"fingerprint"`
+	Archive     string `json:"archive,omitempty"`
+}
+
+type KeyDeleteRequest struct {
+	Minions []string `json:"minions"`
+	// master addresses, used by the distribute variant only
+	Targets []string `json:"targets,omitempty"`
+}
+
+// archiveMinionKey moves the key pair of the minion into a timestamped archive directory
+func archiveMinionKey(baseDir string) (string, error) {
+	archive := filepath.Join(baseDir+MINION_KEY_ARCHIVE_DIR, strconv.FormatInt(time.Now().UnixNano(), 10))
+	archived := false
+	for _, key := range []string{baseDir + MinionKey, baseDir + MinionPublicKey} {
+		if _, err := os.Stat(key); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.MkdirAll(archive, 0700); err != nil {
+			return "", err
+		}
+		log.Printf("[archiveMinionKey] archive %s to %s", key, archive)
+		if err := os.Rename(key, filepath.Join(archive, filepath.Base(key))); err != nil {
+			return "", err
+		}
+		archived = true
+	}
+	if !archived {
+		return "", nil
+	}
+	return archive, nil
+}
+
+func rotateMinionKeyImpl(stopService func(string) (model.Response, error), launchService func(string) (model.Response, error),
+	baseDir string, hashType string) (KeyRotateResult, error) {
+
+	result := KeyRotateResult{HashType: hashType}
+	if _, err := stopService("salt-minion"); err != nil {
+		return result, fmt.Errorf("unable to stop salt-minion: %s", err.Error())
+	}
+	archive, err := archiveMinionKey(baseDir)
+	if err != nil {
+		return result, fmt.Errorf("unable to archive the minion key: %s", err.Error())
+	}
+	result.Archive = archive
+	// salt-minion generates a new key pair on startup, the readiness probe waits for the public key
+	if _, err := launchService("salt-minion"); err != nil {
+		return result, fmt.Errorf("unable to start salt-minion: %s", err.Error())
+	}
+	fingerprint := minionFingerprint(baseDir+MinionKey, baseDir+MinionPublicKey, hashType)
+	if len(fingerprint.ErrorText) > 0 {
+		return result, fmt.Errorf("unable to compute the new fingerprint: %s", fingerprint.ErrorText)
+	}
+	result.Fingerprint = fingerprint.Status
+	return result, nil
+}
+
+func SaltMinionKeyRotateHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMinionKeyRotateHandler] execute rotate minion key request")
+	hashType := req.URL.Query().Get("hash_type")
+	if len(hashType) == 0 {
+		hashType = DEFAULT_HASH_TYPE
+	}
+	if _, ok := fingerprintHashes[hashType]; !ok {
+		model.Response{Status: "unsupported hash type: " + hashType}.WriteBadRequestHttp(w)
+		return
+	}
+
+	baseDir := req.Header.Get("salt-minion-base-dir")
+	launchService := func(service string) (model.Response, error) {
+		return LaunchServiceAndWait(service, baseDir, determineReadinessTimeout())
+	}
+	result, err := rotateMinionKeyImpl(StopService, launchService, baseDir, hashType)
+	if err != nil {
+		log.Printf("[SaltMinionKeyRotateHandler] [ERROR] %s", err.Error())
+		model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError, Payload: result}.WriteHttp(w)
+		return
+	}
+	log.Printf("[SaltMinionKeyRotateHandler] minion key is rotated, new fingerprint: %s", result.Fingerprint)
+	model.Response{Status: result.Fingerprint, Payload: result}.WriteHttp(w)
+}
+
+func SaltMinionKeyRotateDistributeHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMinionKeyRotateDistributeHandler] distribute rotate minion key request")
+
+	decoder := json.NewDecoder(req.Body)
+	var rotateRequest KeyRotateRequest
+	if err := decoder.Decode(&rotateRequest); err != nil {
+		log.Printf("[SaltMinionKeyRotateDistributeHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(rotateRequest.Targets) == 0 {
+		log.Printf("[SaltMinionKeyRotateDistributeHandler] [ERROR] no targets were specified in the request")
+		model.Response{Status: "no targets were specified in the request"}.WriteBadRequestHttp(w)
+		return
+	}
+
+	endpoint := SaltMinionKeyRotateEP
+	if hashType := req.URL.Query().Get("hash_type"); len(hashType) > 0 {
+		endpoint += "?hash_type=" + url.QueryEscape(hashType)
+	}
+	user, pass := GetAuthUserPass(req)
+	result := distributeToTargetsImpl(DistributeRequest, rotateRequest.Targets, endpoint, user, pass, GetSignedRequestBody(req))
+	cResp := model.Responses{Responses: result}
+	log.Printf("[SaltMinionKeyRotateDistributeHandler] distribute rotate minion key request executed: %s", cResp.String())
+	if err := json.NewEncoder(w).Encode(cResp); err != nil {
+		log.Printf("[SaltMinionKeyRotateDistributeHandler] [ERROR] couldn't encode json: %s", err.Error())
+	}
+}
+
+func deleteMinionKey(pkiDir string, id string) KeyResult {
+	result := KeyResult{Id: id, Outcome: KEY_NOT_FOUND}
+	if !minionIdPattern.MatchString(id) {
+		result.Outcome = KEY_FAILED
+		result.ErrorText = "invalid minion id"
+		return result
+	}
+	for _, dir := range []string{MINIONS_ACCEPTED_DIR, MINIONS_PENDING_DIR, MINIONS_REJECTED_DIR, MINIONS_DENIED_DIR} {
+		key := filepath.Join(pkiDir, dir, id)
+		err := os.Remove(key)
+		if err == nil {
+			log.Printf("[deleteMinionKey] deleted key: %s", key)
+			result.Outcome = KEY_DELETED
+		} else if !os.IsNotExist(err) {
+			result.Outcome = KEY_FAILED
+			result.ErrorText = err.Error()
+			return result
+		}
+	}
+	return result
+}
+
+func SaltMasterKeyDeleteHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMasterKeyDeleteHandler] execute delete minion keys request")
+
+	decoder := json.NewDecoder(req.Body)
+	var deleteRequest KeyDeleteRequest
+	if err := decoder.Decode(&deleteRequest); err != nil {
+		log.Printf("[SaltMasterKeyDeleteHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(deleteRequest.Minions) == 0 {
+		log.Printf("[SaltMasterKeyDeleteHandler] [ERROR] no minions were specified in the request")
+		model.Response{Status: "no minions were specified in the request"}.WriteBadRequestHttp(w)
+		return
+	}
+
+	pkiDir := req.Header.Get("salt-master-base-dir") + MASTER_PKI_DIR
+	var results []KeyResult
+	var deleted int
+	for _, id := range deleteRequest.Minions {
+		result := deleteMinionKey(pkiDir, id)
+		log.Printf("[SaltMasterKeyDeleteHandler] key of %s: %s", id, result.String())
+		if result.Outcome == KEY_DELETED {
+			deleted++
+		}
+		results = append(results, result)
+	}
+	status := fmt.Sprintf("%d of %d minion keys are deleted", deleted, len(results))
+	log.Printf("[SaltMasterKeyDeleteHandler] %s", status)
+	model.Response{Status: status, Payload: results}.WriteHttp(w)
+}
+
+func SaltMasterKeyDeleteDistributeHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMasterKeyDeleteDistributeHandler] distribute delete minion keys request")
+
+	decoder := json.NewDecoder(req.Body)
+	var deleteRequest KeyDeleteRequest
+	if err := decoder.Decode(&deleteRequest); err != nil {
+		log.Printf("[SaltMasterKeyDeleteDistributeHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(deleteRequest.Targets) == 0 {
+		log.Printf("[SaltMasterKeyDeleteDistributeHandler] [ERROR] no targets were specified in the request")
+		model.Response{Status: "no targets were specified in the request"}.WriteBadRequestHttp(w)
+		return
+	}
+
+	user, pass := GetAuthUserPass(req)
+	result := distributeToTargetsImpl(DistributeRequest, deleteRequest.Targets, SaltServerKeyDeleteEP, user, pass, GetSignedRequestBody(req))
+	cResp := model.Responses{Responses: result}
+	log.Printf("[SaltMasterKeyDeleteDistributeHandler] distribute delete minion keys request executed: %s", cResp.String())
+	if err := json.NewEncoder(w).Encode(cResp); err != nil {
+		log.Printf("[SaltMasterKeyDeleteDistributeHandler] [ERROR] couldn't encode json: %s", err.Error())
+	}
+}
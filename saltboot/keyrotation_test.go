@@ -0,0 +1,120 @@
+package saltboot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+func TestRotateMinionKey(t *testing.T) {
+	baseDir, _ := os.MkdirTemp("", "keyrotatetest")
+	defer os.RemoveAll(baseDir)
+	old, _ := generateMinionKeyPair(defaultMinionKeySize)
+	installTestMinionKey(baseDir, old)
+	renewed, _ := generateMinionKeyPair(defaultMinionKeySize)
+
+	var calls []string
+	stop := func(service string) (model.Response, error) {
+		calls = append(calls, "stop "+service)
+		return model.Response{}, nil
+	}
+	launch := func(service string) (model.Response, error) {
+		calls = append(calls, "launch "+service)
+		if _, err := os.Stat(baseDir + MinionKey); !os.IsNotExist(err) {
+			t.Error("old key is expected to be removed before the minion is started")
+		}
+		installTestMinionKey(baseDir, renewed)
+		return model.Response{}, nil
+	}
+
+	result, err := rotateMinionKeyImpl(stop, launch, baseDir, DEFAULT_HASH_TYPE)
+
+	if err != nil || result.Fingerprint != renewed.fingerprint {
+		t.Fatalf("new fingerprint is expected: %v %v", result, err)
+	}
+	if len(calls) != 2 || calls[0] != "stop salt-minion" || calls[1] != "launch salt-minion" {
+		t.Errorf("service calls not match: %v", calls)
+	}
+	if fingerprint, _ := keyFingerprintOf(filepath.Join(result.Archive, filepath.Base(MinionPublicKey))); fingerprint != old.fingerprint {
+		t.Error("old public key is expected to be archived")
+	}
+	if _, err := os.Stat(filepath.Join(result.Archive, filepath.Base(MinionKey))); err != nil {
+		t.Errorf("old private key is expected to be archived: %s", err)
+	}
+}
+
+func TestRotateMinionKeyStartFailure(t *testing.T) {
+	baseDir, _ := os.MkdirTemp("", "keyrotatetest")
+	defer os.RemoveAll(baseDir)
+
+	noop := func(string) (model.Response, error) { return model.Response{}, nil }
+	failing := func(string) (model.Response, error) { return model.Response{}, errors.New("not ready") }
+
+	if _, err := rotateMinionKeyImpl(noop, failing, baseDir, DEFAULT_HASH_TYPE); err == nil {
+		t.Error("error is expected if the minion does not start")
+	}
+	if _, err := rotateMinionKeyImpl(failing, noop, baseDir, DEFAULT_HASH_TYPE); err == nil {
+		t.Error("error is expected if the minion does not stop")
+	}
+}
+
+func TestSaltMasterKeyDeleteHandler(t *testing.T) {
+	baseDir, _ := os.MkdirTemp("", "keydeletetest")
+	defer os.RemoveAll(baseDir)
+	pkiDir := baseDir + MASTER_PKI_DIR
+	writeMinionKey(t, pkiDir, MINIONS_ACCEPTED_DIR, "minion")
+	writeMinionKey(t, pkiDir, MINIONS_PENDING_DIR, "minion")
+	writeMinionKey(t, pkiDir, MINIONS_REJECTED_DIR, "other")
+
+	body, _ := json.Marshal(KeyDeleteRequest{Minions: []string{"minion", "other", "missing", "../escape"}})
+	req := httptest.NewRequest("POST", SaltServerKeyDeleteEP, bytes.NewReader(body))
+	req.Header.Set("salt-master-base-dir", baseDir)
+	w := httptest.NewRecorder()
+
+	SaltMasterKeyDeleteHandler(w, req)
+
+	var resp struct {
+		model.Response
+		Payload []KeyResult `json:"payload"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	expected := []string{KEY_DELETED, KEY_DELETED, KEY_NOT_FOUND, KEY_FAILED}
+	if w.Code != 200 || len(resp.Payload) != len(expected) {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	for i, outcome := range expected {
+		if resp.Payload[i].Outcome != outcome {
+			t.Errorf("outcome not match %s == %s", outcome, resp.Payload[i].String())
+		}
+	}
+	for _, dir := range []string{MINIONS_ACCEPTED_DIR, MINIONS_PENDING_DIR} {
+		if _, err := os.Stat(filepath.Join(pkiDir, dir, "minion")); !os.IsNotExist(err) {
+			t.Errorf("key in %s is expected to be deleted", dir)
+		}
+	}
+}
+
+func TestDistributeToTargets(t *testing.T) {
+	var endpoints []string
+	distribute := func(targets []string, endpoint string, user string, pass string, body RequestBody) <-chan model.Response {
+		endpoints = append(endpoints, endpoint)
+		c := make(chan model.Response, len(targets))
+		for _, target := range targets {
+			c <- model.Response{StatusCode: 200, Address: target}
+		}
+		close(c)
+		return c
+	}
+
+	result := distributeToTargetsImpl(distribute, []string{"10.0.0.1", "10.0.0.2"}, SaltServerKeyDeleteEP, "user", "pass", RequestBody{})
+
+	if len(result) != 2 || endpoints[0] != SaltServerKeyDeleteEP {
+		t.Errorf("request is expected to be sent to all targets: %v %v", result, endpoints)
+	}
+}
@@ -0,0 +1,162 @@
+package saltboot
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	MASTER_CONFIG_DIR = "/etc/salt/master.d"
+
+	maxWorkerThreads = 256
+)
+
+// MasterConfig is the typed subset of the salt-master configuration managed by salt-bootstrap
+type MasterConfig struct {
+	FileRoots     map[string][]string `json:"fileRoots,omitempty"`
+	PillarRoots   map[string][]string `json:"pillarRoots,omitempty"`
+	WorkerThreads int                 `json:"workerThreads,omitempty"`
+	AutoAccept    *bool               `json:"autoAccept,omitempty"`
+	// permissions of saltuser through PAM external auth, e.g. [".*", "@runner", "@wheel", "@jobs"]
+	ExternalAuth []string       `json:"externalAuth,omitempty"`
+	Api          *SaltApiConfig `json:"api,omitempty"`
+}
+
+// SaltApiConfig is rendered into the rest_cherrypy section, TLS uses the cluster certificate of salt-bootstrap
+type SaltApiConfig struct {
+	Port       int    `json:"port,omitempty"`
+	Host       string `json:"host,omitempty"`
+	DisableSsl bool   `json:"disableSsl,omitempty"`
+}
+
+func (c MasterConfig) Validate() error {
+	for name, roots := range map[string]map[string][]string{"file_roots": c.FileRoots, "pillar_roots": c.PillarRoots} {
+		for env, paths := range roots {
+			if !saltEnvPattern.MatchString(env) {
+				return fmt.Errorf("invalid environment in %s: %s", name, env)
+			}
+			if len(paths) == 0 {
+				return fmt.Errorf("no paths were specified for environment %s in %s", env, name)
+			}
+			for _, path := range paths {
+				if !filepath.IsAbs(path) {
+					return fmt.Errorf("path must be absolute in %s: %s", name, path)
+				}
+			}
+		}
+	}
+	if c.WorkerThreads < 0 || c.WorkerThreads > maxWorkerThreads {
+		return fmt.Errorf("invalid worker threads: %d", c.WorkerThreads)
+	}
+	for _, permission := range c.ExternalAuth {
+		if len(permission) == 0 {
+			return fmt.Errorf("empty external auth permission")
+		}
+	}
+	if c.Api != nil && (c.Api.Port < 0 || c.Api.Port > 65535) {
+		return fmt.Errorf("invalid salt-api port: %d", c.Api.Port)
+	}
+	return nil
+}
+
+func rootsAsMapSlice(roots map[string][]string) yaml.MapSlice {
+	var envs []string
+	for env := range roots {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	var result yaml.MapSlice
+	for _, env := range envs {
+		result = append(result, yaml.MapItem{Key: env, Value: roots[env]})
+	}
+	return result
+}
+
+func (c MasterConfig) masterFragments() []configFragment {
+	var roots, tuning, eauth yaml.MapSlice
+	if len(c.FileRoots) > 0 {
+		roots = append(roots, yaml.MapItem{Key: "file_roots", Value: rootsAsMapSlice(c.FileRoots)})
+	}
+	if len(c.PillarRoots) > 0 {
+		roots = append(roots, yaml.MapItem{Key: "pillar_roots", Value: rootsAsMapSlice(c.PillarRoots)})
+	}
+	if c.WorkerThreads > 0 {
+		tuning = append(tuning, yaml.MapItem{Key: "worker_threads", Value: c.WorkerThreads})
+	}
+	if c.AutoAccept != nil {
+		tuning = append(tuning, yaml.MapItem{Key: "auto_accept", Value: *c.AutoAccept})
+	}
+	if len(c.ExternalAuth) > 0 {
+		eauth = yaml.MapSlice{{Key: "external_auth", Value: yaml.MapSlice{{Key: "pam", Value: yaml.MapSlice{{Key: SALT_USER, Value: c.ExternalAuth}}}}}}
+	}
+	return []configFragment{
+		{File: managedConfigPrefix + "roots.conf", Values: roots},
+		{File: managedConfigPrefix + "tuning.conf", Values: tuning},
+		{File: managedConfigPrefix + "eauth.conf", Values: eauth},
+	}
+}
+
+func (c MasterConfig) apiFragments(httpsConfig HttpsConfig) []configFragment {
+	var api yaml.MapSlice
+	if c.Api != nil {
+		var cherrypy yaml.MapSlice
+		if c.Api.Port > 0 {
+			cherrypy = append(cherrypy, yaml.MapItem{Key: "port", Value: c.Api.Port})
+		}
+		if len(c.Api.Host) > 0 {
+			cherrypy = append(cherrypy, yaml.MapItem{Key: "host", Value: c.Api.Host})
+		}
+		if c.Api.DisableSsl {
+			cherrypy = append(cherrypy, yaml.MapItem{Key: "disable_ssl", Value: true})
+		} else {
+			cherrypy = append(cherrypy, yaml.MapItem{Key: "ssl_crt", Value: httpsConfig.CertFile}, yaml.MapItem{Key: "ssl_key", Value: httpsConfig.KeyFile})
+		}
+		api = yaml.MapSlice{{Key: "rest_cherrypy", Value: cherrypy}}
+	}
+	return []configFragment{{File: managedConfigPrefix + "api.conf", Values: api}}
+}
+
+// writeMasterConfig renders the managed master.d fragments and reports whether the salt-master and the salt-api config changed
+func writeMasterConfig(config MasterConfig, configDir string, httpsConfig HttpsConfig) (masterChanged bool, apiChanged bool, err error) {
+	if masterChanged, err = writeConfigFragments(config.masterFragments(), configDir); err != nil {
+		return
+	}
+	apiChanged, err = writeConfigFragments(config.apiFragments(httpsConfig), configDir)
+	return
+}
+
+// saltServerReadinessProbes adjusts the default readiness probes to the configured salt-api host and port
+func saltServerReadinessProbes(service string, config *MasterConfig) []ReadinessProbe {
+	probes := serviceReadinessProbes[service]
+	if service != "salt-api" || config == nil || config.Api == nil || (config.Api.Port == 0 && len(config.Api.Host) == 0) {
+		return probes
+	}
+	return withTcpProbeAddress(probes, config.Api.Host, config.Api.Port)
+}
+
+// withTcpProbeAddress points the TCP probes to the host and port, the loopback address is kept if the host is not set
+// or is a wildcard, the port of the probe is kept if the port is not set
+func withTcpProbeAddress(probes []ReadinessProbe, host string, port int) []ReadinessProbe {
+	var result []ReadinessProbe
+	for _, probe := range probes {
+		if probe.Type == TCP_PROBE {
+			probeHost, probePort, err := net.SplitHostPort(probe.Address)
+			if err == nil {
+				if len(host) > 0 && host != "0.0.0.0" && host != "::" {
+					probeHost = host
+				}
+				if port > 0 {
+					probePort = strconv.Itoa(port)
+				}
+				probe.Address = net.JoinHostPort(probeHost, probePort)
+			}
+		}
+		result = append(result, probe)
+	}
+	return result
+}
@@ -0,0 +1,106 @@
+package saltboot
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestMasterConfigValidate(t *testing.T) {
+	valid := MasterConfig{
+		FileRoots:     map[string][]string{"base": {"/srv/salt"}},
+		PillarRoots:   map[string][]string{"base": {"/srv/pillar"}},
+		WorkerThreads: 8,
+		ExternalAuth:  []string{".*", "@runner"},
+		Api:           &SaltApiConfig{Port: 3080},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("valid config is rejected: %s", err)
+	}
+
+	invalid := map[string]MasterConfig{
+		"environment":    {FileRoots: map[string][]string{"../base": {"/srv/salt"}}},
+		"relative path":  {PillarRoots: map[string][]string{"base": {"srv/pillar"}}},
+		"empty roots":    {FileRoots: map[string][]string{"base": {}}},
+		"worker threads": {WorkerThreads: 1000},
+		"permission":     {ExternalAuth: []string{""}},
+		"api port":       {Api: &SaltApiConfig{Port: -1}},
+	}
+	for name, config := range invalid {
+		if err := config.Validate(); err == nil {
+			t.Errorf("invalid %s is accepted", name)
+		}
+	}
+}
+
+func TestWriteMasterConfig(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "masterconfigtest")
+	defer os.RemoveAll(tempDirName)
+	httpsConfig := HttpsConfig{CertFile: "/etc/certs/cluster.pem", KeyFile: "/etc/certs/cluster-key.pem"}
+	autoAccept := false
+
+	config := MasterConfig{
+		FileRoots:    map[string][]string{"base": {"/srv/salt/base"}, "prod": {"/srv/salt/prod"}},
+		AutoAccept:   &autoAccept,
+		ExternalAuth: []string{".*"},
+		Api:          &SaltApiConfig{Port: 3080},
+	}
+	masterChanged, apiChanged, err := writeMasterConfig(config, tempDirName, httpsConfig)
+	if err != nil || !masterChanged || !apiChanged {
+		t.Fatalf("config is expected to be written, master: %v, api: %v, err: %v", masterChanged, apiChanged, err)
+	}
+
+	content, _ := os.ReadFile(tempDirName + "/saltboot-api.conf")
+	var api map[string]map[string]interface{}
+	yaml.Unmarshal(content, &api)
+	if api["rest_cherrypy"]["ssl_crt"] != httpsConfig.CertFile || api["rest_cherrypy"]["ssl_key"] != httpsConfig.KeyFile || api["rest_cherrypy"]["port"] != 3080 {
+		t.Errorf("salt-api config not match: %s", content)
+	}
+
+	content, _ = os.ReadFile(tempDirName + "/saltboot-eauth.conf")
+	var eauth map[string]map[string]map[string][]string
+	yaml.Unmarshal(content, &eauth)
+	if permissions := eauth["external_auth"]["pam"][SALT_USER]; len(permissions) != 1 || permissions[0] != ".*" {
+		t.Errorf("external auth config not match: %s", content)
+	}
+
+	masterChanged, apiChanged, _ = writeMasterConfig(config, tempDirName, httpsConfig)
+	if masterChanged || apiChanged {
+		t.Errorf("unchanged config is reported as changed, master: %v, api: %v", masterChanged, apiChanged)
+	}
+
+	config.Api.Port = 3081
+	masterChanged, apiChanged, _ = writeMasterConfig(config, tempDirName, httpsConfig)
+	if masterChanged || !apiChanged {
+		t.Errorf("only the salt-api config is expected to change, master: %v, api: %v", masterChanged, apiChanged)
+	}
+}
+
+func TestWithTcpProbeAddress(t *testing.T) {
+	defaults := []ReadinessProbe{{Type: TCP_PROBE, Address: "127.0.0.1:3080"}, {Type: FILE_PROBE, Path: "/etc/salt/api.ready"}}
+
+	probes := withTcpProbeAddress(defaults, "", 9443)
+
+	if len(probes) != 2 || probes[0].Address != "127.0.0.1:9443" || probes[1].Path != "/etc/salt/api.ready" {
+		t.Errorf("salt-api probe is expected to use the configured port: %s", probes)
+	}
+	if defaults[0].Address != "127.0.0.1:3080" {
+		t.Error("default probes are not expected to be modified")
+	}
+	for _, probe := range []struct {
+		host     string
+		port     int
+		expected string
+	}{
+		{"10.0.0.1", 9443, "10.0.0.1:9443"},
+		{"10.0.0.1", 0, "10.0.0.1:3080"},
+		{"0.0.0.0", 9443, "127.0.0.1:9443"},
+		{"::", 0, "127.0.0.1:3080"},
+		{"fd00::1", 9443, "[fd00::1]:9443"},
+	} {
+		if probes := withTcpProbeAddress(defaults, probe.host, probe.port); probes[0].Address != probe.expected {
+			t.Errorf("probe address of %s:%d not match %s == %s", probe.host, probe.port, probe.expected, probes[0].Address)
+		}
+	}
+}
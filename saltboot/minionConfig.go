@@ -0,0 +1,188 @@
+package saltboot
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	MINION_CONFIG_DIR      = "/etc/salt/minion.d"
+	MANAGED_CONFIG_HEADER  = "# Managed by salt-bootstrap, manual changes will be overwritten\n"
+	managedConfigPrefix    = "saltboot-"
+	managedConfigFilePerm  = 0644
+	maxMasterAliveInterval = 86400
+)
+
+var (
+	minionIdPattern    = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+	saltEnvPattern     = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+	fingerprintPattern = regexp.MustCompile(`^([0-9a-fA-F]{2}:)+[0-9a-fA-F]{2}$`)
+	masterTypes        = []string{"str", "failover", "func", "disable"}
+	logLevels          = []string{"all", "garbage", "trace", "debug", "profile", "info", "warning", "error", "critical", "quiet"}
+)
+
+// MinionConfig is the typed subset of the salt-minion configuration managed by salt-bootstrap.
+// Every key is read by salt-minion on startup only, so changing any of them requires a restart.
+type MinionConfig struct {
+	Id                  string `json:"id,omitempty"`
+	MasterPort          int    `json:"masterPort,omitempty"`
+	MasterType          string `json:"masterType,omitempty"`
+	MasterAliveInterval int    `json:"masterAliveInterval,omitempty"`
+	SaltEnv             string `json:"saltenv,omitempty"`
+	LogLevel            string `json:"logLevel,omitempty"`
+	MasterFinger        string `json:"masterFinger,omitempty"`
+	HashType            string `json:"hashType,omitempty"`
+}
+
+type configFragment struct {
+	File   string
+	Values yaml.MapSlice
+}
+
+func (c MinionConfig) Validate(masters []string) error {
+	if len(c.Id) > 0 && !minionIdPattern.MatchString(c.Id) {
+		return fmt.Errorf("invalid minion id: %s", c.Id)
+	}
+	if c.MasterPort < 0 || c.MasterPort > 65535 {
+		return fmt.Errorf("invalid master port: %d", c.MasterPort)
+	}
+	if len(c.MasterType) > 0 && !containsString(masterTypes, c.MasterType) {
+		return fmt.Errorf("invalid master type: %s, supported types: %s", c.MasterType, masterTypes)
+	}
+	if c.MasterType == "failover" && len(masters) < 2 {
+		return fmt.Errorf("failover master type requires at least 2 masters, got: %s", masters)
+	}
+	if c.MasterAliveInterval < 0 || c.MasterAliveInterval > maxMasterAliveInterval {
+		return fmt.Errorf("invalid master alive interval: %d", c.MasterAliveInterval)
+	}
+	if len(c.SaltEnv) > 0 && !saltEnvPattern.MatchString(c.SaltEnv) {
+		return fmt.Errorf("invalid saltenv: %s", c.SaltEnv)
+	}
+	if len(c.LogLevel) > 0 && !containsString(logLevels, c.LogLevel) {
+		return fmt.Errorf("invalid log level: %s, supported levels: %s", c.LogLevel, logLevels)
+	}
+	if len(c.MasterFinger) > 0 && !fingerprintPattern.MatchString(c.MasterFinger) {
+		return fmt.Errorf("invalid master fingerprint: %s", c.MasterFinger)
+	}
+	hashType := DEFAULT_HASH_TYPE
+	if len(c.HashType) > 0 {
+		hashType = c.HashType
+	}
+	newHash, ok := fingerprintHashes[hashType]
+	if !ok {
+		return fmt.Errorf("unsupported hash type: %s", c.HashType)
+	}
+	// every byte of the digest is written as two hex digits separated by colons
+	if len(c.MasterFinger) > 0 && len(c.MasterFinger) != newHash().Size()*3-1 {
+		return fmt.Errorf("master fingerprint does not match the %s hash type: %s", hashType, c.MasterFinger)
+	}
+	return nil
+}
+
+// pinMasterFingerprint sets master_finger from the expected fingerprints of the masters. Salt can pin a single
+// master fingerprint only, so every master has to share the same key (as in multi-master setups).
+func pinMasterFingerprint(config *MinionConfig, fingerprints []string) (*MinionConfig, error) {
+	var pinned MinionConfig
+	if config != nil {
+		pinned = *config
+	}
+	masterFinger := normalizeFingerprint(fingerprints[0])
+	for _, fingerprint := range fingerprints[1:] {
+		if normalizeFingerprint(fingerprint) != masterFinger {
+			return nil, fmt.Errorf("masters have different fingerprints, but only a single master_finger can be pinned: %s", fingerprints)
+		}
+	}
+	if len(pinned.MasterFinger) > 0 && normalizeFingerprint(pinned.MasterFinger) != masterFinger {
+		return nil, fmt.Errorf("master fingerprint of the config %s does not match the expected master fingerprint %s", pinned.MasterFinger, masterFinger)
+	}
+	pinned.MasterFinger = masterFinger
+	return &pinned, nil
+}
+
+func (c MinionConfig) fragments() []configFragment {
+	var identity, connection, environment, logging yaml.MapSlice
+	if len(c.Id) > 0 {
+		identity = append(identity, yaml.MapItem{Key: "id", Value: c.Id})
+	}
+	if c.MasterPort > 0 {
+		connection = append(connection, yaml.MapItem{Key: "master_port", Value: c.MasterPort})
+	}
+	if len(c.MasterType) > 0 {
+		connection = append(connection, yaml.MapItem{Key: "master_type", Value: c.MasterType})
+	}
+	if c.MasterAliveInterval > 0 {
+		connection = append(connection, yaml.MapItem{Key: "master_alive_interval", Value: c.MasterAliveInterval})
+	}
+	if len(c.MasterFinger) > 0 {
+		connection = append(connection, yaml.MapItem{Key: "master_finger", Value: c.MasterFinger})
+	}
+	if len(c.HashType) > 0 {
+		connection = append(connection, yaml.MapItem{Key: "hash_type", Value: c.HashType})
+	}
+	if len(c.SaltEnv) > 0 {
+		environment = append(environment, yaml.MapItem{Key: "saltenv", Value: c.SaltEnv})
+	}
+	if len(c.LogLevel) > 0 {
+		logging = append(logging, yaml.MapItem{Key: "log_level", Value: c.LogLevel})
+	}
+	return []configFragment{
+		{File: managedConfigPrefix + "id.conf", Values: identity},
+		{File: managedConfigPrefix + "connection.conf", Values: connection},
+		{File: managedConfigPrefix + "environment.conf", Values: environment},
+		{File: managedConfigPrefix + "logging.conf", Values: logging},
+	}
+}
+
+// writeMinionConfig renders the managed minion.d fragments and reports whether any of them changed
+func writeMinionConfig(config MinionConfig, configDir string) (bool, error) {
+	return writeConfigFragments(config.fragments(), configDir)
+}
+
+func writeConfigFragments(fragments []configFragment, configDir string) (bool, error) {
+	changed := false
+	for _, fragment := range fragments {
+		fragmentChanged, err := writeConfigFragment(configDir+"/"+fragment.File, fragment.Values)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || fragmentChanged
+	}
+	return changed, nil
+}
+
+func writeConfigFragment(file string, values yaml.MapSlice) (bool, error) {
+	current, readErr := os.ReadFile(file)
+	if len(values) == 0 {
+		if readErr != nil {
+			return false, nil
+		}
+		log.Printf("[writeConfigFragment] remove config fragment: %s", file)
+		return true, os.Remove(file)
+	}
+
+	content, err := yaml.Marshal(values)
+	if err != nil {
+		return false, err
+	}
+	content = append([]byte(MANAGED_CONFIG_HEADER), content...)
+	if readErr == nil && bytes.Equal(current, content) {
+		log.Printf("[writeConfigFragment] config fragment is unchanged: %s", file)
+		return false, nil
+	}
+	log.Printf("[writeConfigFragment] write config fragment: %s", file)
+	return true, WriteFile(file, content, managedConfigFilePerm)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
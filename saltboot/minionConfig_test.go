@@ -0,0 +1,97 @@
+package saltboot
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestMinionConfigValidate(t *testing.T) {
+	masters := []string{"10.0.0.1", "10.0.0.2"}
+	valid := MinionConfig{Id: "node1.example.com", MasterPort: 4506, MasterType: "failover", MasterAliveInterval: 30,
+		SaltEnv: "base", LogLevel: "warning", MasterFinger: "ba:30:65:2a:d6:9e:20:4f:d8:b2:f3:a7:d4:65:50:10", HashType: "md5"}
+	if err := valid.Validate(masters); err != nil {
+		t.Errorf("valid config is rejected: %s", err)
+	}
+
+	invalid := map[string]MinionConfig{
+		"id":             {Id: "node 1"},
+		"port":           {MasterPort: 70000},
+		"master type":    {MasterType: "random"},
+		"alive interval": {MasterAliveInterval: -1},
+		"saltenv":        {SaltEnv: "../base"},
+		"log level":      {LogLevel: "verbose"},
+		"fingerprint":    {MasterFinger: "not-a-fingerprint"},
+		"hash type":      {HashType: "crc32"},
+		"finger length":  {MasterFinger: "ba:30:65:2a:d6:9e:20:4f:d8:b2:f3:a7:d4:65:50:10"},
+	}
+	for name, config := range invalid {
+		if err := config.Validate(masters); err == nil {
+			t.Errorf("invalid %s is accepted", name)
+		}
+	}
+
+	if err := (MinionConfig{MasterType: "failover"}).Validate([]string{"10.0.0.1"}); err == nil {
+		t.Error("failover with a single master is accepted")
+	}
+}
+
+func TestWriteMinionConfig(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "minionconfigtest")
+	defer os.RemoveAll(tempDirName)
+
+	config := MinionConfig{Id: "node1", MasterPort: 4506, MasterType: "failover", LogLevel: "info"}
+	changed, err := writeMinionConfig(config, tempDirName)
+	if err != nil || !changed {
+		t.Fatalf("config is expected to be written, changed: %v, err: %v", changed, err)
+	}
+
+	content, _ := os.ReadFile(tempDirName + "/saltboot-connection.conf")
+	if !strings.HasPrefix(string(content), MANAGED_CONFIG_HEADER) {
+		t.Errorf("managed header is missing: %s", content)
+	}
+	var connection map[string]interface{}
+	yaml.Unmarshal(content, &connection)
+	if connection["master_port"] != 4506 || connection["master_type"] != "failover" {
+		t.Errorf("connection config not match: %s", content)
+	}
+	if _, err := os.Stat(tempDirName + "/saltboot-environment.conf"); !os.IsNotExist(err) {
+		t.Error("empty fragment is not expected to be written")
+	}
+
+	if changed, _ := writeMinionConfig(config, tempDirName); changed {
+		t.Error("unchanged config is reported as changed")
+	}
+
+	config.LogLevel = ""
+	if changed, _ := writeMinionConfig(config, tempDirName); !changed {
+		t.Error("removed key is expected to be reported as a change")
+	}
+	if _, err := os.Stat(tempDirName + "/saltboot-logging.conf"); !os.IsNotExist(err) {
+		t.Error("fragment of the removed key is expected to be deleted")
+	}
+}
+
+func TestPinMasterFingerprint(t *testing.T) {
+	finger := "ba:30:65:2a:d6:9e:20:4f:d8:b2:f3:a7:d4:65:50:10"
+
+	config, err := pinMasterFingerprint(nil, []string{strings.ToUpper(finger), finger})
+	if err != nil || config.MasterFinger != finger {
+		t.Errorf("master fingerprint is expected to be pinned: %v %v", config, err)
+	}
+
+	original := &MinionConfig{Id: "node1"}
+	config, _ = pinMasterFingerprint(original, []string{finger})
+	if config.Id != "node1" || len(original.MasterFinger) > 0 {
+		t.Errorf("config is expected to be copied with the pinned fingerprint: %v", config)
+	}
+
+	if _, err := pinMasterFingerprint(nil, []string{finger, "aa:bb"}); err == nil {
+		t.Error("error is expected for masters with different fingerprints")
+	}
+	if _, err := pinMasterFingerprint(&MinionConfig{MasterFinger: "aa:bb"}, []string{finger}); err == nil {
+		t.Error("error is expected for a conflicting master_finger in the config")
+	}
+}
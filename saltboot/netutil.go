@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"strconv"
 	"strings"
@@ -36,6 +37,9 @@ const (
 	defaultConfigLoc = "/etc/salt-bootstrap/security-config.yml"
 )
 
+var interfaceAddrs = net.InterfaceAddrs
+var localHostName = os.Hostname
+
 var tlsVersionMap = map[string]uint16{
 	"1.0": tls.VersionTLS10,
 	"1.1": tls.VersionTLS11,
@@ -298,3 +302,29 @@ func DetermineSecurityDetails(getEnv func(key string) string, securityConfig fun
 	}
 	return &config, nil
 }
+
+// checkLocalTarget checks that the target a signed payload is bound to is an address or the host name of this node, so a
+// payload captured for one node is refused by the others. Loopback addresses are refused, every node has them.
+func checkLocalTarget(target string) error {
+	host := target
+	if h, _, err := net.SplitHostPort(target); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.IsLoopback() || ip.IsUnspecified() {
+			return fmt.Errorf("target is not an address of the node: %s", target)
+		}
+		addrs, err := interfaceAddrs()
+		if err != nil {
+			return err
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return nil
+			}
+		}
+	} else if name, err := localHostName(); err == nil && len(host) > 0 && strings.EqualFold(name, host) {
+		return nil
+	}
+	return fmt.Errorf("target is not an address of the node: %s", target)
+}
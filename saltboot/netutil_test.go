@@ -3,6 +3,7 @@ package saltboot
 import (
 	"crypto/tls"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"strings"
@@ -308,3 +309,40 @@ func TestUsernameAndPasswordAndSignkeyFoundByEnvWithMissingEnd(t *testing.T) {
 		t.Errorf("Error message shall contain %s, but %s", "-----END PUBLIC KEY-----", err.Error())
 	}
 }
+
+// fakeLocalNode makes the addresses and the host name the ones of this node until the end of the test
+func fakeLocalNode(t *testing.T, name string, addresses ...string) {
+	interfaceAddrs = func() ([]net.Addr, error) {
+		var addrs []net.Addr
+		for _, address := range addresses {
+			addrs = append(addrs, &net.IPNet{IP: net.ParseIP(address), Mask: net.CIDRMask(24, 32)})
+		}
+		return addrs, nil
+	}
+	localHostName = func() (string, error) {
+		return name, nil
+	}
+	t.Cleanup(func() {
+		interfaceAddrs = net.InterfaceAddrs
+		localHostName = os.Hostname
+	})
+}
+
+func TestCheckLocalTarget(t *testing.T) {
+	fakeLocalNode(t, "node1.example.com", "127.0.0.1", "10.0.0.1")
+
+	for target, local := range map[string]bool{
+		"10.0.0.1":          true,
+		"10.0.0.1:7070":     true,
+		"NODE1.example.com": true,
+		"10.0.0.2":          false,
+		"node2.example.com": false,
+		"127.0.0.1":         false,
+		"0.0.0.0":           false,
+		"":                  false,
+	} {
+		if err := checkLocalTarget(target); (err == nil) != local {
+			t.Errorf("target %s is expected to be local: %t, error: %v", target, local, err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package saltboot
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type OsFamily string
+
+const (
+	OS_FAMILY_DEBIAN  OsFamily = "debian"
+	OS_FAMILY_RHEL    OsFamily = "rhel"
+	OS_FAMILY_SUSE    OsFamily = "suse"
+	OS_FAMILY_UNKNOWN OsFamily = "unknown"
+)
+
+const (
+	OS_RELEASE_FILE          = "/etc/os-release"
+	OS_RELEASE_FILE_FALLBACK = "/usr/lib/os-release"
+)
+
+var osReleaseFiles = []string{OS_RELEASE_FILE, OS_RELEASE_FILE_FALLBACK}
+
+// Maps the os-release ID (or an ID_LIKE entry) to the OS family
+var osFamilyById = map[string]OsFamily{
+	"debian":              OS_FAMILY_DEBIAN,
+	"ubuntu":              OS_FAMILY_DEBIAN,
+	"rhel":                OS_FAMILY_RHEL,
+	"centos":              OS_FAMILY_RHEL,
+	"fedora":              OS_FAMILY_RHEL,
+	"rocky":               OS_FAMILY_RHEL,
+	"almalinux":           OS_FAMILY_RHEL,
+	"ol":                  OS_FAMILY_RHEL,
+	"amzn":                OS_FAMILY_RHEL,
+	"sles":                OS_FAMILY_SUSE,
+	"sles_sap":            OS_FAMILY_SUSE,
+	"sled":                OS_FAMILY_SUSE,
+	"suse":                OS_FAMILY_SUSE,
+	"opensuse":            OS_FAMILY_SUSE,
+	"opensuse-leap":       OS_FAMILY_SUSE,
+	"opensuse-tumbleweed": OS_FAMILY_SUSE,
+}
+
+// Maps the OS name prefixes sent by the orchestrator (e.g. centos7, redhat8, sles12) to the OS family
+var osFamilyByHint = []struct {
+	prefix string
+	id     string
+	family OsFamily
+}{
+	{"ubuntu", "ubuntu", OS_FAMILY_DEBIAN},
+	{"debian", "debian", OS_FAMILY_DEBIAN},
+	{"redhat", "rhel", OS_FAMILY_RHEL},
+	{"rhel", "rhel", OS_FAMILY_RHEL},
+	{"centos", "centos", OS_FAMILY_RHEL},
+	{"rocky", "rocky", OS_FAMILY_RHEL},
+	{"alma", "almalinux", OS_FAMILY_RHEL},
+	{"oracle", "ol", OS_FAMILY_RHEL},
+	{"amazonlinux", "amzn", OS_FAMILY_RHEL},
+	{"sles", "sles", OS_FAMILY_SUSE},
+	{"suse", "sles", OS_FAMILY_SUSE},
+	{"opensuse", "opensuse", OS_FAMILY_SUSE},
+}
+
+type OsInfo struct {
+	Id           string   `json:"id"`
+	IdLike       []string `json:"idLike,omitempty"`
+	VersionId    string   `json:"versionId,omitempty"`
+	MajorVersion int      `json:"majorVersion"`
+	Name         string   `json:"name,omitempty"`
+	PrettyName   string   `json:"prettyName,omitempty"`
+	Family       OsFamily `json:"family"`
+}
+
+func (o OsInfo) String() string {
+	b, _ := json.Marshal(o)
+	return string(b)
+}
+
+func (o OsInfo) IsFamily(families ...OsFamily) bool {
+	for _, family := range families {
+		if o.Family == family {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectOs determines the host OS from os-release. The OS name provided by the orchestrator is only
+// used as a fallback if os-release is not available.
+func DetectOs(hint *Os) OsInfo {
+	osInfo, err := detectOsFromFiles(osReleaseFiles)
+	if err == nil {
+		log.Printf("[DetectOs] host OS is determined from os-release: %s", osInfo.String())
+		return osInfo
+	}
+	log.Printf("[DetectOs] unable to read os-release: %s", err.Error())
+	if hint != nil && len(hint.Name) > 0 {
+		osInfo = osInfoFromHint(hint.Name)
+		log.Printf("[DetectOs] host OS is determined from the requested OS %s: %s", hint.Name, osInfo.String())
+		return osInfo
+	}
+	log.Println("[DetectOs] host OS is unknown")
+	return OsInfo{Family: OS_FAMILY_UNKNOWN}
+}
+
+func detectOsFromFiles(files []string) (OsInfo, error) {
+	err := errors.New("no os-release file is configured")
+	for _, file := range files {
+		var content []byte
+		if content, err = os.ReadFile(file); err == nil {
+			return parseOsRelease(string(content)), nil
+		}
+	}
+	return OsInfo{}, err
+}
+
+func parseOsRelease(content string) OsInfo {
+	values := parseKeyValueFile(content)
+	osInfo := OsInfo{
+		Id:         strings.ToLower(values["ID"]),
+		IdLike:     strings.Fields(strings.ToLower(values["ID_LIKE"])),
+		VersionId:  values["VERSION_ID"],
+		Name:       values["NAME"],
+		PrettyName: values["PRETTY_NAME"],
+		Family:     OS_FAMILY_UNKNOWN,
+	}
+	osInfo.MajorVersion = parseMajorVersion(osInfo.VersionId)
+	if family, ok := osFamilyById[osInfo.Id]; ok {
+		osInfo.Family = family
+	} else {
+		for _, like := range osInfo.IdLike {
+			if family, ok := osFamilyById[like]; ok {
+				osInfo.Family = family
+				break
+			}
+		}
+	}
+	return osInfo
+}
+
+func osInfoFromHint(name string) OsInfo {
+	lowerName := strings.ToLower(name)
+	for _, hint := range osFamilyByHint {
+		if strings.HasPrefix(lowerName, hint.prefix) {
+			versionId := strings.TrimLeft(strings.TrimPrefix(lowerName, hint.prefix), "-_")
+			return OsInfo{Id: hint.id, VersionId: versionId, MajorVersion: parseMajorVersion(versionId), Name: name, Family: hint.family}
+		}
+	}
+	return OsInfo{Name: name, Family: OS_FAMILY_UNKNOWN}
+}
+
+func parseMajorVersion(versionId string) int {
+	digits := 0
+	for digits < len(versionId) && versionId[digits] >= '0' && versionId[digits] <= '9' {
+		digits++
+	}
+	version, err := strconv.Atoi(versionId[:digits])
+	if err != nil {
+		return 0
+	}
+	return version
+}
+
+// Parses shell-like KEY=value files such as /etc/os-release
+func parseKeyValueFile(content string) map[string]string {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		values[strings.TrimSpace(kv[0])] = strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+	}
+	return values
+}
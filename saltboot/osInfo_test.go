@@ -0,0 +1,136 @@
+package saltboot
+
+import (
+	"testing"
+)
+
+const defaultOsReleaseFixture = "testdata/os-release/centos7"
+
+func init() {
+	osReleaseFiles = []string{defaultOsReleaseFixture}
+}
+
+func withOsReleaseFixture(fixture string, test func()) {
+	osReleaseFiles = []string{"testdata/os-release/" + fixture}
+	defer func() {
+		osReleaseFiles = []string{defaultOsReleaseFixture}
+	}()
+	test()
+}
+
+func TestDetectOsFromFixtures(t *testing.T) {
+	testCases := []struct {
+		fixture      string
+		id           string
+		versionId    string
+		majorVersion int
+		family       OsFamily
+	}{
+		{"centos7", "centos", "7", 7, OS_FAMILY_RHEL},
+		{"rhel8", "rhel", "8.8", 8, OS_FAMILY_RHEL},
+		{"rhel9", "rhel", "9.2", 9, OS_FAMILY_RHEL},
+		{"rocky9", "rocky", "9.3", 9, OS_FAMILY_RHEL},
+		{"alma9", "almalinux", "9.3", 9, OS_FAMILY_RHEL},
+		{"amzn2", "amzn", "2", 2, OS_FAMILY_RHEL},
+		{"amzn2023", "amzn", "2023", 2023, OS_FAMILY_RHEL},
+		{"sles12", "sles", "12.5", 12, OS_FAMILY_SUSE},
+		{"sles15", "sles", "15.5", 15, OS_FAMILY_SUSE},
+		{"opensuse-leap15", "opensuse-leap", "15.5", 15, OS_FAMILY_SUSE},
+		{"ubuntu22", "ubuntu", "22.04", 22, OS_FAMILY_DEBIAN},
+		{"debian12", "debian", "12", 12, OS_FAMILY_DEBIAN},
+	}
+
+	for _, tc := range testCases {
+		withOsReleaseFixture(tc.fixture, func() {
+			osInfo := DetectOs(&Os{Name: "ignored-when-os-release-exists"})
+
+			if osInfo.Id != tc.id {
+				t.Errorf("[%s] id not match %s == %s", tc.fixture, tc.id, osInfo.Id)
+			}
+			if osInfo.VersionId != tc.versionId {
+				t.Errorf("[%s] version not match %s == %s", tc.fixture, tc.versionId, osInfo.VersionId)
+			}
+			if osInfo.MajorVersion != tc.majorVersion {
+				t.Errorf("[%s] major version not match %d == %d", tc.fixture, tc.majorVersion, osInfo.MajorVersion)
+			}
+			if osInfo.Family != tc.family {
+				t.Errorf("[%s] family not match %s == %s", tc.fixture, tc.family, osInfo.Family)
+			}
+		})
+	}
+}
+
+func TestDetectOsFallsBackToIdLike(t *testing.T) {
+	osInfo := parseOsRelease("ID=\"my-custom-el\"\nID_LIKE=\"rhel fedora\"\nVERSION_ID=\"9.1\"\n")
+
+	if osInfo.Family != OS_FAMILY_RHEL {
+		t.Errorf("family not match %s == %s", OS_FAMILY_RHEL, osInfo.Family)
+	}
+}
+
+func TestDetectOsUnknown(t *testing.T) {
+	osInfo := parseOsRelease("ID=plan9\n")
+
+	if osInfo.Family != OS_FAMILY_UNKNOWN {
+		t.Errorf("family not match %s == %s", OS_FAMILY_UNKNOWN, osInfo.Family)
+	}
+}
+
+func TestDetectOsFromHintWhenOsReleaseIsMissing(t *testing.T) {
+	testCases := []struct {
+		hint         string
+		id           string
+		majorVersion int
+		family       OsFamily
+	}{
+		{"redhat8", "rhel", 8, OS_FAMILY_RHEL},
+		{"centos7", "centos", 7, OS_FAMILY_RHEL},
+		{"amazonlinux2", "amzn", 2, OS_FAMILY_RHEL},
+		{"sles12sp3", "sles", 12, OS_FAMILY_SUSE},
+		{"ubuntu16", "ubuntu", 16, OS_FAMILY_DEBIAN},
+		{"debian9", "debian", 9, OS_FAMILY_DEBIAN},
+	}
+
+	for _, tc := range testCases {
+		withOsReleaseFixture("missing", func() {
+			osInfo := DetectOs(&Os{Name: tc.hint})
+
+			if osInfo.Id != tc.id || osInfo.MajorVersion != tc.majorVersion || osInfo.Family != tc.family {
+				t.Errorf("[%s] os not match %s %d %s == %s", tc.hint, tc.id, tc.majorVersion, tc.family, osInfo.String())
+			}
+		})
+	}
+}
+
+func TestDetectOsWithoutOsReleaseAndHint(t *testing.T) {
+	withOsReleaseFixture("missing", func() {
+		if osInfo := DetectOs(nil); osInfo.Family != OS_FAMILY_UNKNOWN {
+			t.Errorf("family not match %s == %s", OS_FAMILY_UNKNOWN, osInfo.Family)
+		}
+		if osInfo := DetectOs(&Os{Name: ""}); osInfo.Family != OS_FAMILY_UNKNOWN {
+			t.Errorf("family not match %s == %s", OS_FAMILY_UNKNOWN, osInfo.Family)
+		}
+	})
+}
+
+func TestShouldUseUserAdd(t *testing.T) {
+	testCases := map[string]bool{
+		"centos7":         false,
+		"rhel9":           false,
+		"rocky9":          false,
+		"amzn2023":        false,
+		"sles12":          true,
+		"sles15":          true,
+		"opensuse-leap15": true,
+		"ubuntu22":        true,
+		"debian12":        true,
+	}
+
+	for fixture, expected := range testCases {
+		withOsReleaseFixture(fixture, func() {
+			if actual := shouldUseUserAdd(nil); actual != expected {
+				t.Errorf("[%s] useradd not match %t == %t", fixture, expected, actual)
+			}
+		})
+	}
+}
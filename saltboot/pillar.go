@@ -0,0 +1,394 @@
+package saltboot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	PILLAR_ROOT              = "/srv/pillar"
+	PILLAR_TOP_FILE          = "/top.sls"
+	PILLAR_TOP_MANAGED_FILE  = "/.saltboot_managed_top"
+	PILLAR_RENDERER_JSON     = "json"
+	PILLAR_RENDERER_YAML     = "yaml"
+	PILLAR_TOP_ADD           = "add"
+	PILLAR_TOP_REMOVE        = "remove"
+	defaultPillarTopSaltEnv  = "base"
+	pillarTopMatchKey        = "match"
+	defaultPillarTopMatchKey = "glob"
+)
+
+var (
+	// the empty renderer is the default json renderer
+	pillarRenderers     = []string{"", PILLAR_RENDERER_JSON, PILLAR_RENDERER_YAML}
+	pillarTopOperations = []string{PILLAR_TOP_ADD, PILLAR_TOP_REMOVE}
+	pillarTopMatchers   = []string{"glob", "pcre", "list", "grain", "grain_pcre", "pillar", "pillar_pcre", "ipcidr", "compound", "nodegroup", "data"}
+	pillarNamePattern   = regexp.MustCompile(`^[a-zA-Z0-9_-]+(\.[a-zA-Z0-9_-]+)*$`)
+)
+
+// renderPillar renders the pillar with the shebang of its renderer, pillars with secrets are piped
+// through the decrypting renderer of their encryption, e.g. #!json|nacl
+func renderPillar(pillar SaltPillar) ([]byte, error) {
+	shebang := func(renderer string) []byte {
+		if len(pillar.Secrets) > 0 {
+			return []byte("#!" + renderer + "|" + pillar.encryption() + "\n")
+		}
+		return []byte("#!" + renderer + "\n")
+	}
+	switch pillar.Renderer {
+	case "", PILLAR_RENDERER_JSON:
+		jsn, err := json.MarshalIndent(pillar.Json, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+		return append(shebang(PILLAR_RENDERER_JSON), jsn...), nil
+	case PILLAR_RENDERER_YAML:
+		yml, err := yaml.Marshal(pillar.Json)
+		if err != nil {
+			return nil, err
+		}
+		return append(shebang(PILLAR_RENDERER_YAML), yml...), nil
+	}
+	return nil, fmt.Errorf("unsupported renderer: %s", pillar.Renderer)
+}
+
+type PillarTopRequest struct {
+	// add or remove
+	Operation string `json:"operation"`
+	SaltEnv   string `json:"saltenv,omitempty"`
+	// target expression of the top file entry
+	Target string `json:"target"`
+	// matcher of the target expression, glob if not set
+	Match   string   `json:"match,omitempty"`
+	Pillars []string `json:"pillars"`
+	Targets []string `json:"targets,omitempty"`
+}
+
+// managed pillars of the top file per saltenv and target expression
+type PillarTopEntries map[string]map[string][]string
+
+type PillarTopResult struct {
+	Changed bool             `json:"changed"`
+	Managed PillarTopEntries `json:"managed"`
+}
+
+func (r PillarTopRequest) Validate() error {
+	if !containsString(pillarTopOperations, r.Operation) {
+		return fmt.Errorf("invalid operation: %s, supported operations: %s", r.Operation, strings.Join(pillarTopOperations, ", "))
+	}
+	if len(r.SaltEnv) > 0 && !saltEnvPattern.MatchString(r.SaltEnv) {
+		return fmt.Errorf("invalid saltenv: %s", r.SaltEnv)
+	}
+	if len(strings.TrimSpace(r.Target)) == 0 || strings.ContainsAny(r.Target, "\r\n") {
+		return fmt.Errorf("invalid target expression: %q", r.Target)
+	}
+	if len(r.Match) > 0 && !containsString(pillarTopMatchers, r.Match) {
+		return fmt.Errorf("invalid match: %s, supported matchers: %s", r.Match, strings.Join(pillarTopMatchers, ", "))
+	}
+	if len(r.Pillars) == 0 {
+		return errors.New("no pillars were specified in the request")
+	}
+	for _, pillar := range r.Pillars {
+		if !pillarNamePattern.MatchString(pillar) {
+			return fmt.Errorf("invalid pillar name: %s", pillar)
+		}
+	}
+	return nil
+}
+
+func (r PillarTopRequest) saltEnv() string {
+	if len(r.SaltEnv) == 0 {
+		return defaultPillarTopSaltEnv
+	}
+	return r.SaltEnv
+}
+
+// missingPillarFiles returns the pillars that have neither a <name>.sls nor a <name>/init.sls file under the pillar root
+func missingPillarFiles(pillarRoot string, pillars []string) (missing []string) {
+	for _, pillar := range pillars {
+		path := pillarRoot + "/" + strings.ReplaceAll(pillar, ".", "/")
+		if _, err := os.Stat(path + ".sls"); err == nil {
+			continue
+		}
+		if _, err := os.Stat(path + "/init.sls"); err == nil {
+			continue
+		}
+		missing = append(missing, pillar)
+	}
+	return missing
+}
+
+func mapSliceValue(m yaml.MapSlice, key string) interface{} {
+	for _, item := range m {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+func setMapSliceValue(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, item := range m {
+		if fmt.Sprint(item.Key) == key {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
+
+func removeMapSliceKey(m yaml.MapSlice, key string) (result yaml.MapSlice) {
+	for _, item := range m {
+		if fmt.Sprint(item.Key) != key {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// pillarTopMatch returns the matcher of a top file target, the matcher is a {match: <matcher>} item of the list
+func pillarTopMatch(entries []interface{}) string {
+	for _, entry := range entries {
+		if m, ok := entry.(yaml.MapSlice); ok {
+			if match := mapSliceValue(m, pillarTopMatchKey); match != nil {
+				return fmt.Sprint(match)
+			}
+		}
+	}
+	return defaultPillarTopMatchKey
+}
+
+func pillarTopPillars(entries []interface{}) (pillars []string) {
+	for _, entry := range entries {
+		if pillar, ok := entry.(string); ok {
+			pillars = append(pillars, pillar)
+		}
+	}
+	return pillars
+}
+
+func removePillarTopPillar(entries []interface{}, pillar string) (result []interface{}) {
+	for _, entry := range entries {
+		if entry != pillar {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+func readPillarTop(file string) (yaml.MapSlice, []byte, error) {
+	content, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return yaml.MapSlice{}, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	var top yaml.MapSlice
+	if err := yaml.Unmarshal(content, &top); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse top file %s: %s", file, err.Error())
+	}
+	return top, content, nil
+}
+
+func readPillarTopManaged(file string) (PillarTopEntries, []byte, error) {
+	managed := make(PillarTopEntries)
+	content, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return managed, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if err := yaml.Unmarshal(content, &managed); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse managed top file %s: %s", file, err.Error())
+	}
+	return managed, content, nil
+}
+
+func (e PillarTopEntries) add(saltEnv string, target string, pillar string) {
+	if e[saltEnv] == nil {
+		e[saltEnv] = make(map[string][]string)
+	}
+	if !containsString(e[saltEnv][target], pillar) {
+		e[saltEnv][target] = append(e[saltEnv][target], pillar)
+		sort.Strings(e[saltEnv][target])
+	}
+}
+
+func (e PillarTopEntries) remove(saltEnv string, target string, pillar string) {
+	var pillars []string
+	for _, p := range e[saltEnv][target] {
+		if p != pillar {
+			pillars = append(pillars, p)
+		}
+	}
+	if len(pillars) > 0 {
+		e[saltEnv][target] = pillars
+		return
+	}
+	delete(e[saltEnv], target)
+	if len(e[saltEnv]) == 0 {
+		delete(e, saltEnv)
+	}
+}
+
+// applyPillarTop adds or removes the pillars of a target in the top file, entries not added by saltboot are kept
+func applyPillarTop(pillarRoot string, request PillarTopRequest) (PillarTopResult, error) {
+	topFile := pillarRoot + PILLAR_TOP_FILE
+	managedFile := pillarRoot + PILLAR_TOP_MANAGED_FILE
+	top, currentTop, err := readPillarTop(topFile)
+	if err != nil {
+		return PillarTopResult{}, err
+	}
+	managed, currentManaged, err := readPillarTopManaged(managedFile)
+	if err != nil {
+		return PillarTopResult{}, err
+	}
+
+	saltEnv := request.saltEnv()
+	env, _ := mapSliceValue(top, saltEnv).(yaml.MapSlice)
+	entries, _ := mapSliceValue(env, request.Target).([]interface{})
+
+	switch request.Operation {
+	case PILLAR_TOP_ADD:
+		if len(entries) == 0 && len(request.Match) > 0 && request.Match != defaultPillarTopMatchKey {
+			entries = append(entries, yaml.MapSlice{{Key: pillarTopMatchKey, Value: request.Match}})
+		} else if len(request.Match) > 0 && pillarTopMatch(entries) != request.Match {
+			return PillarTopResult{}, fmt.Errorf("target %s of %s is already matched with %s", request.Target, saltEnv, pillarTopMatch(entries))
+		}
+		for _, pillar := range request.Pillars {
+			if containsString(pillarTopPillars(entries), pillar) {
+				log.Printf("[applyPillarTop] pillar %s is already in the top file for %s", pillar, request.Target)
+				continue
+			}
+			entries = append(entries, pillar)
+			managed.add(saltEnv, request.Target, pillar)
+		}
+	case PILLAR_TOP_REMOVE:
+		for _, pillar := range request.Pillars {
+			if !containsString(managed[saltEnv][request.Target], pillar) {
+				log.Printf("[applyPillarTop] pillar %s of %s is not managed by saltboot, keep it", pillar, request.Target)
+				continue
+			}
+			entries = removePillarTopPillar(entries, pillar)
+			managed.remove(saltEnv, request.Target, pillar)
+		}
+	}
+
+	if len(pillarTopPillars(entries)) > 0 {
+		env = setMapSliceValue(env, request.Target, entries)
+	} else {
+		env = removeMapSliceKey(env, request.Target)
+	}
+	if len(env) > 0 {
+		top = setMapSliceValue(top, saltEnv, env)
+	} else {
+		top = removeMapSliceKey(top, saltEnv)
+	}
+
+	result := PillarTopResult{Managed: managed}
+	topContent, err := yaml.Marshal(top)
+	if err != nil {
+		return result, err
+	}
+	managedContent, err := yaml.Marshal(managed)
+	if err != nil {
+		return result, err
+	}
+	managedContent = append([]byte(MANAGED_CONFIG_HEADER), managedContent...)
+	if err := os.MkdirAll(pillarRoot, 0755); err != nil {
+		return result, err
+	}
+	if !bytes.Equal(topContent, currentTop) {
+		log.Printf("[applyPillarTop] write top file: %s", topFile)
+		if err := WriteFile(topFile, topContent, 0644); err != nil {
+			return result, err
+		}
+		result.Changed = true
+	}
+	if !bytes.Equal(managedContent, currentManaged) {
+		if err := WriteFile(managedFile, managedContent, 0644); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func SaltPillarTopRequestHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltPillarTopRequestHandler] execute salt pillar top request")
+
+	decoder := json.NewDecoder(req.Body)
+	var topRequest PillarTopRequest
+	if err := decoder.Decode(&topRequest); err != nil {
+		log.Printf("[SaltPillarTopRequestHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if err := topRequest.Validate(); err != nil {
+		log.Printf("[SaltPillarTopRequestHandler] [ERROR] invalid top request: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+
+	pillarRoot := req.Header.Get("salt-master-base-dir") + PILLAR_ROOT
+	if topRequest.Operation == PILLAR_TOP_ADD {
+		if missing := missingPillarFiles(pillarRoot, topRequest.Pillars); len(missing) > 0 {
+			log.Printf("[SaltPillarTopRequestHandler] [ERROR] pillar files do not exist: %s", missing)
+			model.Response{Status: "pillar files do not exist: " + strings.Join(missing, ", ")}.WriteBadRequestHttp(w)
+			return
+		}
+	}
+
+	result, err := applyPillarTop(pillarRoot, topRequest)
+	if err != nil {
+		log.Printf("[SaltPillarTopRequestHandler] [ERROR] unable to update the top file: %s", err.Error())
+		model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}.WriteHttp(w)
+		return
+	}
+	status := "top file is unchanged"
+	if result.Changed {
+		status = "top file is updated"
+	}
+	log.Printf("[SaltPillarTopRequestHandler] %s", status)
+	model.Response{Status: status, Payload: result}.WriteHttp(w)
+}
+
+func SaltPillarTopDistributeRequestHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltPillarTopDistributeRequestHandler] distribute salt pillar top request")
+
+	decoder := json.NewDecoder(req.Body)
+	var topRequest PillarTopRequest
+	if err := decoder.Decode(&topRequest); err != nil {
+		log.Printf("[SaltPillarTopDistributeRequestHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(topRequest.Targets) == 0 {
+		log.Printf("[SaltPillarTopDistributeRequestHandler] [ERROR] no targets were specified in the request")
+		model.Response{Status: "no targets were specified in the request"}.WriteBadRequestHttp(w)
+		return
+	}
+	if err := topRequest.Validate(); err != nil {
+		log.Printf("[SaltPillarTopDistributeRequestHandler] [ERROR] invalid top request: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+
+	user, pass := GetAuthUserPass(req)
+	result := distributeToTargetsImpl(DistributeRequest, topRequest.Targets, SaltPillarTopEP, user, pass, GetSignedRequestBody(req))
+	cResp := model.Responses{Responses: result}
+	log.Printf("[SaltPillarTopDistributeRequestHandler] distribute salt pillar top request executed: %s", cResp.String())
+	if err := json.NewEncoder(w).Encode(cResp); err != nil {
+		log.Printf("[SaltPillarTopDistributeRequestHandler] [ERROR] couldn't encode json: %s", err.Error())
+	}
+}
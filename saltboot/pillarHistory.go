@@ -0,0 +1,352 @@
+package saltboot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// previous versions are kept outside of the pillar root, so salt does not render them
+	PILLAR_HISTORY_DIR        = "/srv/pillar_history"
+	pillarHistoryDepthKey     = "SALTBOOT_PILLAR_HISTORY_DEPTH"
+	defaultPillarHistoryDepth = 5
+)
+
+var pillarVersionPattern = regexp.MustCompile(`^([0-9]+)-([0-9a-f]{64})$`)
+
+var errPillarReferenced = errors.New("pillar is referenced by the top file")
+
+type PillarRequest struct {
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"`
+}
+
+type PillarVersion struct {
+	Version   string    `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Hash      string    `json:"hash"`
+}
+
+type PillarFile struct {
+	Path     string          `json:"path"`
+	Hash     string          `json:"hash"`
+	Size     int64           `json:"size"`
+	ModTime  time.Time       `json:"modTime"`
+	Content  string          `json:"content,omitempty"`
+	Versions []PillarVersion `json:"versions"`
+}
+
+func determinePillarHistoryDepth() int {
+	depthStr := os.Getenv(pillarHistoryDepthKey)
+	if depth, err := strconv.Atoi(depthStr); err == nil && depth >= 0 {
+		return depth
+	}
+	return defaultPillarHistoryDepth
+}
+
+func validatePillarPath(path string) error {
+	if !strings.HasSuffix(path, ".sls") {
+		return errors.New("path is not ending with '.sls' suffix")
+	}
+	if !strings.HasPrefix(path, "/") {
+		return errors.New("path is not starting with '/'")
+	}
+	if strings.Contains(path, "..") {
+		return errors.New("path cannot contain '..' characters")
+	}
+	return nil
+}
+
+func pillarHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func pillarHistoryDir(basePath string, path string) string {
+	return basePath + PILLAR_HISTORY_DIR + path
+}
+
+// pillarVersions lists the previous versions of the pillar, the latest comes first
+func pillarVersions(basePath string, path string) ([]PillarVersion, error) {
+	entries, err := os.ReadDir(pillarHistoryDir(basePath, path))
+	if os.IsNotExist(err) {
+		return []PillarVersion{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	versions := []PillarVersion{}
+	for _, entry := range entries {
+		match := pillarVersionPattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		nanos, _ := strconv.ParseInt(match[1], 10, 64)
+		versions = append(versions, PillarVersion{Version: entry.Name(), Timestamp: time.Unix(0, nanos).UTC(), Hash: match[2]})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Timestamp.After(versions[j].Timestamp)
+	})
+	return versions, nil
+}
+
+// archivePillarVersion saves the current content of the pillar into the history unless it equals to the new content,
+// the history is pruned to the configured depth
+func archivePillarVersion(basePath string, path string, newContent []byte) error {
+	current, err := os.ReadFile(basePath + PILLAR_ROOT + path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if newContent != nil && bytes.Equal(current, newContent) {
+		return nil
+	}
+
+	historyDir := pillarHistoryDir(basePath, path)
+	if depth := determinePillarHistoryDepth(); depth > 0 {
+		if err := os.MkdirAll(historyDir, 0700); err != nil {
+			return err
+		}
+		version := fmt.Sprintf("%d-%s", time.Now().UnixNano(), pillarHash(current))
+		log.Printf("[archivePillarVersion] archive %s as version %s", path, version)
+		if err := WriteFile(filepath.Join(historyDir, version), current, 0600); err != nil {
+			return err
+		}
+	}
+	return prunePillarVersions(basePath, path, determinePillarHistoryDepth())
+}
+
+func prunePillarVersions(basePath string, path string, depth int) error {
+	versions, err := pillarVersions(basePath, path)
+	if err != nil {
+		return err
+	}
+	for i := depth; i < len(versions); i++ {
+		log.Printf("[prunePillarVersions] remove version %s of %s", versions[i].Version, path)
+		if err := os.Remove(filepath.Join(pillarHistoryDir(basePath, path), versions[i].Version)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readPillarFile(basePath string, path string, withContent bool) (PillarFile, error) {
+	file := basePath + PILLAR_ROOT + path
+	info, err := os.Stat(file)
+	if err != nil {
+		return PillarFile{}, err
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return PillarFile{}, err
+	}
+	versions, err := pillarVersions(basePath, path)
+	if err != nil {
+		return PillarFile{}, err
+	}
+	pillarFile := PillarFile{Path: path, Hash: pillarHash(content), Size: info.Size(), ModTime: info.ModTime().UTC(), Versions: versions}
+	if withContent {
+		pillarFile.Content = string(content)
+	}
+	return pillarFile, nil
+}
+
+func listPillarFiles(basePath string) ([]PillarFile, error) {
+	root := basePath + PILLAR_ROOT
+	pillars := []PillarFile{}
+	err := filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && file == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sls") {
+			return nil
+		}
+		pillar, err := readPillarFile(basePath, strings.TrimPrefix(file, root), false)
+		if err != nil {
+			return err
+		}
+		pillars = append(pillars, pillar)
+		return nil
+	})
+	return pillars, err
+}
+
+// pillarNameOf returns the name of the pillar file as the top file refers to it, e.g. app.db for /app/db/init.sls
+func pillarNameOf(path string) string {
+	name := strings.TrimSuffix(strings.TrimPrefix(path, "/"), ".sls")
+	name = strings.TrimSuffix(name, "/init")
+	return strings.ReplaceAll(name, "/", ".")
+}
+
+// pillarTopReferences returns the saltenv and target of the top file entries that include the pillar
+func pillarTopReferences(pillarRoot string, name string) ([]string, error) {
+	top, _, err := readPillarTop(pillarRoot + PILLAR_TOP_FILE)
+	if err != nil {
+		return nil, err
+	}
+	var references []string
+	for _, env := range top {
+		targets, _ := env.Value.(yaml.MapSlice)
+		for _, target := range targets {
+			entries, _ := target.Value.([]interface{})
+			if containsString(pillarTopPillars(entries), name) {
+				references = append(references, fmt.Sprintf("%v:%v", env.Key, target.Key))
+			}
+		}
+	}
+	return references, nil
+}
+
+// deletePillar removes the pillar file, pillars still included by the top file are not removed
+func deletePillar(basePath string, path string) error {
+	pillarLock.Lock()
+	defer pillarLock.Unlock()
+
+	references, err := pillarTopReferences(basePath+PILLAR_ROOT, pillarNameOf(path))
+	if err != nil {
+		return err
+	}
+	if len(references) > 0 {
+		return fmt.Errorf("%w: %s is included for %s", errPillarReferenced, pillarNameOf(path), strings.Join(references, ", "))
+	}
+	if err := archivePillarVersion(basePath, path, nil); err != nil {
+		return err
+	}
+	log.Printf("[deletePillar] remove pillar: %s", path)
+	return os.Remove(basePath + PILLAR_ROOT + path)
+}
+
+// rollbackPillar restores a previous version of the pillar, the current content is archived, so the rollback can be reverted
+func rollbackPillar(basePath string, path string, version string) error {
+	pillarLock.Lock()
+	defer pillarLock.Unlock()
+
+	match := pillarVersionPattern.FindStringSubmatch(version)
+	if match == nil {
+		return fmt.Errorf("invalid version: %s", version)
+	}
+	content, err := os.ReadFile(filepath.Join(pillarHistoryDir(basePath, path), version))
+	if err != nil {
+		return err
+	}
+	if pillarHash(content) != match[2] {
+		return fmt.Errorf("content of version %s does not match its hash", version)
+	}
+	if err := archivePillarVersion(basePath, path, content); err != nil {
+		return err
+	}
+	file := basePath + PILLAR_ROOT + path
+	if err := os.MkdirAll(filepath.Dir(file), 0744); err != nil {
+		return err
+	}
+	log.Printf("[rollbackPillar] restore version %s of %s", version, path)
+	return WriteFile(file, content, 0644)
+}
+
+func decodePillarRequest(w http.ResponseWriter, req *http.Request, handler string) (PillarRequest, bool) {
+	decoder := json.NewDecoder(req.Body)
+	var pillarRequest PillarRequest
+	if err := decoder.Decode(&pillarRequest); err != nil {
+		log.Printf("[%s] [ERROR] couldn't decode json: %s", handler, err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return pillarRequest, false
+	}
+	if err := validatePillarPath(pillarRequest.Path); err != nil {
+		log.Printf("[%s] [ERROR] invalid path %s: %s", handler, pillarRequest.Path, err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return pillarRequest, false
+	}
+	return pillarRequest, true
+}
+
+func writePillarError(w http.ResponseWriter, handler string, err error) {
+	log.Printf("[%s] [ERROR] %s", handler, err.Error())
+	statusCode := http.StatusInternalServerError
+	if os.IsNotExist(err) {
+		statusCode = http.StatusNotFound
+	} else if errors.Is(err, errPillarReferenced) {
+		statusCode = http.StatusConflict
+	}
+	model.Response{ErrorText: err.Error(), StatusCode: statusCode}.WriteHttp(w)
+}
+
+func SaltPillarListRequestHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltPillarListRequestHandler] execute salt pillar list request")
+	pillars, err := listPillarFiles(req.Header.Get("salt-master-base-dir"))
+	if err != nil {
+		writePillarError(w, "SaltPillarListRequestHandler", err)
+		return
+	}
+	model.Response{Status: fmt.Sprintf("%d pillar files", len(pillars)), Payload: pillars}.WriteHttp(w)
+}
+
+func SaltPillarGetRequestHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltPillarGetRequestHandler] execute salt pillar get request")
+	pillarRequest, ok := decodePillarRequest(w, req, "SaltPillarGetRequestHandler")
+	if !ok {
+		return
+	}
+	pillar, err := readPillarFile(req.Header.Get("salt-master-base-dir"), pillarRequest.Path, true)
+	if err != nil {
+		writePillarError(w, "SaltPillarGetRequestHandler", err)
+		return
+	}
+	model.Response{Status: pillar.Hash, Payload: pillar}.WriteHttp(w)
+}
+
+func SaltPillarDeleteRequestHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltPillarDeleteRequestHandler] execute salt pillar delete request")
+	pillarRequest, ok := decodePillarRequest(w, req, "SaltPillarDeleteRequestHandler")
+	if !ok {
+		return
+	}
+	if err := deletePillar(req.Header.Get("salt-master-base-dir"), pillarRequest.Path); err != nil {
+		writePillarError(w, "SaltPillarDeleteRequestHandler", err)
+		return
+	}
+	model.Response{Status: "Salt pillar successfully deleted " + pillarRequest.Path}.WriteHttp(w)
+}
+
+func SaltPillarRollbackRequestHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltPillarRollbackRequestHandler] execute salt pillar rollback request")
+	pillarRequest, ok := decodePillarRequest(w, req, "SaltPillarRollbackRequestHandler")
+	if !ok {
+		return
+	}
+	if !pillarVersionPattern.MatchString(pillarRequest.Version) {
+		log.Printf("[SaltPillarRollbackRequestHandler] [ERROR] invalid version: %s", pillarRequest.Version)
+		model.Response{Status: "invalid version: " + pillarRequest.Version}.WriteBadRequestHttp(w)
+		return
+	}
+	basePath := req.Header.Get("salt-master-base-dir")
+	if err := rollbackPillar(basePath, pillarRequest.Path, pillarRequest.Version); err != nil {
+		writePillarError(w, "SaltPillarRollbackRequestHandler", err)
+		return
+	}
+	pillar, err := readPillarFile(basePath, pillarRequest.Path, false)
+	if err != nil {
+		writePillarError(w, "SaltPillarRollbackRequestHandler", err)
+		return
+	}
+	model.Response{Status: "Salt pillar successfully rolled back to " + pillarRequest.Version, Payload: pillar}.WriteHttp(w)
+}
@@ -0,0 +1,133 @@
+package saltboot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func writeTestPillar(t *testing.T, basePath string, path string, value string) {
+	pillar := SaltPillar{Path: path, Json: map[string]interface{}{"key": value}}
+	if _, err := writePillarImpl(pillar, basePath); err != nil {
+		t.Fatalf("unable to write pillar: %s", err)
+	}
+}
+
+func TestPillarHistoryDepth(t *testing.T) {
+	t.Setenv(pillarHistoryDepthKey, "2")
+	basePath, _ := os.MkdirTemp("", "pillarhistorytest")
+	defer os.RemoveAll(basePath)
+
+	for _, value := range []string{"v1", "v2", "v2", "v3", "v4"} {
+		writeTestPillar(t, basePath, "/app/init.sls", value)
+	}
+
+	versions, _ := pillarVersions(basePath, "/app/init.sls")
+	if len(versions) != 2 {
+		t.Fatalf("versions are expected to be pruned to 2: %v", versions)
+	}
+	v2, _ := renderPillar(SaltPillar{Json: map[string]interface{}{"key": "v2"}})
+	if versions[1].Hash != pillarHash(v2) {
+		t.Errorf("oldest kept version is expected to be v2: %v", versions)
+	}
+}
+
+func TestRollbackPillar(t *testing.T) {
+	basePath, _ := os.MkdirTemp("", "pillarhistorytest")
+	defer os.RemoveAll(basePath)
+	writeTestPillar(t, basePath, "/app/init.sls", "v1")
+	writeTestPillar(t, basePath, "/app/init.sls", "v2")
+	versions, _ := pillarVersions(basePath, "/app/init.sls")
+
+	if err := rollbackPillar(basePath, "/app/init.sls", versions[0].Version); err != nil {
+		t.Fatalf("unable to roll back: %s", err)
+	}
+
+	pillar, _ := readPillarFile(basePath, "/app/init.sls", true)
+	if pillar.Hash != versions[0].Hash || len(pillar.Versions) != 2 {
+		t.Errorf("rolled back pillar not match: %v", pillar)
+	}
+	if err := rollbackPillar(basePath, "/app/init.sls", "1-"+versions[0].Hash); err == nil {
+		t.Error("error is expected for a missing version")
+	}
+}
+
+func TestDeletePillarKeepsHistory(t *testing.T) {
+	basePath, _ := os.MkdirTemp("", "pillarhistorytest")
+	defer os.RemoveAll(basePath)
+	writeTestPillar(t, basePath, "/app/init.sls", "v1")
+	writeTestPillar(t, basePath, "/other.sls", "v1")
+
+	if err := deletePillar(basePath, "/app/init.sls"); err != nil {
+		t.Fatalf("unable to delete pillar: %s", err)
+	}
+
+	pillars, _ := listPillarFiles(basePath)
+	if len(pillars) != 1 || pillars[0].Path != "/other.sls" {
+		t.Errorf("pillar list not match: %v", pillars)
+	}
+	versions, _ := pillarVersions(basePath, "/app/init.sls")
+	if len(versions) != 1 {
+		t.Fatalf("deleted pillar is expected to be archived: %v", versions)
+	}
+	if err := rollbackPillar(basePath, "/app/init.sls", versions[0].Version); err != nil {
+		t.Errorf("deleted pillar is expected to be restorable: %s", err)
+	}
+}
+
+func TestDeletePillarReferencedByTop(t *testing.T) {
+	basePath, _ := os.MkdirTemp("", "pillarhistorytest")
+	defer os.RemoveAll(basePath)
+	writeTestPillar(t, basePath, "/app/db/init.sls", "v1")
+	request := PillarTopRequest{Operation: PILLAR_TOP_ADD, Target: "*", Pillars: []string{"app.db"}}
+	if _, err := applyPillarTop(basePath+PILLAR_ROOT, request); err != nil {
+		t.Fatalf("unable to add pillar to the top file: %s", err)
+	}
+
+	if err := deletePillar(basePath, "/app/db/init.sls"); !errors.Is(err, errPillarReferenced) {
+		t.Errorf("pillar referenced by the top file is not expected to be deleted: %v", err)
+	}
+	if _, err := os.Stat(basePath + PILLAR_ROOT + "/app/db/init.sls"); err != nil {
+		t.Errorf("pillar is expected to be kept: %s", err)
+	}
+
+	request.Operation = PILLAR_TOP_REMOVE
+	applyPillarTop(basePath+PILLAR_ROOT, request)
+	if err := deletePillar(basePath, "/app/db/init.sls"); err != nil {
+		t.Errorf("pillar is expected to be deleted once it is removed from the top file: %s", err)
+	}
+}
+
+func TestSaltPillarGetRequestHandler(t *testing.T) {
+	basePath, _ := os.MkdirTemp("", "pillarhistorytest")
+	defer os.RemoveAll(basePath)
+	writeTestPillar(t, basePath, "/app.sls", "v1")
+
+	for path, code := range map[string]int{"/app.sls": 200, "/missing.sls": 404, "/../app.sls": 400} {
+		body, _ := json.Marshal(PillarRequest{Path: path})
+		req := httptest.NewRequest("POST", SaltPillarGetEP, bytes.NewReader(body))
+		req.Header.Set("salt-master-base-dir", basePath)
+		w := httptest.NewRecorder()
+
+		SaltPillarGetRequestHandler(w, req)
+
+		if w.Code != code {
+			t.Errorf("status code of %s not match %d == %d", path, code, w.Code)
+		}
+	}
+}
+
+func TestSaltPillarRollbackRequestHandlerInvalidVersion(t *testing.T) {
+	body, _ := json.Marshal(PillarRequest{Path: "/app.sls", Version: "../../etc/shadow"})
+	req := httptest.NewRequest("POST", SaltPillarRollbackEP, bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	SaltPillarRollbackRequestHandler(w, req)
+
+	if w.Code != 400 {
+		t.Errorf("status code not match %d == %d", 400, w.Code)
+	}
+}
@@ -0,0 +1,732 @@
+package saltboot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	PILLAR_ENCRYPTION_NACL = "nacl"
+	PILLAR_ENCRYPTION_GPG  = "gpg"
+	pillarEncryptionKey    = "SALTBOOT_PILLAR_ENCRYPTION"
+
+	// default sk_file of salt's nacl renderer on the master
+	PILLAR_NACL_KEY        = "nacl"
+	PILLAR_NACL_PUBLIC_KEY = "nacl.pub"
+	PILLAR_GPG_KEY         = "saltboot_pillar_gpg.asc"
+	// the new key and the re-encrypted pillars of a rotation are staged next to the current files until every pillar
+	// is re-encrypted
+	pillarStagedSuffix = ".rotating"
+	// default gpg_keydir of salt's gpg renderer
+	SALT_GPG_KEYDIR = "/etc/salt/gpgkeys"
+
+	naclPrefix      = "NACL["
+	naclSuffix      = "]"
+	pgpMessageType  = "PGP MESSAGE"
+	pgpMessageBegin = "-----BEGIN " + pgpMessageType + "-----"
+	pillarGpgName   = "saltboot-pillar"
+	pillarGpgEmail  = "saltboot@localhost"
+)
+
+var pillarEncryptions = []string{PILLAR_ENCRYPTION_NACL, PILLAR_ENCRYPTION_GPG}
+
+// pillarLock serialises the writes of the pillar files with the key rotation
+var pillarLock sync.Mutex
+
+// pillarCipher encrypts the secret pillar values in the format of the matching salt renderer
+type pillarCipher interface {
+	Encrypt(plaintext string) (string, error)
+	Decrypt(ciphertext string) (string, error)
+	IsEncrypted(value string) bool
+}
+
+type naclCipher struct {
+	publicKey  *[32]byte
+	privateKey *[32]byte
+}
+
+func (c naclCipher) Encrypt(plaintext string) (string, error) {
+	sealed, err := box.SealAnonymous(nil, []byte(plaintext), c.publicKey, rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return naclPrefix + base64.StdEncoding.EncodeToString(sealed) + naclSuffix, nil
+}
+
+func (c naclCipher) Decrypt(ciphertext string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(ciphertext, naclPrefix), naclSuffix))
+	if err != nil {
+		return "", err
+	}
+	plaintext, ok := box.OpenAnonymous(nil, sealed, c.publicKey, c.privateKey)
+	if !ok {
+		return "", errors.New("unable to decrypt the secret with the nacl key")
+	}
+	return string(plaintext), nil
+}
+
+func (c naclCipher) IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, naclPrefix) && strings.HasSuffix(value, naclSuffix)
+}
+
+type gpgCipher struct {
+	entity *openpgp.Entity
+}
+
+func (c gpgCipher) Encrypt(plaintext string) (string, error) {
+	var buf bytes.Buffer
+	armored, err := armor.Encode(&buf, pgpMessageType, nil)
+	if err != nil {
+		return "", err
+	}
+	w, err := openpgp.Encrypt(armored, []*openpgp.Entity{c.entity}, nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write([]byte(plaintext)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := armored.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (c gpgCipher) Decrypt(ciphertext string) (string, error) {
+	block, err := armor.Decode(strings.NewReader(ciphertext))
+	if err != nil {
+		return "", err
+	}
+	md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{c.entity}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (c gpgCipher) IsEncrypted(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), pgpMessageBegin)
+}
+
+func (c gpgCipher) fingerprint() string {
+	return strings.ToUpper(hex.EncodeToString(c.entity.PrimaryKey.Fingerprint))
+}
+
+func determinePillarEncryption(encryption string) string {
+	if len(encryption) > 0 {
+		return encryption
+	}
+	if encryption = os.Getenv(pillarEncryptionKey); len(encryption) > 0 {
+		return encryption
+	}
+	return PILLAR_ENCRYPTION_NACL
+}
+
+func generateNaclCipher() (naclCipher, error) {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return naclCipher{}, err
+	}
+	return naclCipher{publicKey: publicKey, privateKey: privateKey}, nil
+}
+
+func generateGpgCipher() (gpgCipher, error) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoRSA, RSABits: 3072}
+	entity, err := openpgp.NewEntity(pillarGpgName, "", pillarGpgEmail, config)
+	if err != nil {
+		return gpgCipher{}, err
+	}
+	return gpgCipher{entity: entity}, nil
+}
+
+func generatePillarCipher(encryption string) (pillarCipher, error) {
+	switch encryption {
+	case PILLAR_ENCRYPTION_NACL:
+		return generateNaclCipher()
+	case PILLAR_ENCRYPTION_GPG:
+		return generateGpgCipher()
+	}
+	return nil, fmt.Errorf("unsupported encryption: %s", encryption)
+}
+
+func readNaclCipher(keyFile string) (naclCipher, error) {
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		return naclCipher{}, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) != 32 {
+		return naclCipher{}, fmt.Errorf("invalid nacl key: %s", keyFile)
+	}
+	var privateKey, publicKey [32]byte
+	copy(privateKey[:], key)
+	derived, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return naclCipher{}, err
+	}
+	copy(publicKey[:], derived)
+	return naclCipher{publicKey: &publicKey, privateKey: &privateKey}, nil
+}
+
+func readGpgCipher(keyFile string) (gpgCipher, error) {
+	f, err := os.Open(keyFile)
+	if err != nil {
+		return gpgCipher{}, err
+	}
+	defer f.Close()
+	entities, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return gpgCipher{}, err
+	}
+	if len(entities) != 1 || entities[0].PrivateKey == nil {
+		return gpgCipher{}, fmt.Errorf("invalid gpg key: %s", keyFile)
+	}
+	return gpgCipher{entity: entities[0]}, nil
+}
+
+func pillarKeyFile(basePath string, encryption string) string {
+	if encryption == PILLAR_ENCRYPTION_GPG {
+		return filepath.Join(basePath+MASTER_PKI_DIR, PILLAR_GPG_KEY)
+	}
+	return filepath.Join(basePath+MASTER_PKI_DIR, PILLAR_NACL_KEY)
+}
+
+func readPillarCipher(basePath string, encryption string) (pillarCipher, error) {
+	return readPillarCipherFile(pillarKeyFile(basePath, encryption), encryption)
+}
+
+func readPillarCipherFile(keyFile string, encryption string) (pillarCipher, error) {
+	switch encryption {
+	case PILLAR_ENCRYPTION_NACL:
+		return readNaclCipher(keyFile)
+	case PILLAR_ENCRYPTION_GPG:
+		return readGpgCipher(keyFile)
+	}
+	return nil, fmt.Errorf("unsupported encryption: %s", encryption)
+}
+
+// marshalPillarCipher returns the encryption of the cipher and the content of its key file
+func marshalPillarCipher(cipher pillarCipher) (string, []byte, error) {
+	switch c := cipher.(type) {
+	case naclCipher:
+		return PILLAR_ENCRYPTION_NACL, []byte(base64.StdEncoding.EncodeToString(c.privateKey[:])), nil
+	case gpgCipher:
+		var buf bytes.Buffer
+		armored, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+		if err != nil {
+			return "", nil, err
+		}
+		if err := c.entity.SerializePrivate(armored, nil); err != nil {
+			return "", nil, err
+		}
+		if err := armored.Close(); err != nil {
+			return "", nil, err
+		}
+		return PILLAR_ENCRYPTION_GPG, buf.Bytes(), nil
+	}
+	return "", nil, fmt.Errorf("unsupported cipher: %T", cipher)
+}
+
+// writePillarCipher saves the key, so salt can decrypt the pillars: the nacl key goes to the default sk_file,
+// the gpg key is imported into salt's gpg keyring
+func writePillarCipher(basePath string, cipher pillarCipher) error {
+	encryption, content, err := marshalPillarCipher(cipher)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(basePath+MASTER_PKI_DIR, 0700); err != nil {
+		return err
+	}
+	keyFile := pillarKeyFile(basePath, encryption)
+	log.Printf("[writePillarCipher] write %s key: %s", encryption, keyFile)
+	if err := WriteFile(keyFile, content, 0600); err != nil {
+		return err
+	}
+	return activatePillarCipher(basePath, cipher, keyFile)
+}
+
+// activatePillarCipher writes the nacl public key next to the key file or imports the gpg key into salt's keyring
+func activatePillarCipher(basePath string, cipher pillarCipher, keyFile string) error {
+	switch c := cipher.(type) {
+	case naclCipher:
+		return WriteFile(filepath.Join(basePath+MASTER_PKI_DIR, PILLAR_NACL_PUBLIC_KEY), []byte(base64.StdEncoding.EncodeToString(c.publicKey[:])), 0644)
+	case gpgCipher:
+		keyDir := basePath + SALT_GPG_KEYDIR
+		if err := os.MkdirAll(keyDir, 0700); err != nil {
+			return err
+		}
+		_, err := ExecCmd("gpg", "--homedir", keyDir, "--batch", "--import", keyFile)
+		return err
+	}
+	return fmt.Errorf("unsupported cipher: %T", cipher)
+}
+
+// loadPillarCipher reads the key of the encryption, a new key is generated on first use
+func loadPillarCipher(basePath string, encryption string) (pillarCipher, error) {
+	cipher, err := readPillarCipher(basePath, encryption)
+	if !os.IsNotExist(err) {
+		return cipher, err
+	}
+	log.Printf("[loadPillarCipher] generate new %s key for pillar secrets", encryption)
+	if cipher, err = generatePillarCipher(encryption); err != nil {
+		return nil, err
+	}
+	return cipher, writePillarCipher(basePath, cipher)
+}
+
+func (pillar SaltPillar) encryption() string {
+	return determinePillarEncryption(pillar.Encryption)
+}
+
+func validatePillarSecrets(pillar SaltPillar) error {
+	if len(pillar.Secrets) == 0 {
+		return nil
+	}
+	if !containsString(pillarEncryptions, pillar.encryption()) {
+		return fmt.Errorf("unsupported encryption: %s", pillar.encryption())
+	}
+	for _, secret := range pillar.Secrets {
+		if _, err := pillarSecretValue(pillar.Json, secret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pillarSecretValue returns the string value of the dot separated key path
+func pillarSecretValue(values map[string]interface{}, secret string) (string, error) {
+	keys := strings.Split(secret, ".")
+	current := values
+	for i, key := range keys {
+		value, ok := current[key]
+		if !ok {
+			return "", fmt.Errorf("secret %s does not exist in the pillar", secret)
+		}
+		if i == len(keys)-1 {
+			s, ok := value.(string)
+			if !ok {
+				return "", fmt.Errorf("secret %s is not a string", secret)
+			}
+			return s, nil
+		}
+		if current, ok = value.(map[string]interface{}); !ok {
+			return "", fmt.Errorf("secret %s does not exist in the pillar", secret)
+		}
+	}
+	return "", fmt.Errorf("secret %s does not exist in the pillar", secret)
+}
+
+func setPillarSecretValue(values map[string]interface{}, secret string, value string) {
+	keys := strings.Split(secret, ".")
+	current := values
+	for _, key := range keys[:len(keys)-1] {
+		current = current[key].(map[string]interface{})
+	}
+	current[keys[len(keys)-1]] = value
+}
+
+// encryptPillarSecrets returns a copy of the pillar with the secret values encrypted
+func encryptPillarSecrets(pillar SaltPillar, basePath string) (SaltPillar, error) {
+	if len(pillar.Secrets) == 0 {
+		return pillar, nil
+	}
+	if err := validatePillarSecrets(pillar); err != nil {
+		return pillar, err
+	}
+	cipher, err := loadPillarCipher(basePath, pillar.encryption())
+	if err != nil {
+		return pillar, err
+	}
+	b, err := json.Marshal(pillar.Json)
+	if err != nil {
+		return pillar, err
+	}
+	encrypted := pillar
+	encrypted.Json = nil
+	if err := json.Unmarshal(b, &encrypted.Json); err != nil {
+		return pillar, err
+	}
+	for _, secret := range pillar.Secrets {
+		value, _ := pillarSecretValue(encrypted.Json, secret)
+		ciphertext, err := cipher.Encrypt(value)
+		if err != nil {
+			return pillar, err
+		}
+		setPillarSecretValue(encrypted.Json, secret, ciphertext)
+	}
+	return encrypted, nil
+}
+
+// transformPillarStrings applies the function on every string value of the decoded pillar
+func transformPillarStrings(value interface{}, transform func(string) (string, error)) (interface{}, error) {
+	var err error
+	switch v := value.(type) {
+	case string:
+		return transform(v)
+	case map[string]interface{}:
+		for key, item := range v {
+			if v[key], err = transformPillarStrings(item, transform); err != nil {
+				return nil, err
+			}
+		}
+	case map[interface{}]interface{}:
+		for key, item := range v {
+			if v[key], err = transformPillarStrings(item, transform); err != nil {
+				return nil, err
+			}
+		}
+	case yaml.MapSlice:
+		for i := range v {
+			if v[i].Value, err = transformPillarStrings(v[i].Value, transform); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			if v[i], err = transformPillarStrings(v[i], transform); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
+
+// reencryptPillarContent re-encrypts the secrets of a rendered pillar, the content is returned unchanged
+// if the pillar is not encrypted with the given encryption
+func reencryptPillarContent(content []byte, encryption string, oldCipher pillarCipher, newCipher pillarCipher) ([]byte, bool, error) {
+	newline := bytes.IndexByte(content, '\n')
+	if newline < 0 {
+		return content, false, nil
+	}
+	shebang := string(content[:newline])
+	renderers := strings.Split(strings.TrimPrefix(shebang, "#!"), "|")
+	if !strings.HasPrefix(shebang, "#!") || len(renderers) != 2 || renderers[1] != encryption {
+		return content, false, nil
+	}
+
+	transform := func(value string) (string, error) {
+		if !oldCipher.IsEncrypted(value) {
+			return value, nil
+		}
+		plaintext, err := oldCipher.Decrypt(value)
+		if err != nil {
+			return "", err
+		}
+		return newCipher.Encrypt(plaintext)
+	}
+	var rendered []byte
+	switch renderers[0] {
+	case PILLAR_RENDERER_JSON:
+		var values interface{}
+		decoder := json.NewDecoder(bytes.NewReader(content[newline+1:]))
+		// keep the numbers as they were written
+		decoder.UseNumber()
+		if err := decoder.Decode(&values); err != nil {
+			return nil, false, err
+		}
+		values, err := transformPillarStrings(values, transform)
+		if err != nil {
+			return nil, false, err
+		}
+		if rendered, err = json.MarshalIndent(values, "", "\t"); err != nil {
+			return nil, false, err
+		}
+	case PILLAR_RENDERER_YAML:
+		var values yaml.MapSlice
+		if err := yaml.Unmarshal(content[newline+1:], &values); err != nil {
+			return nil, false, err
+		}
+		transformed, err := transformPillarStrings(values, transform)
+		if err != nil {
+			return nil, false, err
+		}
+		if rendered, err = yaml.Marshal(transformed); err != nil {
+			return nil, false, err
+		}
+	default:
+		return content, false, nil
+	}
+	return append([]byte(shebang+"\n"), rendered...), true, nil
+}
+
+type PillarKeyRotateRequest struct {
+	Encryption string `json:"encryption,omitempty"`
+}
+
+type PillarKeyRotateResult struct {
+	Encryption string   `json:"encryption"`
+	Pillars    []string `json:"pillars"`
+	Versions   int      `json:"versions"`
+}
+
+type reencryptedPillarFile struct {
+	file    string
+	content []byte
+}
+
+// reencryptPillarFiles re-encrypts the pillars and their previous versions in memory
+func reencryptPillarFiles(basePath string, encryption string, oldCipher pillarCipher, newCipher pillarCipher) ([]reencryptedPillarFile, error) {
+	var files []reencryptedPillarFile
+	for _, root := range []string{basePath + PILLAR_ROOT, basePath + PILLAR_HISTORY_DIR} {
+		err := filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && file == root {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if entry.IsDir() || strings.HasSuffix(file, pillarStagedSuffix) {
+				return nil
+			}
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			reencrypted, changed, err := reencryptPillarContent(content, encryption, oldCipher, newCipher)
+			if err != nil {
+				return fmt.Errorf("unable to re-encrypt %s: %s", file, err.Error())
+			}
+			if changed {
+				files = append(files, reencryptedPillarFile{file: file, content: reencrypted})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// stagePillarCipher writes the new key next to the current one, the key of an interrupted rotation is reused, so no
+// unused key is left in the gpg keyring
+func stagePillarCipher(basePath string, encryption string) (pillarCipher, string, error) {
+	stagedKeyFile := pillarKeyFile(basePath, encryption) + pillarStagedSuffix
+	cipher, err := readPillarCipherFile(stagedKeyFile, encryption)
+	if err == nil {
+		log.Printf("[stagePillarCipher] resume the rotation with the staged key: %s", stagedKeyFile)
+		return cipher, stagedKeyFile, nil
+	} else if !os.IsNotExist(err) {
+		return nil, stagedKeyFile, err
+	}
+	if cipher, err = generatePillarCipher(encryption); err != nil {
+		return nil, stagedKeyFile, err
+	}
+	_, content, err := marshalPillarCipher(cipher)
+	if err != nil {
+		return nil, stagedKeyFile, err
+	}
+	if err := os.MkdirAll(basePath+MASTER_PKI_DIR, 0700); err != nil {
+		return nil, stagedKeyFile, err
+	}
+	log.Printf("[stagePillarCipher] stage new %s key: %s", encryption, stagedKeyFile)
+	return cipher, stagedKeyFile, WriteFile(stagedKeyFile, content, 0600)
+}
+
+// stagedPillarFiles returns the re-encrypted pillars and versions that a rotation staged but not committed
+func stagedPillarFiles(basePath string) ([]string, error) {
+	var files []string
+	for _, root := range []string{basePath + PILLAR_ROOT, basePath + PILLAR_HISTORY_DIR} {
+		err := filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && file == root {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if !entry.IsDir() && strings.HasSuffix(file, pillarStagedSuffix) {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// commitStagedPillarFile moves the staged file over the pillar or the version it re-encrypted, the hash in the name of
+// a version is kept in sync with its content
+func commitStagedPillarFile(staged string) (string, error) {
+	file := strings.TrimSuffix(staged, pillarStagedSuffix)
+	target := file
+	if match := pillarVersionPattern.FindStringSubmatch(filepath.Base(file)); match != nil {
+		content, err := os.ReadFile(staged)
+		if err != nil {
+			return "", err
+		}
+		target = filepath.Join(filepath.Dir(file), match[1]+"-"+pillarHash(content))
+	}
+	if info, err := os.Stat(file); err == nil {
+		if err := preserveFileAttributes(file, info, staged); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(staged, target); err != nil {
+		return "", err
+	}
+	if target != file {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return target, nil
+}
+
+// recoverPillarRotation cleans up after an interrupted rotation. If its key is still staged, the pillars on the disk
+// are readable with the current key and the staged pillars are dropped. Otherwise the key was already swapped, so the
+// staged pillars are committed, as only they are readable with the current key.
+func recoverPillarRotation(basePath string, stagedKeyFile string) error {
+	staged, err := stagedPillarFiles(basePath)
+	if err != nil || len(staged) == 0 {
+		return err
+	}
+	_, err = os.Stat(stagedKeyFile)
+	keySwapped := os.IsNotExist(err)
+	if err != nil && !keySwapped {
+		return err
+	}
+	for _, file := range staged {
+		if keySwapped {
+			log.Printf("[recoverPillarRotation] commit the staged pillar of an interrupted rotation: %s", file)
+			_, err = commitStagedPillarFile(file)
+		} else {
+			log.Printf("[recoverPillarRotation] drop the staged pillar of an interrupted rotation: %s", file)
+			err = os.Remove(file)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotatePillarKey re-encrypts the pillars and their versions with a new key. The re-encrypted files are staged next
+// to the current ones and committed right after the new key replaces the old one, so a failed rotation leaves the
+// pillars readable with the current key and can be retried.
+func rotatePillarKey(basePath string, encryption string) (PillarKeyRotateResult, error) {
+	pillarLock.Lock()
+	defer pillarLock.Unlock()
+
+	result := PillarKeyRotateResult{Encryption: encryption, Pillars: []string{}}
+	keyFile := pillarKeyFile(basePath, encryption)
+	if err := recoverPillarRotation(basePath, keyFile+pillarStagedSuffix); err != nil {
+		return result, err
+	}
+	oldCipher, err := readPillarCipher(basePath, encryption)
+	if os.IsNotExist(err) {
+		oldCipher = nil
+	} else if err != nil {
+		return result, err
+	}
+	newCipher, stagedKeyFile, err := stagePillarCipher(basePath, encryption)
+	if err != nil {
+		return result, err
+	}
+	if _, ok := newCipher.(gpgCipher); ok {
+		// salt decrypts with any key of its keyring, so both the old and the new pillars are readable
+		if err := activatePillarCipher(basePath, newCipher, stagedKeyFile); err != nil {
+			return result, err
+		}
+	}
+
+	var files []reencryptedPillarFile
+	if oldCipher != nil {
+		if files, err = reencryptPillarFiles(basePath, encryption, oldCipher, newCipher); err != nil {
+			return result, err
+		}
+	}
+	for _, f := range files {
+		permissions := os.FileMode(0600)
+		if strings.HasPrefix(f.file, basePath+PILLAR_ROOT+"/") {
+			permissions = 0644
+		}
+		if err := WriteFile(f.file+pillarStagedSuffix, f.content, permissions); err != nil {
+			return result, err
+		}
+	}
+
+	log.Printf("[rotatePillarKey] replace %s with the staged key", keyFile)
+	if err := os.Rename(stagedKeyFile, keyFile); err != nil {
+		return result, err
+	}
+	for _, f := range files {
+		if _, err := commitStagedPillarFile(f.file + pillarStagedSuffix); err != nil {
+			return result, err
+		}
+		if strings.HasPrefix(f.file, basePath+PILLAR_ROOT+"/") {
+			result.Pillars = append(result.Pillars, strings.TrimPrefix(f.file, basePath+PILLAR_ROOT))
+		} else {
+			result.Versions++
+		}
+	}
+	if _, ok := newCipher.(naclCipher); ok {
+		if err := activatePillarCipher(basePath, newCipher, keyFile); err != nil {
+			return result, err
+		}
+	}
+	if old, ok := oldCipher.(gpgCipher); ok {
+		_, err := ExecCmd("gpg", "--homedir", basePath+SALT_GPG_KEYDIR, "--batch", "--yes", "--delete-secret-and-public-key", old.fingerprint())
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func SaltPillarKeyRotateRequestHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltPillarKeyRotateRequestHandler] execute salt pillar key rotation request")
+
+	decoder := json.NewDecoder(req.Body)
+	var rotateRequest PillarKeyRotateRequest
+	if err := decoder.Decode(&rotateRequest); err != nil {
+		log.Printf("[SaltPillarKeyRotateRequestHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	encryption := determinePillarEncryption(rotateRequest.Encryption)
+	if !containsString(pillarEncryptions, encryption) {
+		log.Printf("[SaltPillarKeyRotateRequestHandler] [ERROR] unsupported encryption: %s", encryption)
+		model.Response{Status: "unsupported encryption: " + encryption}.WriteBadRequestHttp(w)
+		return
+	}
+
+	result, err := rotatePillarKey(req.Header.Get("salt-master-base-dir"), encryption)
+	if err != nil {
+		log.Printf("[SaltPillarKeyRotateRequestHandler] [ERROR] unable to rotate the pillar key: %s", err.Error())
+		model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError, Payload: result}.WriteHttp(w)
+		return
+	}
+	status := fmt.Sprintf("%s key is rotated, %d pillars and %d versions are re-encrypted", encryption, len(result.Pillars), result.Versions)
+	log.Printf("[SaltPillarKeyRotateRequestHandler] %s", status)
+	model.Response{Status: status, Payload: result}.WriteHttp(w)
+}
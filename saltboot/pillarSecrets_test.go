@@ -0,0 +1,222 @@
+package saltboot
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func writeTestSecretPillar(t *testing.T, basePath string, renderer string, password string) SaltPillar {
+	pillar := SaltPillar{
+		Path:       "/postgres/init.sls",
+		Json:       map[string]interface{}{"postgres": map[string]interface{}{"user": "admin", "password": password, "port": float64(5432)}},
+		Renderer:   renderer,
+		Secrets:    []string{"postgres.password"},
+		Encryption: PILLAR_ENCRYPTION_NACL,
+	}
+	if _, err := writePillarImpl(pillar, basePath); err != nil {
+		t.Fatalf("unable to write pillar: %s", err)
+	}
+	return pillar
+}
+
+func TestWritePillarEncryptsSecrets(t *testing.T) {
+	for _, renderer := range []string{PILLAR_RENDERER_JSON, PILLAR_RENDERER_YAML} {
+		basePath, _ := os.MkdirTemp("", "pillarsecretstest")
+		defer os.RemoveAll(basePath)
+
+		pillar := writeTestSecretPillar(t, basePath, renderer, "s3cr3t-password")
+
+		content, _ := os.ReadFile(basePath + PILLAR_ROOT + pillar.Path)
+		if strings.Contains(string(content), "s3cr3t-password") {
+			t.Errorf("%s pillar contains the plaintext secret: %s", renderer, content)
+		}
+		if !strings.HasPrefix(string(content), "#!"+renderer+"|nacl\n") || !strings.Contains(string(content), "admin") {
+			t.Errorf("%s pillar not match: %s", renderer, content)
+		}
+		if pillar.Json["postgres"].(map[string]interface{})["password"] != "s3cr3t-password" {
+			t.Errorf("request pillar is not expected to be modified: %v", pillar.Json)
+		}
+		if info, err := os.Stat(basePath + MASTER_PKI_DIR + "/" + PILLAR_NACL_KEY); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("nacl key is expected to be written with 0600: %v", err)
+		}
+	}
+}
+
+func TestNaclCipherRoundTrip(t *testing.T) {
+	basePath, _ := os.MkdirTemp("", "pillarsecretstest")
+	defer os.RemoveAll(basePath)
+	cipher, err := loadPillarCipher(basePath, PILLAR_ENCRYPTION_NACL)
+	if err != nil {
+		t.Fatalf("unable to create nacl key: %s", err)
+	}
+
+	ciphertext, _ := cipher.Encrypt("secret")
+	if !cipher.IsEncrypted(ciphertext) || cipher.IsEncrypted("secret") {
+		t.Errorf("ciphertext not match: %s", ciphertext)
+	}
+	reloaded, _ := loadPillarCipher(basePath, PILLAR_ENCRYPTION_NACL)
+	if plaintext, err := reloaded.Decrypt(ciphertext); err != nil || plaintext != "secret" {
+		t.Errorf("plaintext not match: %s, %v", plaintext, err)
+	}
+}
+
+func TestGpgCipherRoundTrip(t *testing.T) {
+	cipher, err := generateGpgCipher()
+	if err != nil {
+		t.Fatalf("unable to create gpg key: %s", err)
+	}
+
+	ciphertext, _ := cipher.Encrypt("secret")
+	if !cipher.IsEncrypted(ciphertext) || strings.Contains(ciphertext, "secret") {
+		t.Errorf("ciphertext not match: %s", ciphertext)
+	}
+	if plaintext, err := cipher.Decrypt(ciphertext); err != nil || plaintext != "secret" {
+		t.Errorf("plaintext not match: %s, %v", plaintext, err)
+	}
+}
+
+func TestRotatePillarKey(t *testing.T) {
+	basePath, _ := os.MkdirTemp("", "pillarsecretstest")
+	defer os.RemoveAll(basePath)
+	writeTestSecretPillar(t, basePath, PILLAR_RENDERER_YAML, "v1")
+	pillar := writeTestSecretPillar(t, basePath, PILLAR_RENDERER_YAML, "v2")
+	writeTestPillar(t, basePath, "/plain.sls", "v1")
+	oldCipher, _ := readPillarCipher(basePath, PILLAR_ENCRYPTION_NACL)
+
+	result, err := rotatePillarKey(basePath, PILLAR_ENCRYPTION_NACL)
+	if err != nil {
+		t.Fatalf("unable to rotate the key: %s", err)
+	}
+
+	if len(result.Pillars) != 1 || result.Pillars[0] != pillar.Path || result.Versions != 1 {
+		t.Errorf("rotation result not match: %v", result)
+	}
+	newCipher, _ := readPillarCipher(basePath, PILLAR_ENCRYPTION_NACL)
+	content, _ := os.ReadFile(basePath + PILLAR_ROOT + pillar.Path)
+	decrypted, _, err := reencryptPillarContent(content, PILLAR_ENCRYPTION_NACL, newCipher, naclPlaintextCipher{})
+	if err != nil || !strings.Contains(string(decrypted), "password: v2") || !strings.Contains(string(decrypted), "port: 5432") {
+		t.Errorf("pillar is expected to be re-encrypted with the new key: %s, %v", decrypted, err)
+	}
+	if _, _, err := reencryptPillarContent(content, PILLAR_ENCRYPTION_NACL, oldCipher, naclPlaintextCipher{}); err == nil {
+		t.Error("pillar is not expected to be decryptable with the old key")
+	}
+
+	versions, _ := pillarVersions(basePath, pillar.Path)
+	if err := rollbackPillar(basePath, pillar.Path, versions[0].Version); err != nil {
+		t.Fatalf("re-encrypted version is expected to be restorable: %s", err)
+	}
+	content, _ = os.ReadFile(basePath + PILLAR_ROOT + pillar.Path)
+	if decrypted, _, err := reencryptPillarContent(content, PILLAR_ENCRYPTION_NACL, newCipher, naclPlaintextCipher{}); err != nil || !strings.Contains(string(decrypted), "password: v1") {
+		t.Errorf("version is expected to be re-encrypted with the new key: %s, %v", decrypted, err)
+	}
+}
+
+func TestRotatePillarKeyDropsPillarsStagedBeforeKeySwap(t *testing.T) {
+	basePath, _ := os.MkdirTemp("", "pillarsecretstest")
+	defer os.RemoveAll(basePath)
+	pillar := writeTestSecretPillar(t, basePath, PILLAR_RENDERER_JSON, "v1")
+	oldCipher, _ := readPillarCipher(basePath, PILLAR_ENCRYPTION_NACL)
+	file := basePath + PILLAR_ROOT + pillar.Path
+	original, _ := os.ReadFile(file)
+
+	// an interrupted rotation staged its key and a pillar, but did not swap the key
+	stagedCipher, stagedKeyFile, err := stagePillarCipher(basePath, PILLAR_ENCRYPTION_NACL)
+	if err != nil {
+		t.Fatalf("unable to stage the key: %s", err)
+	}
+	os.WriteFile(file+pillarStagedSuffix, []byte("partial"), 0644)
+	if current, _ := os.ReadFile(file); !bytes.Equal(current, original) {
+		t.Fatal("pillar is not expected to change before the key is swapped")
+	}
+	if _, _, err := reencryptPillarContent(original, PILLAR_ENCRYPTION_NACL, oldCipher, naclPlaintextCipher{}); err != nil {
+		t.Fatalf("pillar is expected to stay readable with the current key: %s", err)
+	}
+
+	result, err := rotatePillarKey(basePath, PILLAR_ENCRYPTION_NACL)
+	if err != nil || len(result.Pillars) != 1 {
+		t.Fatalf("interrupted rotation is expected to be retried: %v, %v", result, err)
+	}
+
+	newCipher, _ := readPillarCipher(basePath, PILLAR_ENCRYPTION_NACL)
+	if *newCipher.(naclCipher).privateKey != *stagedCipher.(naclCipher).privateKey {
+		t.Error("staged key is expected to replace the key")
+	}
+	if _, err := os.Stat(stagedKeyFile); !os.IsNotExist(err) {
+		t.Error("staged key is expected to be removed")
+	}
+	if staged, _ := stagedPillarFiles(basePath); len(staged) != 0 {
+		t.Errorf("staged pillars are expected to be removed: %v", staged)
+	}
+	content, _ := os.ReadFile(file)
+	if decrypted, _, err := reencryptPillarContent(content, PILLAR_ENCRYPTION_NACL, newCipher, naclPlaintextCipher{}); err != nil || !strings.Contains(string(decrypted), `"v1"`) {
+		t.Errorf("pillar is expected to be readable with the new key: %s, %v", decrypted, err)
+	}
+}
+
+func TestRotatePillarKeyCommitsPillarsStagedAfterKeySwap(t *testing.T) {
+	basePath, _ := os.MkdirTemp("", "pillarsecretstest")
+	defer os.RemoveAll(basePath)
+	writeTestSecretPillar(t, basePath, PILLAR_RENDERER_JSON, "v1")
+	pillar := writeTestSecretPillar(t, basePath, PILLAR_RENDERER_JSON, "v2")
+	oldCipher, _ := readPillarCipher(basePath, PILLAR_ENCRYPTION_NACL)
+
+	// an interrupted rotation staged every file and swapped the key, but did not commit the files
+	stagedCipher, stagedKeyFile, _ := stagePillarCipher(basePath, PILLAR_ENCRYPTION_NACL)
+	files, err := reencryptPillarFiles(basePath, PILLAR_ENCRYPTION_NACL, oldCipher, stagedCipher)
+	if err != nil || len(files) != 2 {
+		t.Fatalf("unable to re-encrypt the pillars: %v, %v", files, err)
+	}
+	for _, f := range files {
+		os.WriteFile(f.file+pillarStagedSuffix, f.content, 0600)
+	}
+	os.Rename(stagedKeyFile, pillarKeyFile(basePath, PILLAR_ENCRYPTION_NACL))
+
+	if _, err := rotatePillarKey(basePath, PILLAR_ENCRYPTION_NACL); err != nil {
+		t.Fatalf("interrupted rotation is expected to be recovered: %s", err)
+	}
+
+	newCipher, _ := readPillarCipher(basePath, PILLAR_ENCRYPTION_NACL)
+	content, _ := os.ReadFile(basePath + PILLAR_ROOT + pillar.Path)
+	if decrypted, _, err := reencryptPillarContent(content, PILLAR_ENCRYPTION_NACL, newCipher, naclPlaintextCipher{}); err != nil || !strings.Contains(string(decrypted), `"v2"`) {
+		t.Errorf("pillar is expected to be readable with the new key: %s, %v", decrypted, err)
+	}
+	versions, _ := pillarVersions(basePath, pillar.Path)
+	if len(versions) != 1 {
+		t.Fatalf("version is expected to be kept: %v", versions)
+	}
+	if err := rollbackPillar(basePath, pillar.Path, versions[0].Version); err != nil {
+		t.Errorf("committed version is expected to be restorable: %s", err)
+	}
+	if staged, _ := stagedPillarFiles(basePath); len(staged) != 0 {
+		t.Errorf("staged pillars are expected to be committed: %v", staged)
+	}
+}
+
+// naclPlaintextCipher "encrypts" to plaintext, so the re-encryption can be used to decrypt a whole pillar
+type naclPlaintextCipher struct{}
+
+func (naclPlaintextCipher) Encrypt(plaintext string) (string, error)  { return plaintext, nil }
+func (naclPlaintextCipher) Decrypt(ciphertext string) (string, error) { return ciphertext, nil }
+func (naclPlaintextCipher) IsEncrypted(value string) bool             { return false }
+
+func TestSaltPillarRequestHandlerInvalidSecrets(t *testing.T) {
+	for _, pillar := range []SaltPillar{
+		{Path: "/app.sls", Json: map[string]interface{}{"password": "secret"}, Secrets: []string{"missing"}},
+		{Path: "/app.sls", Json: map[string]interface{}{"port": 5432}, Secrets: []string{"port"}},
+		{Path: "/app.sls", Json: map[string]interface{}{"password": "secret"}, Secrets: []string{"password"}, Encryption: "rot13"},
+	} {
+		body, _ := json.Marshal(pillar)
+		req := httptest.NewRequest("POST", SaltPillarEP, bytes.NewReader(body))
+		w := httptest.NewRecorder()
+
+		SaltPillarRequestHandler(w, req)
+
+		if w.Code != 400 {
+			t.Errorf("status code of %v not match %d == %d", pillar, 400, w.Code)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package saltboot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+// targetPillarOf decodes the signed pillar of the target and checks that it is bound to the target, the signature is
+// checked by the target itself
+func targetPillarOf(target string, signedPillar SignedPayload) (SaltPillar, error) {
+	if len(signedPillar.Signature) == 0 {
+		return SaltPillar{}, fmt.Errorf("pillar of target %s is not signed", target)
+	}
+	var pillar SaltPillar
+	if err := json.Unmarshal([]byte(signedPillar.Payload), &pillar); err != nil {
+		return SaltPillar{}, fmt.Errorf("invalid pillar for target %s: %s", target, err.Error())
+	}
+	if pillar.Target != target {
+		return SaltPillar{}, fmt.Errorf("pillar of target %s is bound to %s", target, pillar.Target)
+	}
+	if len(pillar.TargetPillars) > 0 {
+		return SaltPillar{}, fmt.Errorf("pillar of target %s contains target pillars", target)
+	}
+	if err := validatePillarPath(pillar.Path); err != nil {
+		return SaltPillar{}, fmt.Errorf("invalid path for target %s: %s", target, err.Error())
+	}
+	if !containsString(pillarRenderers, pillar.Renderer) {
+		return SaltPillar{}, fmt.Errorf("unsupported renderer for target %s: %s", target, pillar.Renderer)
+	}
+	if err := validatePillarSecrets(pillar); err != nil {
+		return SaltPillar{}, fmt.Errorf("invalid pillar for target %s: %s", target, err.Error())
+	}
+	return pillar, nil
+}
+
+// distributeTargetPillarsImpl sends each target only its own signed pillar
+func distributeTargetPillarsImpl(distributeRequest func(map[string]RequestBody, string, string, string, bool) <-chan model.Response,
+	targetPillars map[string]SignedPayload, user string, pass string) []model.Response {
+
+	requests := make(map[string]RequestBody)
+	pending := make(map[string]bool)
+	for target, signedPillar := range targetPillars {
+		requests[target] = signedPillar.requestBody()
+		pending[target] = true
+	}
+	var result []model.Response
+	for resp := range distributeRequest(requests, SaltPillarEP, user, pass, true) {
+		if !pending[resp.Address] {
+			log.Printf("[distributeTargetPillarsImpl] [ERROR] response from unknown target: %s", resp.String())
+			continue
+		}
+		delete(pending, resp.Address)
+		result = append(result, resp)
+	}
+	for target := range pending {
+		result = append(result, model.Response{StatusCode: http.StatusInternalServerError, ErrorText: "no response from the target", Address: target})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Address < result[j].Address
+	})
+	return result
+}
+
+func validateTargetPillars(saltPillar SaltPillar) error {
+	if len(saltPillar.Targets) > 0 || len(saltPillar.Json) > 0 {
+		return errors.New("target pillars cannot be combined with targets or a pillar")
+	}
+	for target, signedPillar := range saltPillar.TargetPillars {
+		if _, err := targetPillarOf(target, signedPillar); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func distributeTargetPillars(w http.ResponseWriter, req *http.Request, saltPillar SaltPillar) {
+	if err := validateTargetPillars(saltPillar); err != nil {
+		log.Printf("[SaltPillarDistributeRequestHandler] [ERROR] invalid target pillars: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+
+	user, pass := GetAuthUserPass(req)
+	log.Printf("[SaltPillarDistributeRequestHandler] send target pillars to %d nodes", len(saltPillar.TargetPillars))
+	result := distributeTargetPillarsImpl(DistributeTargetedRequest, saltPillar.TargetPillars, user, pass)
+
+	cResp := model.Responses{Responses: result}
+	log.Printf("[SaltPillarDistributeRequestHandler] distribute target pillars request executed: %s", cResp.String())
+	if err := json.NewEncoder(w).Encode(cResp); err != nil {
+		log.Printf("[SaltPillarDistributeRequestHandler] [ERROR] couldn't encode json: %s", err.Error())
+	}
+}
@@ -0,0 +1,129 @@
+package saltboot
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+func signedTargetPillar(pillar SaltPillar) SignedPayload {
+	payload, _ := json.Marshal(pillar)
+	return SignedPayload{Payload: string(payload), Signature: "signature-of-" + pillar.Target}
+}
+
+func TestTargetPillarOf(t *testing.T) {
+	pillar := SaltPillar{Path: "/nodes/init.sls", Json: map[string]interface{}{"role": "master"}, Target: "10.0.0.1"}
+
+	targetPillar, err := targetPillarOf("10.0.0.1", signedTargetPillar(pillar))
+	if err != nil {
+		t.Fatalf("unable to decode pillar of target: %s", err)
+	}
+	if targetPillar.Path != pillar.Path || targetPillar.Json["role"] != "master" {
+		t.Errorf("pillar of the target not match: %v", targetPillar)
+	}
+	if _, err := targetPillarOf("10.0.0.2", signedTargetPillar(pillar)); err == nil {
+		t.Error("error is expected for a pillar bound to another target")
+	}
+	if _, err := targetPillarOf("10.0.0.1", SignedPayload{Payload: signedTargetPillar(pillar).Payload}); err == nil {
+		t.Error("error is expected for a pillar without signature")
+	}
+	pillar.Secrets = []string{"password"}
+	if _, err := targetPillarOf("10.0.0.1", signedTargetPillar(pillar)); err == nil {
+		t.Error("error is expected for an invalid pillar")
+	}
+}
+
+func TestDistributeTargetPillars(t *testing.T) {
+	targetPillars := make(map[string]SignedPayload)
+	for _, target := range []string{"10.0.0.1:7070", "10.0.0.2", "10.0.0.3"} {
+		targetPillars[target] = signedTargetPillar(SaltPillar{Path: "/nodes/init.sls", Json: map[string]interface{}{"node": target}, Target: target})
+	}
+	var endpoint string
+	var forwarded map[string]RequestBody
+	distribute := func(requests map[string]RequestBody, ep string, user string, pass string, allowFallback bool) <-chan model.Response {
+		endpoint = ep
+		forwarded = requests
+		c := make(chan model.Response, 2)
+		c <- model.Response{StatusCode: http.StatusOK, Address: "10.0.0.1:7070"}
+		c <- model.Response{StatusCode: http.StatusInternalServerError, ErrorText: "failed", Address: "10.0.0.2"}
+		close(c)
+		return c
+	}
+
+	result := distributeTargetPillarsImpl(distribute, targetPillars, "user", "pass")
+
+	if endpoint != SaltPillarEP || len(forwarded) != len(targetPillars) {
+		t.Fatalf("signed pillars are expected to be forwarded to %s: %s %v", SaltPillarEP, endpoint, forwarded)
+	}
+	for target, requestBody := range forwarded {
+		if requestBody.SignedPayload != targetPillars[target].Payload || requestBody.Signature != targetPillars[target].Signature {
+			t.Errorf("target %s is expected to receive only its own signed pillar: %v", target, requestBody)
+		}
+	}
+	expected := []model.Response{
+		{StatusCode: http.StatusOK, Address: "10.0.0.1:7070"},
+		{StatusCode: http.StatusInternalServerError, ErrorText: "failed", Address: "10.0.0.2"},
+		{StatusCode: http.StatusInternalServerError, ErrorText: "no response from the target", Address: "10.0.0.3"},
+	}
+	if len(result) != len(expected) {
+		t.Fatalf("result not match: %v", result)
+	}
+	for i := range expected {
+		if result[i].String() != expected[i].String() {
+			t.Errorf("result not match %s == %s", expected[i].String(), result[i].String())
+		}
+	}
+}
+
+func TestSaltPillarRequestHandlerTargetPillar(t *testing.T) {
+	baseDir, _ := os.MkdirTemp("", "pillartargettest")
+	defer os.RemoveAll(baseDir)
+	fakeLocalNode(t, "node2", "10.0.0.2")
+
+	for target, expected := range map[string]int{"10.0.0.2": 200, "10.0.0.3": 400} {
+		pillar := SaltPillar{Path: "/nodes/" + target + ".sls", Json: map[string]interface{}{"role": "master"}, Target: target}
+		body, _ := json.Marshal(pillar)
+		req := httptest.NewRequest("POST", SaltPillarEP, bytes.NewReader(body))
+		req.Header.Set("salt-master-base-dir", baseDir)
+		w := httptest.NewRecorder()
+
+		SaltPillarRequestHandler(w, req)
+
+		if w.Code != expected {
+			t.Errorf("status code of target %s not match %d == %d", target, expected, w.Code)
+		}
+	}
+	if _, err := os.Stat(baseDir + "/srv/pillar/nodes/10.0.0.3.sls"); !os.IsNotExist(err) {
+		t.Error("pillar bound to another node is not expected to be written")
+	}
+	content, _ := os.ReadFile(baseDir + "/srv/pillar/nodes/10.0.0.2.sls")
+	if !bytes.Contains(content, []byte("master")) {
+		t.Errorf("pillar of the target is expected to be written: %s", content)
+	}
+}
+
+func TestSaltPillarDistributeRequestHandlerInvalidTargetPillar(t *testing.T) {
+	for _, pillar := range []SaltPillar{
+		{Targets: []string{"10.0.0.2"}, TargetPillars: map[string]SignedPayload{
+			"10.0.0.1": signedTargetPillar(SaltPillar{Path: "/nodes/init.sls", Target: "10.0.0.1"}),
+		}},
+		{TargetPillars: map[string]SignedPayload{
+			"10.0.0.1": signedTargetPillar(SaltPillar{Path: "/nodes/init.sls", Target: "10.0.0.2"}),
+		}},
+	} {
+		body, _ := json.Marshal(pillar)
+		req := httptest.NewRequest("POST", SaltPillarDistributeEP, bytes.NewReader(body))
+		w := httptest.NewRecorder()
+
+		SaltPillarDistributeRequestHandler(w, req)
+
+		if w.Code != 400 {
+			t.Errorf("status code not match %d == %d", 400, w.Code)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package saltboot
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestWritePillarYaml(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "writepillartest")
+	defer os.RemoveAll(tempDirName)
+
+	pillar := SaltPillar{Path: "/path/file.sls", Json: map[string]interface{}{"key": []string{"a", "b"}}, Renderer: PILLAR_RENDERER_YAML}
+	if _, err := writePillarImpl(pillar, tempDirName); err != nil {
+		t.Fatalf("error occurred during write %s", err)
+	}
+
+	expected := "#!yaml\nkey:\n- a\n- b\n"
+	content, _ := os.ReadFile(tempDirName + PILLAR_ROOT + pillar.Path)
+	if string(content) != expected {
+		t.Errorf("yml content not match %s == %s", expected, string(content))
+	}
+
+	pillar.Renderer = "jinja"
+	if _, err := writePillarImpl(pillar, tempDirName); err == nil {
+		t.Error("error is expected for an unsupported renderer")
+	}
+}
+
+func newPillarTopTestDir(t *testing.T, top string, pillars ...string) string {
+	baseDir, _ := os.MkdirTemp("", "pillartoptest")
+	os.MkdirAll(baseDir+PILLAR_ROOT+"/nested", 0755)
+	for _, pillar := range pillars {
+		os.WriteFile(baseDir+PILLAR_ROOT+"/"+pillar, []byte("#!json\n{}"), 0644)
+	}
+	if len(top) > 0 {
+		os.WriteFile(baseDir+PILLAR_ROOT+PILLAR_TOP_FILE, []byte(top), 0644)
+	}
+	return baseDir
+}
+
+func readPillarTopFixture(t *testing.T, pillarRoot string) map[string]map[string][]interface{} {
+	content, _ := os.ReadFile(pillarRoot + PILLAR_TOP_FILE)
+	var top map[string]map[string][]interface{}
+	if err := yaml.Unmarshal(content, &top); err != nil {
+		t.Fatalf("unable to parse top file: %s", err)
+	}
+	return top
+}
+
+func TestApplyPillarTopKeepsUnmanagedEntries(t *testing.T) {
+	baseDir := newPillarTopTestDir(t, "base:\n  '*':\n  - manual\n")
+	defer os.RemoveAll(baseDir)
+	pillarRoot := baseDir + PILLAR_ROOT
+
+	applyPillarTop(pillarRoot, PillarTopRequest{Operation: PILLAR_TOP_ADD, Target: "*", Pillars: []string{"manual", "added"}})
+	applyPillarTop(pillarRoot, PillarTopRequest{Operation: PILLAR_TOP_ADD, Target: "roles:gateway", Match: "grain", Pillars: []string{"gateway"}})
+
+	top := readPillarTopFixture(t, pillarRoot)
+	if !reflect.DeepEqual(top["base"]["*"], []interface{}{"manual", "added"}) {
+		t.Errorf("entries not match: %v", top["base"]["*"])
+	}
+	if len(top["base"]["roles:gateway"]) != 2 {
+		t.Errorf("matcher and pillar are expected: %v", top["base"]["roles:gateway"])
+	}
+
+	result, _ := applyPillarTop(pillarRoot, PillarTopRequest{Operation: PILLAR_TOP_REMOVE, Target: "*", Pillars: []string{"manual", "added"}})
+	applyPillarTop(pillarRoot, PillarTopRequest{Operation: PILLAR_TOP_REMOVE, Target: "roles:gateway", Pillars: []string{"gateway"}})
+
+	top = readPillarTopFixture(t, pillarRoot)
+	expected := map[string]map[string][]interface{}{"base": {"*": {"manual"}}}
+	if !reflect.DeepEqual(top, expected) {
+		t.Errorf("top not match %v == %v", expected, top)
+	}
+	if !result.Changed || len(result.Managed["base"]["*"]) != 0 {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestApplyPillarTopMatchConflict(t *testing.T) {
+	baseDir := newPillarTopTestDir(t, "base:\n  'G@roles:a':\n  - match: compound\n  - a\n")
+	defer os.RemoveAll(baseDir)
+
+	if _, err := applyPillarTop(baseDir+PILLAR_ROOT, PillarTopRequest{Operation: PILLAR_TOP_ADD, Target: "G@roles:a", Match: "grain", Pillars: []string{"b"}}); err == nil {
+		t.Error("error is expected for a different matcher of the target")
+	}
+	if _, err := applyPillarTop(baseDir+PILLAR_ROOT, PillarTopRequest{Operation: PILLAR_TOP_ADD, Target: "G@roles:a", Match: "compound", Pillars: []string{"b"}}); err != nil {
+		t.Errorf("same matcher is rejected: %s", err)
+	}
+}
+
+func TestMissingPillarFiles(t *testing.T) {
+	baseDir := newPillarTopTestDir(t, "", "flat.sls", "nested/init.sls", "nested/child.sls")
+	defer os.RemoveAll(baseDir)
+
+	missing := missingPillarFiles(baseDir+PILLAR_ROOT, []string{"flat", "nested", "nested.child", "missing"})
+
+	if !reflect.DeepEqual(missing, []string{"missing"}) {
+		t.Errorf("missing pillars not match: %v", missing)
+	}
+}
+
+func TestPillarTopRequestValidate(t *testing.T) {
+	invalid := map[string]PillarTopRequest{
+		"operation": {Operation: "update", Target: "*", Pillars: []string{"a"}},
+		"saltenv":   {Operation: PILLAR_TOP_ADD, SaltEnv: "../base", Target: "*", Pillars: []string{"a"}},
+		"target":    {Operation: PILLAR_TOP_ADD, Target: " ", Pillars: []string{"a"}},
+		"match":     {Operation: PILLAR_TOP_ADD, Target: "*", Match: "regex", Pillars: []string{"a"}},
+		"pillars":   {Operation: PILLAR_TOP_ADD, Target: "*"},
+		"pillar":    {Operation: PILLAR_TOP_ADD, Target: "*", Pillars: []string{"../a"}},
+	}
+	for name, request := range invalid {
+		if err := request.Validate(); err == nil {
+			t.Errorf("invalid %s is accepted", name)
+		}
+	}
+}
+
+func TestSaltPillarTopRequestHandlerMissingPillar(t *testing.T) {
+	baseDir := newPillarTopTestDir(t, "", "exists.sls")
+	defer os.RemoveAll(baseDir)
+
+	for pillar, code := range map[string]int{"exists": 200, "missing": 400} {
+		body, _ := json.Marshal(PillarTopRequest{Operation: PILLAR_TOP_ADD, Target: "*", Pillars: []string{pillar}})
+		req := httptest.NewRequest("POST", SaltPillarTopEP, bytes.NewReader(body))
+		req.Header.Set("salt-master-base-dir", baseDir)
+		w := httptest.NewRecorder()
+
+		SaltPillarTopRequestHandler(w, req)
+
+		if w.Code != code {
+			t.Errorf("status code of %s not match %d == %d: %s", pillar, code, w.Code, w.Body.String())
+		}
+	}
+}
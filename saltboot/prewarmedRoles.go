@@ -0,0 +1,194 @@
+package saltboot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+const (
+	PREWARMED_ROLES_READ  = "read"
+	PREWARMED_ROLES_SET   = "set"
+	PREWARMED_ROLES_CLEAR = "clear"
+)
+
+var rolePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+type PrewarmedRolesRequest struct {
+	// action of the distribute request: read, set or clear
+	Action  string   `json:"action,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+	Targets []string `json:"targets,omitempty"`
+}
+
+// RoleAssignment shows where the roles of the minion come from
+type RoleAssignment struct {
+	Requested []string `json:"requested"`
+	Prewarmed []string `json:"prewarmed"`
+	Effective []string `json:"effective"`
+	// false if the grains already had roles and were kept as is
+	GrainsWritten bool `json:"grainsWritten"`
+}
+
+// MinionRunResult keeps the fields of the readiness result at the top level of the payload, the roles are added next to them.
+// The fields are copied rather than embedded, so the result does not inherit the Error and String methods of the readiness.
+type MinionRunResult struct {
+	Service       string         `json:"service,omitempty"`
+	Ready         bool           `json:"ready"`
+	ElapsedMillis int64          `json:"elapsedMillis"`
+	Probes        []ProbeResult  `json:"probes,omitempty"`
+	Roles         RoleAssignment `json:"roles"`
+}
+
+// minionRunResultOf copies the readiness result, which is missing if the service could not be started
+func minionRunResultOf(readiness *ReadinessResult, roles RoleAssignment) MinionRunResult {
+	result := MinionRunResult{Roles: roles}
+	if readiness != nil {
+		result.Service = readiness.Service
+		result.Ready = readiness.Ready
+		result.ElapsedMillis = readiness.ElapsedMillis
+		result.Probes = readiness.Probes
+	}
+	return result
+}
+
+func validateRoles(roles []string) ([]string, error) {
+	var result []string
+	for _, role := range roles {
+		role = strings.TrimSpace(role)
+		if !rolePattern.MatchString(role) {
+			return nil, fmt.Errorf("invalid role name: %s", role)
+		}
+		if !containsString(result, role) {
+			result = append(result, role)
+		}
+	}
+	return result, nil
+}
+
+func writePrewarmedRoles(file string, roles []string) error {
+	log.Printf("[writePrewarmedRoles] write prewarmed roles to %s: %s", file, roles)
+	return WriteFile(file, []byte(strings.Join(roles, "\n")+"\n"), 0644)
+}
+
+func clearPrewarmedRoles(file string) error {
+	log.Printf("[clearPrewarmedRoles] remove prewarmed roles: %s", file)
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// grainRoles returns the roles grain as strings
+func grainRoles(grains map[string]interface{}) (roles []string) {
+	if values, ok := grains[rolesGrain].([]interface{}); ok {
+		for _, value := range values {
+			roles = append(roles, fmt.Sprint(value))
+		}
+	}
+	return roles
+}
+
+func SaltMinionPrewarmedRolesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMinionPrewarmedRolesHandler] read prewarmed roles")
+	roles, err := readPrewarmedRoles(req.Header.Get("salt-minion-base-dir") + PREWARMED_ROLES_FILE)
+	if err != nil {
+		log.Printf("[SaltMinionPrewarmedRolesHandler] [ERROR] unable to read prewarmed roles: %s", err.Error())
+		model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}.WriteHttp(w)
+		return
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	model.Response{Status: strings.Join(roles, ","), Payload: roles}.WriteHttp(w)
+}
+
+func SaltMinionPrewarmedRolesSetHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMinionPrewarmedRolesSetHandler] set prewarmed roles")
+
+	decoder := json.NewDecoder(req.Body)
+	var rolesRequest PrewarmedRolesRequest
+	if err := decoder.Decode(&rolesRequest); err != nil {
+		log.Printf("[SaltMinionPrewarmedRolesSetHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	roles, err := validateRoles(rolesRequest.Roles)
+	if err == nil && len(roles) == 0 {
+		err = fmt.Errorf("no roles were specified in the request")
+	}
+	if err != nil {
+		log.Printf("[SaltMinionPrewarmedRolesSetHandler] [ERROR] invalid roles: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+
+	if err := writePrewarmedRoles(req.Header.Get("salt-minion-base-dir")+PREWARMED_ROLES_FILE, roles); err != nil {
+		log.Printf("[SaltMinionPrewarmedRolesSetHandler] [ERROR] unable to write prewarmed roles: %s", err.Error())
+		model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}.WriteHttp(w)
+		return
+	}
+	model.Response{Status: strings.Join(roles, ","), Payload: roles}.WriteHttp(w)
+}
+
+func SaltMinionPrewarmedRolesClearHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMinionPrewarmedRolesClearHandler] clear prewarmed roles")
+	if err := clearPrewarmedRoles(req.Header.Get("salt-minion-base-dir") + PREWARMED_ROLES_FILE); err != nil {
+		log.Printf("[SaltMinionPrewarmedRolesClearHandler] [ERROR] unable to clear prewarmed roles: %s", err.Error())
+		model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}.WriteHttp(w)
+		return
+	}
+	model.Response{Status: "prewarmed roles are cleared", Payload: []string{}}.WriteHttp(w)
+}
+
+func prewarmedRolesEndpoint(action string) (string, error) {
+	switch action {
+	case PREWARMED_ROLES_READ:
+		return SaltMinionPrewarmedRolesEP, nil
+	case PREWARMED_ROLES_SET:
+		return SaltMinionPrewarmedRolesSetEP, nil
+	case PREWARMED_ROLES_CLEAR:
+		return SaltMinionPrewarmedRolesClearEP, nil
+	}
+	return "", fmt.Errorf("invalid action: %s, supported actions: %s, %s, %s", action, PREWARMED_ROLES_READ, PREWARMED_ROLES_SET, PREWARMED_ROLES_CLEAR)
+}
+
+func SaltMinionPrewarmedRolesDistributeHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMinionPrewarmedRolesDistributeHandler] distribute prewarmed roles request")
+
+	decoder := json.NewDecoder(req.Body)
+	var rolesRequest PrewarmedRolesRequest
+	if err := decoder.Decode(&rolesRequest); err != nil {
+		log.Printf("[SaltMinionPrewarmedRolesDistributeHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(rolesRequest.Targets) == 0 {
+		log.Printf("[SaltMinionPrewarmedRolesDistributeHandler] [ERROR] no targets were specified in the request")
+		model.Response{Status: "no targets were specified in the request"}.WriteBadRequestHttp(w)
+		return
+	}
+	endpoint, err := prewarmedRolesEndpoint(rolesRequest.Action)
+	if err == nil && rolesRequest.Action == PREWARMED_ROLES_SET {
+		_, err = validateRoles(rolesRequest.Roles)
+	}
+	if err != nil {
+		log.Printf("[SaltMinionPrewarmedRolesDistributeHandler] [ERROR] invalid request: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+
+	user, pass := GetAuthUserPass(req)
+	result := distributeToTargetsImpl(DistributeRequest, rolesRequest.Targets, endpoint, user, pass, GetSignedRequestBody(req))
+	cResp := model.Responses{Responses: result}
+	log.Printf("[SaltMinionPrewarmedRolesDistributeHandler] distribute prewarmed roles request executed: %s", cResp.String())
+	if err := json.NewEncoder(w).Encode(cResp); err != nil {
+		log.Printf("[SaltMinionPrewarmedRolesDistributeHandler] [ERROR] couldn't encode json: %s", err.Error())
+	}
+}
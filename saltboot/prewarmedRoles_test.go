@@ -0,0 +1,103 @@
+package saltboot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateRoles(t *testing.T) {
+	roles, err := validateRoles([]string{"manager_server", " manager_agent ", "manager_server"})
+	if err != nil || !reflect.DeepEqual(roles, []string{"manager_server", "manager_agent"}) {
+		t.Errorf("roles not match: %v %v", roles, err)
+	}
+	for _, role := range []string{"", "role with space", "../role", "role\nother"} {
+		if _, err := validateRoles([]string{role}); err == nil {
+			t.Errorf("invalid role is accepted: %q", role)
+		}
+	}
+}
+
+func TestPrewarmedRolesHandlers(t *testing.T) {
+	baseDir := newGrainsTestDir(t, "")
+	defer os.RemoveAll(baseDir)
+
+	body, _ := json.Marshal(PrewarmedRolesRequest{Roles: []string{"a", "b"}})
+	req := httptest.NewRequest("POST", SaltMinionPrewarmedRolesSetEP, bytes.NewReader(body))
+	req.Header.Set("salt-minion-base-dir", baseDir)
+	w := httptest.NewRecorder()
+	SaltMinionPrewarmedRolesSetHandler(w, req)
+	if w.Code != 200 {
+		t.Fatalf("status code not match %d == %d: %s", 200, w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", SaltMinionPrewarmedRolesEP, nil)
+	req.Header.Set("salt-minion-base-dir", baseDir)
+	w = httptest.NewRecorder()
+	SaltMinionPrewarmedRolesHandler(w, req)
+	var resp struct {
+		Payload []string `json:"payload"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !reflect.DeepEqual(resp.Payload, []string{"a", "b"}) {
+		t.Errorf("prewarmed roles not match: %v", resp.Payload)
+	}
+
+	req = httptest.NewRequest("POST", SaltMinionPrewarmedRolesClearEP, nil)
+	req.Header.Set("salt-minion-base-dir", baseDir)
+	w = httptest.NewRecorder()
+	SaltMinionPrewarmedRolesClearHandler(w, req)
+	if _, err := os.Stat(baseDir + PREWARMED_ROLES_FILE); !os.IsNotExist(err) {
+		t.Error("prewarmed roles are expected to be cleared")
+	}
+
+	body, _ = json.Marshal(PrewarmedRolesRequest{Roles: []string{"in valid"}})
+	req = httptest.NewRequest("POST", SaltMinionPrewarmedRolesSetEP, bytes.NewReader(body))
+	req.Header.Set("salt-minion-base-dir", baseDir)
+	w = httptest.NewRecorder()
+	SaltMinionPrewarmedRolesSetHandler(w, req)
+	if w.Code != 400 {
+		t.Errorf("status code not match %d == %d", 400, w.Code)
+	}
+}
+
+func TestPrewarmedRolesEndpoint(t *testing.T) {
+	expected := map[string]string{
+		PREWARMED_ROLES_READ:  SaltMinionPrewarmedRolesEP,
+		PREWARMED_ROLES_SET:   SaltMinionPrewarmedRolesSetEP,
+		PREWARMED_ROLES_CLEAR: SaltMinionPrewarmedRolesClearEP,
+	}
+	for action, endpoint := range expected {
+		if actual, err := prewarmedRolesEndpoint(action); err != nil || actual != endpoint {
+			t.Errorf("endpoint not match %s == %s", endpoint, actual)
+		}
+	}
+	if _, err := prewarmedRolesEndpoint("append"); err == nil {
+		t.Error("error is expected for an unknown action")
+	}
+}
+
+func TestMinionRunResultKeepsReadinessAtTopLevel(t *testing.T) {
+	result := minionRunResultOf(&ReadinessResult{Service: "salt-minion", Ready: true}, RoleAssignment{Requested: []string{"a"}})
+
+	var payload map[string]interface{}
+	b, _ := json.Marshal(result)
+	json.Unmarshal(b, &payload)
+
+	if payload["service"] != "salt-minion" || payload["ready"] != true || payload["roles"] == nil {
+		t.Errorf("readiness fields are expected at the top level of the payload: %s", b)
+	}
+}
+
+func TestMinionRunResultWithoutReadiness(t *testing.T) {
+	result := minionRunResultOf(nil, RoleAssignment{Requested: []string{"a"}})
+
+	if s := fmt.Sprintf("%v", result); strings.Contains(s, "PANIC") || result.Ready {
+		t.Errorf("result without readiness is expected to be printable and not ready: %s", s)
+	}
+}
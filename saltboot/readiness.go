@@ -0,0 +1,157 @@
+package saltboot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TCP_PROBE      = "tcp"
+	PID_FILE_PROBE = "pidfile"
+	FILE_PROBE     = "file"
+
+	readinessTimeoutKey     = "SALTBOOT_READINESS_TIMEOUT"
+	defaultReadinessTimeout = 30 * time.Second
+)
+
+var readinessPollInterval = 500 * time.Millisecond
+
+var serviceReadinessProbes = map[string][]ReadinessProbe{
+	"salt-master": {
+		{Type: TCP_PROBE, Address: "127.0.0.1:4505"},
+		{Type: TCP_PROBE, Address: "127.0.0.1:4506"},
+	},
+	"salt-api": {
+		{Type: TCP_PROBE, Address: "127.0.0.1:3080"},
+	},
+	// the paths are relative to the salt-minion-base-dir header
+	"salt-minion": {
+		{Type: PID_FILE_PROBE, Path: PID_FILE_LOCATION + "salt-minion.pid"},
+		{Type: FILE_PROBE, Path: MinionPublicKey},
+	},
+}
+
+type ReadinessProbe struct {
+	Type    string `json:"type"`
+	Address string `json:"address,omitempty"`
+	Path    string `json:"path,omitempty"`
+}
+
+func (p ReadinessProbe) String() string {
+	if p.Type == TCP_PROBE {
+		return p.Type + ":" + p.Address
+	}
+	return p.Type + ":" + p.Path
+}
+
+type ProbeResult struct {
+	Probe     string `json:"probe"`
+	Ready     bool   `json:"ready"`
+	ErrorText string `json:"errorText,omitempty"`
+}
+
+type ReadinessResult struct {
+	Service       string        `json:"service"`
+	Ready         bool          `json:"ready"`
+	ElapsedMillis int64         `json:"elapsedMillis"`
+	Probes        []ProbeResult `json:"probes,omitempty"`
+}
+
+func (r ReadinessResult) String() string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
+
+func (r ReadinessResult) Error() string {
+	var failures []string
+	for _, probe := range r.Probes {
+		if !probe.Ready {
+			failures = append(failures, probe.Probe+" ("+probe.ErrorText+")")
+		}
+	}
+	return fmt.Sprintf("%s is not ready after %d ms, failed probes: %s", r.Service, r.ElapsedMillis, strings.Join(failures, ", "))
+}
+
+func (p ReadinessProbe) check() error {
+	switch p.Type {
+	case TCP_PROBE:
+		conn, err := net.DialTimeout("tcp", p.Address, time.Second)
+		if err != nil {
+			return err
+		}
+		closeIt(conn)
+		return nil
+	case PID_FILE_PROBE:
+		content, err := os.ReadFile(p.Path)
+		if err != nil {
+			return err
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+		if err != nil {
+			return fmt.Errorf("invalid pid file %s: %s", p.Path, err.Error())
+		}
+		if err := checkProcessRunning(pid); err != nil {
+			return fmt.Errorf("process %d is not running: %s", pid, err.Error())
+		}
+		return nil
+	case FILE_PROBE:
+		_, err := os.Stat(p.Path)
+		return err
+	}
+	return fmt.Errorf("unknown probe type: %s", p.Type)
+}
+
+// readinessProbes returns the probes of the service with the file paths under the base dir
+func readinessProbes(service string, baseDir string) []ReadinessProbe {
+	var probes []ReadinessProbe
+	for _, probe := range serviceReadinessProbes[service] {
+		if probe.Type != TCP_PROBE {
+			probe.Path = baseDir + probe.Path
+		}
+		probes = append(probes, probe)
+	}
+	return probes
+}
+
+func determineReadinessTimeout() time.Duration {
+	timeoutStr := os.Getenv(readinessTimeoutKey)
+	if timeout, err := strconv.Atoi(timeoutStr); err == nil && timeout >= 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return defaultReadinessTimeout
+}
+
+// waitForReady blocks until every readiness probe of the service succeeds or the timeout elapses
+func waitForReady(service string, probes []ReadinessProbe, timeout time.Duration) ReadinessResult {
+	log.Printf("[waitForReady] wait %s for %s to become ready, probes: %s", timeout, service, probes)
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		result := ReadinessResult{Service: service, Ready: true}
+		for _, probe := range probes {
+			probeResult := ProbeResult{Probe: probe.String(), Ready: true}
+			if err := probe.check(); err != nil {
+				probeResult.Ready = false
+				probeResult.ErrorText = err.Error()
+				result.Ready = false
+			}
+			result.Probes = append(result.Probes, probeResult)
+		}
+		result.ElapsedMillis = time.Since(start).Milliseconds()
+		if result.Ready {
+			log.Printf("[waitForReady] %s is ready: %s", service, result.String())
+			return result
+		}
+		if time.Now().Add(readinessPollInterval).After(deadline) {
+			log.Printf("[waitForReady] [ERROR] %s", result.Error())
+			return result
+		}
+		time.Sleep(readinessPollInterval)
+	}
+}
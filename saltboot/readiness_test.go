@@ -0,0 +1,165 @@
+package saltboot
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+func init() {
+	// the handler tests do not run real salt services, so there is nothing to wait for
+	serviceReadinessProbes = map[string][]ReadinessProbe{}
+	readinessPollInterval = 10 * time.Millisecond
+}
+
+func TestTcpProbeReady(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	defer listener.Close()
+
+	result := waitForReady("test", []ReadinessProbe{{Type: TCP_PROBE, Address: listener.Addr().String()}}, time.Second)
+
+	if !result.Ready || len(result.Probes) != 1 || !result.Probes[0].Ready {
+		t.Errorf("service is expected to be ready: %s", result.String())
+	}
+}
+
+func TestTcpProbeTimeout(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := listener.Addr().String()
+	listener.Close()
+
+	result := waitForReady("test", []ReadinessProbe{{Type: TCP_PROBE, Address: address}}, 50*time.Millisecond)
+
+	if result.Ready || result.Probes[0].Ready || len(result.Probes[0].ErrorText) == 0 {
+		t.Errorf("service is not expected to be ready: %s", result.String())
+	}
+	if !strings.Contains(result.Error(), "tcp:"+address) {
+		t.Errorf("failed probe is missing from the error: %s", result.Error())
+	}
+}
+
+func TestPidFileProbe(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "readinesstest")
+	defer os.RemoveAll(tempDirName)
+	pidFile := tempDirName + "/test.pid"
+	probes := []ReadinessProbe{{Type: PID_FILE_PROBE, Path: pidFile}}
+
+	if result := waitForReady("test", probes, 0); result.Ready {
+		t.Errorf("missing pid file is not expected to be ready: %s", result.String())
+	}
+
+	os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+	if result := waitForReady("test", probes, 0); !result.Ready {
+		t.Errorf("pid file of a running process is expected to be ready: %s", result.String())
+	}
+
+	os.WriteFile(pidFile, []byte("not-a-pid"), 0644)
+	if result := waitForReady("test", probes, 0); result.Ready {
+		t.Errorf("invalid pid file is not expected to be ready: %s", result.String())
+	}
+}
+
+func TestFileProbeWaitsForFile(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "readinesstest")
+	defer os.RemoveAll(tempDirName)
+	keyFile := tempDirName + "/minion.pub"
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		os.WriteFile(keyFile, []byte("key"), 0644)
+	}()
+	result := waitForReady("test", []ReadinessProbe{{Type: FILE_PROBE, Path: keyFile}}, 5*time.Second)
+
+	if !result.Ready {
+		t.Errorf("service is expected to be ready once the file appears: %s", result.String())
+	}
+}
+
+func TestUnknownProbeType(t *testing.T) {
+	result := waitForReady("test", []ReadinessProbe{{Type: "unknown"}}, 0)
+
+	if result.Ready {
+		t.Errorf("unknown probe is not expected to be ready: %s", result.String())
+	}
+}
+
+func TestDetermineReadinessTimeout(t *testing.T) {
+	defer os.Unsetenv(readinessTimeoutKey)
+
+	os.Setenv(readinessTimeoutKey, "5")
+	if timeout := determineReadinessTimeout(); timeout != 5*time.Second {
+		t.Errorf("timeout not match %s == %s", 5*time.Second, timeout)
+	}
+
+	os.Setenv(readinessTimeoutKey, "invalid")
+	if timeout := determineReadinessTimeout(); timeout != defaultReadinessTimeout {
+		t.Errorf("timeout not match %s == %s", defaultReadinessTimeout, timeout)
+	}
+}
+
+func TestWaitForServiceReadinessFailure(t *testing.T) {
+	probes := []ReadinessProbe{{Type: FILE_PROBE, Path: "/nonexistent/test-service.pub"}}
+
+	resp, err := waitForServiceReadiness("test-service", probes, 0, model.Response{Status: "test-service started", StatusCode: http.StatusOK}, nil)
+
+	if err == nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("readiness failure is expected: %s", resp.String())
+	}
+	if readiness, ok := resp.Payload.(ReadinessResult); !ok || readiness.Ready {
+		t.Errorf("readiness result is expected in the payload: %s", resp.String())
+	}
+}
+
+func TestReadinessProbesUnderBaseDir(t *testing.T) {
+	defaults := serviceReadinessProbes
+	defer func() {
+		serviceReadinessProbes = defaults
+	}()
+	serviceReadinessProbes = map[string][]ReadinessProbe{"salt-minion": {
+		{Type: PID_FILE_PROBE, Path: PID_FILE_LOCATION + "salt-minion.pid"},
+		{Type: FILE_PROBE, Path: MinionPublicKey},
+		{Type: TCP_PROBE, Address: "127.0.0.1:4505"},
+	}}
+
+	probes := readinessProbes("salt-minion", "/base")
+
+	expected := []ReadinessProbe{
+		{Type: PID_FILE_PROBE, Path: "/base" + PID_FILE_LOCATION + "salt-minion.pid"},
+		{Type: FILE_PROBE, Path: "/base" + MinionPublicKey},
+		{Type: TCP_PROBE, Address: "127.0.0.1:4505"},
+	}
+	if len(probes) != len(expected) {
+		t.Fatalf("probes not match %v == %v", expected, probes)
+	}
+	for i := range expected {
+		if probes[i] != expected[i] {
+			t.Errorf("probe not match %v == %v", expected[i], probes[i])
+		}
+	}
+	if serviceReadinessProbes["salt-minion"][1].Path != MinionPublicKey {
+		t.Error("default probes are not expected to be changed")
+	}
+}
+
+func TestSaltActionRequestReadinessTimeout(t *testing.T) {
+	timeout, negative := 5, -1
+
+	if actual, err := (SaltActionRequest{ReadinessTimeout: &timeout}).readinessTimeout(); err != nil || actual != 5*time.Second {
+		t.Errorf("timeout not match %s == %s, %v", 5*time.Second, actual, err)
+	}
+	if actual, _ := (SaltActionRequest{}).readinessTimeout(); actual != defaultReadinessTimeout {
+		t.Errorf("timeout not match %s == %s", defaultReadinessTimeout, actual)
+	}
+	if _, err := (SaltActionRequest{ReadinessTimeout: &negative}).readinessTimeout(); err == nil {
+		t.Error("negative timeout is expected to be rejected")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package saltboot
+
+import "syscall"
+
+// checkProcessRunning sends the null signal, a process of another user is reported as running
+func checkProcessRunning(pid int) error {
+	if err := syscall.Kill(pid, 0); err != nil && err != syscall.EPERM {
+		return err
+	}
+	return nil
+}
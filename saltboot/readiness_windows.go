@@ -0,0 +1,12 @@
+package saltboot
+
+import "os"
+
+// checkProcessRunning opens the process, which fails if it does not exist
+func checkProcessRunning(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Release()
+}
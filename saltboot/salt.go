@@ -1,15 +1,16 @@
 package saltboot
 
 import (
-	"bufio"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strings"
 
 	"strconv"
+	"time"
 
 	"github.com/hortonworks/salt-bootstrap/saltboot/model"
 	"gopkg.in/yaml.v2"
@@ -20,8 +21,12 @@ type SaltActionRequest struct {
 	Masters []SaltMaster `json:"masters,omitempty"`
 	Minions []SaltMinion `json:"minions,omitempty"`
 	Action  string       `json:"action"`
-	Cloud   *Cloud       `json:"cloud"`
-	OS      *Os          `json:"os"`
+	// addresses of the nodes whose hosts entries are cleaned up on decommission
+	Peers []string `json:"peers,omitempty"`
+	Cloud *Cloud   `json:"cloud"`
+	OS    *Os      `json:"os"`
+	// seconds to wait for the started services to become ready, SALTBOOT_READINESS_TIMEOUT is used if it is not set
+	ReadinessTimeout *int `json:"readinessTimeout,omitempty"`
 }
 
 type Cloud struct {
@@ -47,28 +52,53 @@ type RequestBody struct {
 	SignedPayload string
 }
 
+// SignedPayload is a payload signed on its own by the orchestrator, so that it can be forwarded to a single node without
+// the rest of the request it was sent in
+type SignedPayload struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+func (p SignedPayload) requestBody() RequestBody {
+	return RequestBody{SignedPayload: p.Payload, Signature: p.Signature}
+}
+
 type SaltMaster struct {
-	Address  string   `json:"address"`
-	Auth     SaltAuth `json:"auth,omitempty"`
-	Hostname *string  `json:"hostName,omitempty"`
-	Domain   string   `json:"domain,omitempty"`
+	Address  string        `json:"address"`
+	Auth     SaltAuth      `json:"auth,omitempty"`
+	Hostname *string       `json:"hostName,omitempty"`
+	Domain   string        `json:"domain,omitempty"`
+	Config   *MasterConfig `json:"config,omitempty"`
 }
 
 type SaltMinion struct {
-	Address       string   `json:"address"`
-	Roles         []string `json:"roles,omitempty"`
-	Server        string   `json:"server,omitempty"`
-	Servers       []string `json:"servers,omitempty"`
-	HostGroup     string   `json:"hostGroup,omitempty"`
-	Hostname      *string  `json:"hostName,omitempty"`
-	Domain        string   `json:"domain,omitempty"`
-	RestartNeeded *bool    `json:"restartNeeded,omitempty"`
+	Address       string        `json:"address"`
+	Roles         []string      `json:"roles,omitempty"`
+	Server        string        `json:"server,omitempty"`
+	Servers       []string      `json:"servers,omitempty"`
+	HostGroup     string        `json:"hostGroup,omitempty"`
+	Hostname      *string       `json:"hostName,omitempty"`
+	Domain        string        `json:"domain,omitempty"`
+	RestartNeeded *bool         `json:"restartNeeded,omitempty"`
+	Config        *MinionConfig `json:"config,omitempty"`
+	// expected fingerprints of the masters, pinned as master_finger
+	MasterFingerprints []string `json:"masterFingerprints,omitempty"`
 }
 
 type SaltPillar struct {
 	Path    string                 `json:"path"`
 	Json    map[string]interface{} `json:"json"`
 	Targets []string               `json:"targets"`
+	// renderer of the written pillar file: json (default) or yaml
+	Renderer string `json:"renderer,omitempty"`
+	// dot separated paths of the string values to encrypt, e.g. postgres.password
+	Secrets []string `json:"secrets,omitempty"`
+	// encryption of the secrets: nacl or gpg, defaults to SALTBOOT_PILLAR_ENCRYPTION or nacl
+	Encryption string `json:"encryption,omitempty"`
+	// pillar of each target signed separately, the payload is a pillar bound to the target, each target receives only its own
+	TargetPillars map[string]SignedPayload `json:"targetPillars,omitempty"`
+	// address of the node the pillar is bound to, the pillar is refused by every other node
+	Target string `json:"target,omitempty"`
 }
 
 func (saltMinion SaltMinion) AsByteArray() []byte {
@@ -95,6 +125,16 @@ func (r SaltActionRequest) String() string {
 	return string(b)
 }
 
+func (r SaltActionRequest) readinessTimeout() (time.Duration, error) {
+	if r.ReadinessTimeout == nil {
+		return determineReadinessTimeout(), nil
+	}
+	if *r.ReadinessTimeout < 0 {
+		return 0, fmt.Errorf("invalid readiness timeout: %d", *r.ReadinessTimeout)
+	}
+	return time.Duration(*r.ReadinessTimeout) * time.Second, nil
+}
+
 func (r SaltActionRequest) distributeAction(user string, pass string, signedRequestBody RequestBody) []model.Response {
 	log.Print("[distributeAction] distribute salt state command to targets")
 	return distributeActionImpl(DistributeRequest, r, user, pass, signedRequestBody)
@@ -126,6 +166,13 @@ func distributeActionImpl(distributeActionRequest func([]string, string, string,
 		log.Printf("[distributeActionImpl] send action request to master: %s", request.Master.Address)
 		result = append(result, <-distributeActionRequest([]string{request.Master.Address}, SaltServerEp+"/"+action, user, pass, requestBody))
 	}
+
+	if action == DECOMMISSION_ACTION && len(request.Peers) > 0 {
+		log.Printf("[distributeActionImpl] send hosts cleanup request to peers: %s", request.Peers)
+		for res := range distributeActionRequest(request.Peers, HostsCleanupEP, user, pass, requestBody) {
+			result = append(result, res)
+		}
+	}
 	return result
 }
 
@@ -151,6 +198,12 @@ func SaltMinionRunRequestHandler(w http.ResponseWriter, req *http.Request) {
 		resp.WriteHttp(w)
 		return
 	}
+	readinessTimeout, err := saltActionRequest.readinessTimeout()
+	if err != nil {
+		log.Printf("[SaltMinionRunRequestHandler] [ERROR] %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
 	saltMinion := saltActionRequest.Minions[index]
 	if saltMinion.Server == "" {
 		saltMinion.Server = saltActionRequest.Master.Address
@@ -158,6 +211,25 @@ func SaltMinionRunRequestHandler(w http.ResponseWriter, req *http.Request) {
 	if saltMinion.Domain == "" {
 		saltMinion.Domain = saltActionRequest.Master.Domain
 	}
+	servers := saltMinion.Servers
+	if len(saltMinion.MasterFingerprints) > 0 {
+		if saltMinion.Config, err = pinMasterFingerprint(saltMinion.Config, saltMinion.MasterFingerprints); err != nil {
+			log.Printf("[SaltMinionRunRequestHandler] [ERROR] unable to pin master fingerprint: %s", err.Error())
+			model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+			return
+		}
+	}
+	if saltMinion.Config != nil {
+		masters := servers
+		if len(masters) == 0 {
+			masters = []string{saltMinion.Server}
+		}
+		if err := saltMinion.Config.Validate(masters); err != nil {
+			log.Printf("[SaltMinionRunRequestHandler] [ERROR] invalid minion config: %s", err.Error())
+			model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+			return
+		}
+	}
 
 	err = ensureHostIsResolvable(saltMinion.Hostname, saltMinion.Domain, saltMinion.Address, saltActionRequest.OS, saltActionRequest.Cloud)
 	if err != nil {
@@ -169,7 +241,7 @@ func SaltMinionRunRequestHandler(w http.ResponseWriter, req *http.Request) {
 
 	baseDir := req.Header.Get("salt-minion-base-dir")
 
-	err = os.MkdirAll(baseDir+"/etc/salt/minion.d", 0755)
+	err = os.MkdirAll(baseDir+MINION_CONFIG_DIR, 0755)
 	if err != nil {
 		resp = model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}
 		resp.WriteHttp(w)
@@ -177,7 +249,6 @@ func SaltMinionRunRequestHandler(w http.ResponseWriter, req *http.Request) {
 	}
 
 	var masterConf []byte
-	servers := saltMinion.Servers
 	var restartNeeded bool
 	log.Printf("[SaltMinionRunRequestHandler] Restart needed flag on minion: %v", saltMinion.IsRestartNeeded())
 	if servers != nil && len(servers) > 0 {
@@ -190,57 +261,60 @@ func SaltMinionRunRequestHandler(w http.ResponseWriter, req *http.Request) {
 		restartNeeded = saltMinion.IsRestartNeeded() || isSaltMasterIpDiffers([]string{saltMinion.Server})
 	}
 
-	err = WriteFile(baseDir+"/etc/salt/minion.d/master.conf", masterConf, 0644)
+	err = WriteFile(baseDir+MINION_CONFIG_DIR+"/master.conf", masterConf, 0644)
 	if err != nil {
 		resp = model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}
 		resp.WriteHttp(w)
 		return
 	}
 
-	grainConfigPath := baseDir + "/etc/salt/grains"
-	prewarmedRolesPath := baseDir + "/etc/salt/prewarmed_roles"
-	if isGrainsConfigNeeded(grainConfigPath) {
-		// Check if prewarmed roles exist, and add them to the roles before generating the file
-		if shouldAppendPrewarmedRoles(prewarmedRolesPath) {
-			file, err := os.Open(prewarmedRolesPath)
-			if err != nil {
-				resp = model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}
-				resp.WriteHttp(w)
-				return
-			}
-			defer file.Close()
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				s := strings.TrimSpace(scanner.Text())
-				if len(s) > 0 {
-					saltMinion.Roles = append(saltMinion.Roles, s)
-				}
-			}
-		}
-
-		grainConfig := GrainConfig{Roles: saltMinion.Roles, HostGroup: saltMinion.HostGroup}
-		grainYaml, err := yaml.Marshal(grainConfig)
+	if saltMinion.Config != nil {
+		configChanged, err := writeMinionConfig(*saltMinion.Config, baseDir+MINION_CONFIG_DIR)
 		if err != nil {
+			log.Printf("[SaltMinionRunRequestHandler] [ERROR] unable to write minion config: %s", err.Error())
 			resp = model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}
 			resp.WriteHttp(w)
 			return
 		}
-		err = WriteFile(grainConfigPath, grainYaml, 0644)
+		if configChanged {
+			log.Println("[SaltMinionRunRequestHandler] minion config changed, restart needed")
+			restartNeeded = true
+		}
+	}
+
+	grainConfigPath := baseDir + GRAINS_FILE
+	roles := RoleAssignment{Requested: saltMinion.Roles}
+	if isGrainsConfigNeeded(grainConfigPath) {
+		grains := map[string]interface{}{rolesGrain: saltMinion.Roles, "hostgroup": saltMinion.HostGroup}
+		result, err := applyGrains(baseDir, GrainsRequest{Operation: GRAINS_SET, Grains: grains}, true)
 		if err != nil {
 			resp = model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}
 			resp.WriteHttp(w)
 			return
 		}
+		roles.GrainsWritten = true
+		roles.Prewarmed = result.PrewarmedRoles
+		roles.Effective = grainRoles(result.Grains)
+	} else if grains, _, err := readGrains(grainConfigPath); err == nil {
+		roles.Effective = grainRoles(grains)
 	}
+	log.Printf("[SaltMinionRunRequestHandler] roles requested: %s, prewarmed: %s, effective: %s", roles.Requested, roles.Prewarmed, roles.Effective)
 
 	log.Println("[SaltMinionRunRequestHandler] execute salt-minion run request")
 	if restartNeeded {
-		resp, _ = RestartService("salt-minion")
-		resp.WriteHttp(w)
+		resp, err = RestartServiceAndWait("salt-minion", baseDir, readinessTimeout)
 	} else {
-		resp, _ = LaunchService("salt-minion")
-		resp.WriteHttp(w)
+		resp, err = LaunchServiceAndWait("salt-minion", baseDir, readinessTimeout)
+	}
+	if err != nil {
+		log.Printf("[SaltMinionRunRequestHandler] [ERROR] salt-minion is not running: %s", err.Error())
+	}
+	var readiness *ReadinessResult
+	if result, ok := resp.Payload.(ReadinessResult); ok {
+		readiness = &result
 	}
+	resp.Payload = minionRunResultOf(readiness, roles)
+	resp.WriteHttp(w)
 }
 
 func SaltMinionStopRequestHandler(w http.ResponseWriter, req *http.Request) {
@@ -252,7 +326,11 @@ func SaltMinionStopRequestHandler(w http.ResponseWriter, req *http.Request) {
 
 func SaltMinionKeyHandler(w http.ResponseWriter, req *http.Request) {
 	log.Println("[SaltMinionKeyHandler] fetch the salt-minion's fingerprint")
-	fingerprint := getMinionFingerprintFromPrivateKey()
+	hashType := req.URL.Query().Get("hash_type")
+	if len(hashType) == 0 {
+		hashType = DEFAULT_HASH_TYPE
+	}
+	fingerprint := getMinionFingerprint(hashType)
 	fingerprint.WriteHttp(w)
 }
 
@@ -276,7 +354,13 @@ func SaltMinionKeyDistributionHandler(w http.ResponseWriter, req *http.Request)
 	user, pass := GetAuthUserPass(req)
 	signedRequestBody := GetSignedRequestBody(req)
 
-	result := fingerprintsRequest.distributeRequest(user, pass, signedRequestBody)
+	hashType := req.URL.Query().Get("hash_type")
+	if _, ok := fingerprintHashes[hashType]; len(hashType) > 0 && !ok {
+		log.Printf("[SaltMinionKeyDistributionHandler] [ERROR] unsupported hash type: %s", hashType)
+		FingerprintsResponse{}.WriteBadRequestHttp(w, errors.New("unsupported hash type: "+hashType))
+		return
+	}
+	result := fingerprintsRequest.distributeRequest(hashType, user, pass, signedRequestBody)
 	response := FingerprintsResponse{Fingerprints: result, StatusCode: 200}
 	log.Printf("[SaltMinionKeyDistributionHandler] distribute fingerprint request executed: %s", response.String())
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -300,6 +384,19 @@ func SaltServerRunRequestHandler(w http.ResponseWriter, req *http.Request) {
 		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
 		return
 	}
+	readinessTimeout, err := saltActionRequest.readinessTimeout()
+	if err != nil {
+		log.Printf("[SaltServerRunRequestHandler] [ERROR] %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if saltMaster.Config != nil {
+		if err := saltMaster.Config.Validate(); err != nil {
+			log.Printf("[SaltServerRunRequestHandler] [ERROR] invalid master config: %s", err.Error())
+			model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+			return
+		}
+	}
 
 	var resp model.Response
 
@@ -319,25 +416,47 @@ func SaltServerRunRequestHandler(w http.ResponseWriter, req *http.Request) {
 	}
 	responses = append(responses, resp)
 
-	resp, err = LaunchService("salt-master")
-	if err != nil {
-		resp.WriteHttp(w)
-		return
-	}
-	responses = append(responses, resp)
-
-	resp, err = LaunchService("salt-api")
-	if err != nil {
-		resp.WriteHttp(w)
-		return
+	restartNeeded := map[string]bool{}
+	if saltMaster.Config != nil {
+		configDir := req.Header.Get("salt-master-base-dir") + MASTER_CONFIG_DIR
+		if err := os.MkdirAll(configDir, 0755); err != nil {
+			model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}.WriteHttp(w)
+			return
+		}
+		masterChanged, apiChanged, err := writeMasterConfig(*saltMaster.Config, configDir, GetHttpsConfig())
+		if err != nil {
+			log.Printf("[SaltServerRunRequestHandler] [ERROR] unable to write master config: %s", err.Error())
+			model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}.WriteHttp(w)
+			return
+		}
+		log.Printf("[SaltServerRunRequestHandler] master config changed: %v, salt-api config changed: %v", masterChanged, apiChanged)
+		restartNeeded["salt-master"] = masterChanged
+		// salt-api authenticates through the external_auth of the master config
+		restartNeeded["salt-api"] = masterChanged || apiChanged
+	}
+
+	var readiness []interface{}
+	for _, service := range []string{"salt-master", "salt-api"} {
+		if restartNeeded[service] {
+			resp, err = RestartService(service)
+		} else {
+			resp, err = LaunchService(service)
+		}
+		resp, err = waitForServiceReadiness(service, saltServerReadinessProbes(service, saltMaster.Config), readinessTimeout, resp, err)
+		if err != nil {
+			log.Printf("[SaltServerRunRequestHandler] [ERROR] %s is not running: %s", service, err.Error())
+			resp.WriteHttp(w)
+			return
+		}
+		responses = append(responses, resp)
+		readiness = append(readiness, resp.Payload)
 	}
-	responses = append(responses, resp)
 
 	var message string
 	for _, r := range responses {
 		message += r.Status + "; "
 	}
-	finalResponse := model.Response{Status: message, StatusCode: http.StatusOK}
+	finalResponse := model.Response{Status: message, StatusCode: http.StatusOK, Payload: readiness}
 	finalResponse.WriteHttp(w)
 }
 
@@ -398,7 +517,10 @@ func (pillar SaltPillar) WritePillar() (outStr string, err error) {
 }
 
 func writePillarImpl(pillar SaltPillar, basePath string) (outStr string, err error) {
-	file := basePath + "/srv/pillar" + pillar.Path
+	pillarLock.Lock()
+	defer pillarLock.Unlock()
+
+	file := basePath + PILLAR_ROOT + pillar.Path
 	dir := file[0:strings.LastIndex(file, "/")]
 
 	log.Printf("[SaltPillar.WritePillar] mkdir %s", dir)
@@ -407,9 +529,19 @@ func writePillarImpl(pillar SaltPillar, basePath string) (outStr string, err err
 		return "Failed to create dir " + dir, err
 	}
 
-	jsonDef := []byte("#!json\n")
-	jsn, _ := json.MarshalIndent(pillar.Json, "", "\t")
-	err = WriteFile(file, append(jsonDef, jsn...), 0644)
+	pillar, err = encryptPillarSecrets(pillar, basePath)
+	if err != nil {
+		return "Failed to encrypt the secrets of pillar " + file, err
+	}
+	content, err := renderPillar(pillar)
+	if err != nil {
+		return "Failed to render pillar " + file, err
+	}
+	err = archivePillarVersion(basePath, pillar.Path, content)
+	if err != nil {
+		return "Failed to archive the previous version of " + file, err
+	}
+	err = WriteFile(file, content, 0644)
 	if err != nil {
 		return "Failed to write to " + file, err
 	}
@@ -426,24 +558,36 @@ func SaltPillarRequestHandler(w http.ResponseWriter, req *http.Request) {
 		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
 		return
 	}
+	if len(saltPillar.TargetPillars) > 0 {
+		log.Printf("[SaltPillarRequestHandler] [ERROR] target pillars are only accepted by the distribute request")
+		model.Response{Status: "target pillars are only accepted by the distribute request"}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(saltPillar.Target) > 0 {
+		if err := checkLocalTarget(saltPillar.Target); err != nil {
+			log.Printf("[SaltPillarRequestHandler] [ERROR] pillar is bound to another node: %s", err.Error())
+			model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+			return
+		}
+	}
 
-	if !strings.HasSuffix(saltPillar.Path, ".sls") {
-		log.Printf("[SaltPillarRequestHandler] [ERROR] path is not ending with '.sls' suffix %s", saltPillar.Path)
-		model.Response{Status: "path is not ending with '.sls' suffix"}.WriteBadRequestHttp(w)
+	if err := validatePillarPath(saltPillar.Path); err != nil {
+		log.Printf("[SaltPillarRequestHandler] [ERROR] invalid path %s: %s", saltPillar.Path, err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
 		return
 	}
-	if !strings.HasPrefix(saltPillar.Path, "/") {
-		log.Printf("[SaltPillarRequestHandler] [ERROR] path is not starting with '/' %s", saltPillar.Path)
-		model.Response{Status: "path is not starting with '/'"}.WriteBadRequestHttp(w)
+	if !containsString(pillarRenderers, saltPillar.Renderer) {
+		log.Printf("[SaltPillarRequestHandler] [ERROR] unsupported renderer %s", saltPillar.Renderer)
+		model.Response{Status: "unsupported renderer: " + saltPillar.Renderer}.WriteBadRequestHttp(w)
 		return
 	}
-	if strings.Contains(saltPillar.Path, "..") {
-		log.Printf("[SaltPillarRequestHandler] [ERROR] path cannot contain '..' characters %s", saltPillar.Path)
-		model.Response{Status: "path cannot contain '..' characters"}.WriteBadRequestHttp(w)
+	if err := validatePillarSecrets(saltPillar); err != nil {
+		log.Printf("[SaltPillarRequestHandler] [ERROR] invalid secrets: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
 		return
 	}
 
-	outStr, err := saltPillar.WritePillar()
+	outStr, err := writePillarImpl(saltPillar, req.Header.Get("salt-master-base-dir"))
 	if err != nil {
 		log.Printf("[SaltPillarRequestHandler] [ERROR] failed to execute salt pillar save config: %s", err.Error())
 		model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}.WriteHttp(w)
@@ -487,6 +631,10 @@ func SaltPillarDistributeRequestHandler(w http.ResponseWriter, req *http.Request
 		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
 		return
 	}
+	if len(saltPillar.TargetPillars) > 0 {
+		distributeTargetPillars(w, req, saltPillar)
+		return
+	}
 
 	user, pass := GetAuthUserPass(req)
 	signedRequestBody := GetSignedRequestBody(req)
@@ -527,16 +675,6 @@ func isGrainsConfigNeeded(grainConfigLocation string) bool {
 	return true
 }
 
-func shouldAppendPrewarmedRoles(prewarmRoleLocation string) bool {
-	// Essentially a file exists check.
-	log.Println("[shouldAppendPrewarmedRoles] check whether prewarm roles exist, file location: " + prewarmRoleLocation)
-	_, err := os.Stat(prewarmRoleLocation)
-	if os.IsNotExist(err) {
-		return false
-	}
-	return true
-}
-
 func isSaltMasterIpDiffers(servers []string) bool {
 	log.Println("[isSaltMasterIpDiffers] check whether salt-minion requires restart")
 	masterConfFile := "/etc/salt/minion.d/master.conf"
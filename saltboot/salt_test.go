@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http/httptest"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/hortonworks/salt-bootstrap/saltboot/model"
@@ -106,15 +107,21 @@ func TestSaltMinionRunRequestHandler(t *testing.T) {
 		if err != nil {
 			t.Errorf("couldn't unmarshall grain yaml: %s", err)
 		}
+
+		var resp struct {
+			Payload MinionRunResult `json:"payload"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if !resp.Payload.Roles.GrainsWritten || !reflect.DeepEqual(resp.Payload.Roles.Effective, []string{"role1", "role2"}) {
+			t.Errorf("role assignment not match: %v", resp.Payload.Roles)
+		}
 		os.RemoveAll(tempDirName)
 	}()
 
 	checkExecutedCommands([]string{
 		"hostname -d",
 		"hostname testhostname.example.com",
-		"grep SUSE /etc/issue",
-		"grep sles12 /etc/issue",
-		"ps aux",
+		"/bin/systemctl show salt-minion --property=LoadState,ActiveState,SubState,UnitFileState,MainPID,ActiveEnterTimestamp,ExecMainStatus",
 		"/bin/systemctl start salt-minion",
 		"/bin/systemctl enable salt-minion",
 	}, t)
@@ -166,18 +173,12 @@ func TestSaltServerRunRequestHandler(t *testing.T) {
 		"hostname -s",
 		"hostname -d",
 		"hostname ",
-		"grep SUSE /etc/issue",
-		"grep sles12 /etc/issue",
 		"grep saltuser /etc/passwd",
-		"grep Ubuntu /etc/issue",
-		"grep Debian /etc/issue",
-		"grep SUSE /etc/issue",
-		"grep sles12 /etc/issue",
 		"^adduser --no-create-home -G wheel -s /sbin/nologin --password \\$6\\$([a-zA-Z\\$0-9/.]+) saltuser",
-		"ps aux",
+		"/bin/systemctl show salt-master --property=LoadState,ActiveState,SubState,UnitFileState,MainPID,ActiveEnterTimestamp,ExecMainStatus",
 		"/bin/systemctl start salt-master",
 		"/bin/systemctl enable salt-master",
-		"ps aux",
+		"/bin/systemctl show salt-api --property=LoadState,ActiveState,SubState,UnitFileState,MainPID,ActiveEnterTimestamp,ExecMainStatus",
 		"/bin/systemctl start salt-api",
 		"/bin/systemctl enable salt-api",
 	}, t)
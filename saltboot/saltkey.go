@@ -0,0 +1,254 @@
+package saltboot
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+const (
+	MASTER_PKI_DIR     = "/etc/salt/pki/master"
+	MASTER_PUBLIC_KEY  = "master.pub"
+	MASTER_PRIVATE_KEY = "master.pem"
+	DEFAULT_HASH_TYPE  = "sha256"
+
+	MINIONS_PENDING_DIR  = "minions_pre"
+	MINIONS_ACCEPTED_DIR = "minions"
+	MINIONS_REJECTED_DIR = "minions_rejected"
+
+	KEY_ACCEPTED         = "accepted"
+	KEY_ALREADY_ACCEPTED = "already-accepted"
+	KEY_REJECTED         = "rejected"
+	KEY_NOT_FOUND        = "not-found"
+	KEY_FAILED           = "failed"
+)
+
+// hash types of salt's hash_type option that can be used for fingerprints
+var fingerprintHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+type MinionKeyFingerprint struct {
+	Id          string `json:"id"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type KeyAcceptRequest struct {
+	Minions []MinionKeyFingerprint `json:"minions"`
+}
+
+type KeyResult struct {
+	Id          string `json:"id"`
+	Outcome     string `json:"outcome"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	ErrorText   string `json:"errorText,omitempty"`
+}
+
+func (r KeyResult) String() string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
+
+type MasterFingerprint struct {
+	HashType    string `json:"hashType"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+func pemFingerprint(content []byte) (string, error) {
+	return pemFingerprintWithHash(content, DEFAULT_HASH_TYPE)
+}
+
+// pemFingerprintWithHash computes the fingerprint of a PEM encoded key the same way as salt's pem_finger:
+// the hash of the key body lines including the line endings, without the BEGIN and END lines
+func pemFingerprintWithHash(content []byte, hashType string) (string, error) {
+	newHash, ok := fingerprintHashes[hashType]
+	if !ok {
+		return "", fmt.Errorf("unsupported hash type: %s", hashType)
+	}
+	var lines [][]byte
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) < 3 || !bytes.HasPrefix(lines[0], []byte("-----BEGIN")) {
+		return "", errors.New("invalid PEM encoded key")
+	}
+	hasher := newHash()
+	hasher.Write(bytes.Join(lines[1:len(lines)-1], nil))
+	return formatFingerprint(hasher.Sum(nil)), nil
+}
+
+func formatFingerprint(sum []byte) string {
+	var parts []string
+	for _, b := range sum {
+		parts = append(parts, fmt.Sprintf("%02x", b))
+	}
+	return strings.Join(parts, ":")
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.TrimSpace(fingerprint))
+}
+
+func keyFingerprintOf(file string) (string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return pemFingerprint(content)
+}
+
+func acceptMinionKey(pkiDir string, minion MinionKeyFingerprint) KeyResult {
+	result := KeyResult{Id: minion.Id}
+	if !minionIdPattern.MatchString(minion.Id) {
+		result.Outcome = KEY_FAILED
+		result.ErrorText = "invalid minion id"
+		return result
+	}
+	expected := normalizeFingerprint(minion.Fingerprint)
+	pendingKey := filepath.Join(pkiDir, MINIONS_PENDING_DIR, minion.Id)
+	acceptedKey := filepath.Join(pkiDir, MINIONS_ACCEPTED_DIR, minion.Id)
+
+	if _, err := os.Stat(pendingKey); os.IsNotExist(err) {
+		fingerprint, err := keyFingerprintOf(acceptedKey)
+		if os.IsNotExist(err) {
+			result.Outcome = KEY_NOT_FOUND
+			result.ErrorText = "no pending key found"
+			return result
+		}
+		result.Fingerprint = fingerprint
+		if err != nil {
+			result.Outcome = KEY_FAILED
+			result.ErrorText = err.Error()
+		} else if fingerprint != expected {
+			result.Outcome = KEY_FAILED
+			result.ErrorText = "accepted key does not match the expected fingerprint"
+		} else {
+			result.Outcome = KEY_ALREADY_ACCEPTED
+		}
+		return result
+	}
+
+	fingerprint, err := keyFingerprintOf(pendingKey)
+	result.Fingerprint = fingerprint
+	if err != nil {
+		result.Outcome = KEY_FAILED
+		result.ErrorText = err.Error()
+		return result
+	}
+	if fingerprint != expected {
+		log.Printf("[acceptMinionKey] fingerprint of %s does not match, expected: %s, actual: %s", minion.Id, expected, fingerprint)
+		if err := moveKey(pendingKey, filepath.Join(pkiDir, MINIONS_REJECTED_DIR, minion.Id)); err != nil {
+			result.Outcome = KEY_FAILED
+			result.ErrorText = err.Error()
+			return result
+		}
+		result.Outcome = KEY_REJECTED
+		result.ErrorText = "pending key does not match the expected fingerprint"
+		return result
+	}
+	if _, err := os.Stat(acceptedKey); err == nil {
+		result.Outcome = KEY_FAILED
+		result.ErrorText = "a different key is already accepted for the minion"
+		return result
+	}
+	if err := moveKey(pendingKey, acceptedKey); err != nil {
+		result.Outcome = KEY_FAILED
+		result.ErrorText = err.Error()
+		return result
+	}
+	result.Outcome = KEY_ACCEPTED
+	return result
+}
+
+func moveKey(from string, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0700); err != nil {
+		return err
+	}
+	log.Printf("[moveKey] move key %s to %s", from, to)
+	return os.Rename(from, to)
+}
+
+func acceptMinionKeys(pkiDir string, request KeyAcceptRequest) (results []KeyResult) {
+	for _, minion := range request.Minions {
+		result := acceptMinionKey(pkiDir, minion)
+		log.Printf("[acceptMinionKeys] key of %s: %s", minion.Id, result.String())
+		results = append(results, result)
+	}
+	return results
+}
+
+func SaltMasterKeyAcceptHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[SaltMasterKeyAcceptHandler] execute accept minion keys request")
+
+	decoder := json.NewDecoder(req.Body)
+	var acceptRequest KeyAcceptRequest
+	if err := decoder.Decode(&acceptRequest); err != nil {
+		log.Printf("[SaltMasterKeyAcceptHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(acceptRequest.Minions) == 0 {
+		log.Printf("[SaltMasterKeyAcceptHandler] [ERROR] no minions were specified in the request")
+		model.Response{Status: "no minions were specified in the request"}.WriteBadRequestHttp(w)
+		return
+	}
+
+	pkiDir := req.Header.Get("salt-master-base-dir") + MASTER_PKI_DIR
+	results := acceptMinionKeys(pkiDir, acceptRequest)
+
+	var accepted int
+	for _, result := range results {
+		if result.Outcome == KEY_ACCEPTED || result.Outcome == KEY_ALREADY_ACCEPTED {
+			accepted++
+		}
+	}
+	status := fmt.Sprintf("%d of %d minion keys are accepted", accepted, len(results))
+	log.Printf("[SaltMasterKeyAcceptHandler] %s", status)
+	model.Response{Status: status, Payload: results}.WriteHttp(w)
+}
+
+func SaltMasterFingerprintHandler(w http.ResponseWriter, req *http.Request) {
+	hashType := req.URL.Query().Get("hash_type")
+	if len(hashType) == 0 {
+		hashType = DEFAULT_HASH_TYPE
+	}
+	log.Printf("[SaltMasterFingerprintHandler] compute the fingerprint of the master key with hash type: %s", hashType)
+	if _, ok := fingerprintHashes[hashType]; !ok {
+		model.Response{Status: "unsupported hash type: " + hashType}.WriteBadRequestHttp(w)
+		return
+	}
+
+	masterKey := filepath.Join(req.Header.Get("salt-master-base-dir")+MASTER_PKI_DIR, MASTER_PUBLIC_KEY)
+	content, err := os.ReadFile(masterKey)
+	if err != nil {
+		log.Printf("[SaltMasterFingerprintHandler] [ERROR] unable to read the master key: %s", err.Error())
+		model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}.WriteHttp(w)
+		return
+	}
+	fingerprint, err := pemFingerprintWithHash(content, hashType)
+	if err != nil {
+		log.Printf("[SaltMasterFingerprintHandler] [ERROR] unable to compute the fingerprint: %s", err.Error())
+		model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}.WriteHttp(w)
+		return
+	}
+	log.Printf("[SaltMasterFingerprintHandler] fingerprint of the master key: %s", fingerprint)
+	model.Response{Status: fingerprint, Payload: MasterFingerprint{HashType: hashType, Fingerprint: fingerprint}}.WriteHttp(w)
+}
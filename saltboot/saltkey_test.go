@@ -0,0 +1,157 @@
+package saltboot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+func generatePublicKeyPem(t *testing.T) []byte {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+	publicKeyDer, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDer})
+}
+
+func writeMinionKey(t *testing.T, pkiDir string, dir string, id string) string {
+	key := generatePublicKeyPem(t)
+	os.MkdirAll(filepath.Join(pkiDir, dir), 0700)
+	os.WriteFile(filepath.Join(pkiDir, dir, id), key, 0644)
+	fingerprint, _ := pemFingerprint(key)
+	return fingerprint
+}
+
+func TestPemFingerprintIgnoresEmptyLines(t *testing.T) {
+	key := generatePublicKeyPem(t)
+	expected, _ := pemFingerprint(key)
+
+	fingerprint, err := pemFingerprint(append(append([]byte("\n"), key...), '\n'))
+
+	if err != nil || fingerprint != expected {
+		t.Errorf("fingerprint not match %s == %s (%v)", expected, fingerprint, err)
+	}
+	if _, err := pemFingerprint([]byte("not a key")); err == nil {
+		t.Error("error is expected for an invalid key")
+	}
+}
+
+func TestAcceptMinionKeys(t *testing.T) {
+	pkiDir, _ := os.MkdirTemp("", "saltkeytest")
+	defer os.RemoveAll(pkiDir)
+
+	matching := writeMinionKey(t, pkiDir, MINIONS_PENDING_DIR, "matching")
+	writeMinionKey(t, pkiDir, MINIONS_PENDING_DIR, "mismatching")
+	accepted := writeMinionKey(t, pkiDir, MINIONS_ACCEPTED_DIR, "accepted")
+
+	results := acceptMinionKeys(pkiDir, KeyAcceptRequest{Minions: []MinionKeyFingerprint{
+		{Id: "matching", Fingerprint: matching},
+		{Id: "mismatching", Fingerprint: matching},
+		{Id: "accepted", Fingerprint: accepted},
+		{Id: "missing", Fingerprint: matching},
+		{Id: "../escape", Fingerprint: matching},
+	}})
+
+	expected := []string{KEY_ACCEPTED, KEY_REJECTED, KEY_ALREADY_ACCEPTED, KEY_NOT_FOUND, KEY_FAILED}
+	for i, outcome := range expected {
+		if results[i].Outcome != outcome {
+			t.Errorf("outcome not match %s == %s", outcome, results[i].String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(pkiDir, MINIONS_ACCEPTED_DIR, "matching")); err != nil {
+		t.Errorf("matching key is expected to be accepted: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(pkiDir, MINIONS_REJECTED_DIR, "mismatching")); err != nil {
+		t.Errorf("mismatching key is expected to be rejected: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(pkiDir, MINIONS_PENDING_DIR, "mismatching")); !os.IsNotExist(err) {
+		t.Error("mismatching key is not expected to remain pending")
+	}
+}
+
+func TestAcceptMinionKeyAlreadyAcceptedWithDifferentKey(t *testing.T) {
+	pkiDir, _ := os.MkdirTemp("", "saltkeytest")
+	defer os.RemoveAll(pkiDir)
+
+	pending := writeMinionKey(t, pkiDir, MINIONS_PENDING_DIR, "minion")
+	writeMinionKey(t, pkiDir, MINIONS_ACCEPTED_DIR, "minion")
+
+	result := acceptMinionKey(pkiDir, MinionKeyFingerprint{Id: "minion", Fingerprint: pending})
+
+	if result.Outcome != KEY_FAILED {
+		t.Errorf("outcome not match %s == %s", KEY_FAILED, result.String())
+	}
+	if _, err := os.Stat(filepath.Join(pkiDir, MINIONS_PENDING_DIR, "minion")); err != nil {
+		t.Error("pending key is expected to be kept")
+	}
+}
+
+func TestSaltMasterKeyAcceptHandler(t *testing.T) {
+	baseDir, _ := os.MkdirTemp("", "saltkeytest")
+	defer os.RemoveAll(baseDir)
+	fingerprint := writeMinionKey(t, baseDir+MASTER_PKI_DIR, MINIONS_PENDING_DIR, "minion")
+
+	body, _ := json.Marshal(KeyAcceptRequest{Minions: []MinionKeyFingerprint{{Id: "minion", Fingerprint: fingerprint}}})
+	req := httptest.NewRequest("POST", SaltServerKeyAcceptEP, bytes.NewReader(body))
+	req.Header.Set("salt-master-base-dir", baseDir)
+	w := httptest.NewRecorder()
+
+	SaltMasterKeyAcceptHandler(w, req)
+
+	var resp struct {
+		model.Response
+		Payload []KeyResult `json:"payload"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != 200 || len(resp.Payload) != 1 || resp.Payload[0].Outcome != KEY_ACCEPTED {
+		t.Errorf("minion key is expected to be accepted: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSaltMasterFingerprintHandler(t *testing.T) {
+	baseDir, _ := os.MkdirTemp("", "saltkeytest")
+	defer os.RemoveAll(baseDir)
+	key := generatePublicKeyPem(t)
+	os.MkdirAll(baseDir+MASTER_PKI_DIR, 0700)
+	os.WriteFile(filepath.Join(baseDir+MASTER_PKI_DIR, MASTER_PUBLIC_KEY), key, 0644)
+
+	for _, hashType := range []string{"", "md5", "sha512"} {
+		req := httptest.NewRequest("POST", SaltServerFingerprintEP+"?hash_type="+hashType, nil)
+		req.Header.Set("salt-master-base-dir", baseDir)
+		w := httptest.NewRecorder()
+
+		SaltMasterFingerprintHandler(w, req)
+
+		expectedHashType := hashType
+		if len(expectedHashType) == 0 {
+			expectedHashType = DEFAULT_HASH_TYPE
+		}
+		expected, _ := pemFingerprintWithHash(key, expectedHashType)
+		var resp struct {
+			Status  string            `json:"status"`
+			Payload MasterFingerprint `json:"payload"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != 200 || resp.Status != expected || resp.Payload.HashType != expectedHashType {
+			t.Errorf("fingerprint not match %s == %s (%s)", expected, resp.Status, resp.Payload.HashType)
+		}
+	}
+
+	req := httptest.NewRequest("POST", SaltServerFingerprintEP+"?hash_type=crc32", nil)
+	req.Header.Set("salt-master-base-dir", baseDir)
+	w := httptest.NewRecorder()
+	SaltMasterFingerprintHandler(w, req)
+	if w.Code != 400 {
+		t.Errorf("status code not match %d == %d", 400, w.Code)
+	}
+}
@@ -25,32 +25,21 @@ func (s *Servers) WriteToFile() (outStr string, err error) {
 
 	file := s.Path
 
-	if _, err := os.Stat(file); os.IsNotExist(err) {
-		if _, err := os.Create(file); err != nil {
-			return "Failed to create " + file, err
-		}
-	}
-
-	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0600)
-	if err != nil {
-		return "Failed to open " + file, err
+	current, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return "Failed to read " + file, err
 	}
 
 	var serverList string
 	for _, server := range s.Servers {
-		serverList += fmt.Sprintf("\n%s %s", server.Address, server.Name)
+		serverList += fmt.Sprintf("\n%s %s %s", server.Address, server.Name, HOSTS_ENTRY_MARKER)
 	}
 	log.Printf("[Servers.writeToFile] constructed server list: %s", serverList)
 
-	if _, err = f.WriteString(serverList); err != nil {
+	if err = WriteFile(file, append(current, serverList...), 0644); err != nil {
 		return "Failed to write to " + file, err
 	}
 
-	if err := f.Close(); err != nil {
-		log.Printf("[Servers.writeToFile] unable to close file (%s): %s", file, err.Error())
-		return "Failed to close " + file, err
-	}
-
 	return "Server list successfully appended to " + file, err
 }
 
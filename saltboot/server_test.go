@@ -23,7 +23,7 @@ func TestWriteToFile(t *testing.T) {
 		t.Errorf("error occurred during write %s", err)
 	}
 
-	expected := "\naddress name\naddress2 name2"
+	expected := "\naddress name # saltboot\naddress2 name2 # saltboot"
 	content, _ := os.ReadFile(tempDirName + "servers")
 	if string(content) != expected {
 		t.Errorf("servers content not match %s == %s", expected, string(content))
@@ -1,19 +1,52 @@
 package saltboot
 
 import (
-	"net/http"
-
+	"encoding/json"
 	"log"
+	"net/http"
+	"regexp"
 	"strings"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/hortonworks/salt-bootstrap/saltboot/model"
 )
 
+const (
+	SYSTEMD_TIMESTAMP_LAYOUT = "Mon 2006-01-02 15:04:05 MST"
+	PID_FILE_LOCATION        = "/var/run/"
+)
+
+var systemdStatusProperties = []string{"LoadState", "ActiveState", "SubState", "UnitFileState", "MainPID", "ActiveEnterTimestamp", "ExecMainStatus"}
+
+var serviceNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9@._-]*$`)
+
+type ServiceStatus struct {
+	Name          string `json:"name"`
+	InitSystem    string `json:"initSystem"`
+	Active        bool   `json:"active"`
+	State         string `json:"state,omitempty"`
+	SubState      string `json:"subState,omitempty"`
+	Enabled       bool   `json:"enabled"`
+	Pid           int    `json:"pid,omitempty"`
+	UptimeSeconds int64  `json:"uptimeSeconds,omitempty"`
+	LastExitCode  *int   `json:"lastExitCode,omitempty"`
+}
+
+func (s ServiceStatus) String() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+type ServiceStatusRequest struct {
+	Targets []string `json:"targets"`
+}
+
 func RestartService(service string) (model.Response, error) {
-	alreadyRunning, psOutput := IsServiceRunning(service)
+	alreadyRunning, status := IsServiceRunning(service)
 
 	if alreadyRunning {
-		log.Printf("[RestartService] %s is already running %s, restart", service, psOutput)
+		log.Printf("[RestartService] %s is already running %s, restart", service, status)
 		return SetServiceState(service, RESTART_ACTION)
 	} else {
 		log.Printf("[RestartService] %s is not running (no need to stop first) and will be started", service)
@@ -22,10 +55,10 @@ func RestartService(service string) (model.Response, error) {
 }
 
 func LaunchService(service string) (model.Response, error) {
-	alreadyRunning, psOutput := IsServiceRunning(service)
+	alreadyRunning, status := IsServiceRunning(service)
 
 	if alreadyRunning {
-		log.Printf("[LaunchService] %s is already running %s", service, psOutput)
+		log.Printf("[LaunchService] %s is already running %s", service, status)
 		return model.Response{StatusCode: http.StatusOK, Status: service + " is already running"}, nil
 	} else {
 		log.Printf("[LaunchService] %s is not running and will be started", service)
@@ -34,32 +67,115 @@ func LaunchService(service string) (model.Response, error) {
 	return SetServiceState(service, START_ACTION)
 }
 
+// LaunchServiceAndWait launches the service and waits for its readiness probes under the base dir
+func LaunchServiceAndWait(service string, baseDir string, timeout time.Duration) (model.Response, error) {
+	resp, err := LaunchService(service)
+	return waitForServiceReadiness(service, readinessProbes(service, baseDir), timeout, resp, err)
+}
+
+// RestartServiceAndWait restarts the service and waits for its readiness probes under the base dir
+func RestartServiceAndWait(service string, baseDir string, timeout time.Duration) (model.Response, error) {
+	resp, err := RestartService(service)
+	return waitForServiceReadiness(service, readinessProbes(service, baseDir), timeout, resp, err)
+}
+
+func waitForServiceReadiness(service string, probes []ReadinessProbe, timeout time.Duration, resp model.Response, err error) (model.Response, error) {
+	if err != nil {
+		return resp, err
+	}
+	readiness := waitForReady(service, probes, timeout)
+	resp.Payload = readiness
+	if !readiness.Ready {
+		resp.StatusCode = http.StatusInternalServerError
+		resp.ErrorText = readiness.Error()
+		return resp, readiness
+	}
+	return resp, nil
+}
+
 func StopService(service string) (model.Response, error) {
 	return SetServiceState(service, STOP_ACTION)
 }
 
 func IsServiceRunning(service string) (bool, string) {
 	log.Printf("[IsServiceRunning] check if service: %s is running", service)
-	psOutput, _ := ExecCmd("ps", "aux")
-	return strings.Contains(psOutput, service), psOutput
+	status, err := GetServiceStatus(service)
+	if err != nil {
+		log.Printf("[IsServiceRunning] [ERROR] unable to determine the status of %s: %s", service, err.Error())
+		return false, err.Error()
+	}
+	return status.Active, status.String()
+}
+
+func GetServiceStatus(service string) (ServiceStatus, error) {
+	return GetInitSystem().Status(service)
 }
 
 func SetServiceState(service string, serviceAction string) (resp model.Response, err error) {
 	initSystem := GetInitSystem()
-	action := initSystem.ActionCommand(service, serviceAction)
-	if _, err := ExecCmd(action[0], action[1:]...); err != nil {
+	if _, err := initSystem.Action(service, serviceAction); err != nil {
 		return model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}, err
 	}
-	var state []string
-	if serviceAction == STOP_ACTION {
-		state = initSystem.StateCommand(service, false)
-	} else {
-		state = initSystem.StateCommand(service, true)
-	}
-	result, err := ExecCmd(state[0], state[1:]...)
+	result, err := initSystem.SetEnabled(service, serviceAction != STOP_ACTION)
 	if err != nil {
 		return model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError}, err
 	}
 	resp = model.Response{Status: result, StatusCode: http.StatusOK}
 	return resp, nil
 }
+
+func ServiceStatusHandler(w http.ResponseWriter, req *http.Request) {
+	service := mux.Vars(req)["name"]
+	log.Printf("[ServiceStatusHandler] query the status of service: %s", service)
+	if !serviceNamePattern.MatchString(service) {
+		log.Printf("[ServiceStatusHandler] [ERROR] invalid service name: %s", service)
+		model.Response{Status: "invalid service name: " + service}.WriteBadRequestHttp(w)
+		return
+	}
+
+	status, err := GetServiceStatus(service)
+	if err != nil {
+		log.Printf("[ServiceStatusHandler] [ERROR] unable to determine the status of %s: %s", service, err.Error())
+		model.Response{ErrorText: err.Error(), StatusCode: http.StatusInternalServerError, Payload: status}.WriteHttp(w)
+		return
+	}
+	log.Printf("[ServiceStatusHandler] status of %s: %s", service, status.String())
+	model.Response{Status: status.State, Payload: status}.WriteHttp(w)
+}
+
+func ServiceStatusDistributeHandler(w http.ResponseWriter, req *http.Request) {
+	service := mux.Vars(req)["name"]
+	log.Printf("[ServiceStatusDistributeHandler] distribute status request of service: %s", service)
+	if !serviceNamePattern.MatchString(service) {
+		log.Printf("[ServiceStatusDistributeHandler] [ERROR] invalid service name: %s", service)
+		model.Response{Status: "invalid service name: " + service}.WriteBadRequestHttp(w)
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var statusRequest ServiceStatusRequest
+	if err := decoder.Decode(&statusRequest); err != nil {
+		log.Printf("[ServiceStatusDistributeHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(statusRequest.Targets) == 0 {
+		log.Printf("[ServiceStatusDistributeHandler] [ERROR] no targets were specified in the request")
+		model.Response{Status: "no targets were specified in the request"}.WriteBadRequestHttp(w)
+		return
+	}
+
+	user, pass := GetAuthUserPass(req)
+	signedRequestBody := GetSignedRequestBody(req)
+
+	result := distributeToTargetsImpl(DistributeRequest, statusRequest.Targets, serviceStatusEndpoint(service), user, pass, signedRequestBody)
+	cResp := model.Responses{Responses: result}
+	log.Printf("[ServiceStatusDistributeHandler] distribute service status request executed: %s", cResp.String())
+	if err := json.NewEncoder(w).Encode(cResp); err != nil {
+		log.Printf("[ServiceStatusDistributeHandler] [ERROR] couldn't encode json: %s", err.Error())
+	}
+}
+
+func serviceStatusEndpoint(service string) string {
+	return strings.Replace(ServiceStatusEP, "{name}", service, 1)
+}
@@ -0,0 +1,180 @@
+package saltboot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+func withCommandOutput(outputs map[string]string, failing map[string]bool, test func()) {
+	mockedExecutor := commandExecutor
+	commandExecutor = func(executable string, args ...string) ([]byte, error) {
+		command := executable + " " + strings.Join(args, " ")
+		if failing[command] {
+			return []byte{}, errors.New("exit status 3")
+		}
+		return []byte(outputs[command]), nil
+	}
+	defer func() {
+		commandExecutor = mockedExecutor
+	}()
+	test()
+}
+
+func systemdShowCommand(service string) string {
+	return "/bin/systemctl show " + service + " --property=" + strings.Join(systemdStatusProperties, ",")
+}
+
+func TestSystemdServiceStatusActive(t *testing.T) {
+	since := time.Now().Add(-2 * time.Hour).UTC().Format(SYSTEMD_TIMESTAMP_LAYOUT)
+	outputs := map[string]string{
+		systemdShowCommand("salt-minion"): "LoadState=loaded\nActiveState=active\nSubState=running\nUnitFileState=enabled\nMainPID=1234\nActiveEnterTimestamp=" + since + "\nExecMainStatus=0",
+	}
+
+	withCommandOutput(outputs, nil, func() {
+		status, err := GetServiceStatus("salt-minion")
+
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !status.Active || !status.Enabled || status.Pid != 1234 || status.SubState != "running" {
+			t.Errorf("status not match: %s", status.String())
+		}
+		if status.UptimeSeconds < 7100 || status.UptimeSeconds > 7300 {
+			t.Errorf("uptime is not around 2 hours: %d", status.UptimeSeconds)
+		}
+		if status.LastExitCode == nil || *status.LastExitCode != 0 {
+			t.Errorf("last exit code not match: %s", status.String())
+		}
+	})
+}
+
+func TestSystemdServiceStatusFailed(t *testing.T) {
+	outputs := map[string]string{
+		systemdShowCommand("salt-minion"): "LoadState=loaded\nActiveState=failed\nSubState=failed\nUnitFileState=disabled\nMainPID=0\nActiveEnterTimestamp=\nExecMainStatus=1",
+	}
+
+	withCommandOutput(outputs, nil, func() {
+		status, _ := GetServiceStatus("salt-minion")
+
+		if status.Active || status.Enabled || status.Pid != 0 || status.State != "failed" {
+			t.Errorf("status not match: %s", status.String())
+		}
+		if status.LastExitCode == nil || *status.LastExitCode != 1 {
+			t.Errorf("last exit code not match: %s", status.String())
+		}
+	})
+}
+
+func TestSystemdServiceStatusNotFound(t *testing.T) {
+	outputs := map[string]string{
+		systemdShowCommand("unknown"): "LoadState=not-found\nActiveState=inactive",
+	}
+
+	withCommandOutput(outputs, nil, func() {
+		if _, err := GetServiceStatus("unknown"); err == nil {
+			t.Error("error is expected for a missing service")
+		}
+	})
+}
+
+func TestProcessListIsNotUsedForServiceStatus(t *testing.T) {
+	outputs := map[string]string{
+		"ps aux":                          "root 1 grep salt-minion",
+		systemdShowCommand("salt-minion"): "LoadState=loaded\nActiveState=inactive",
+	}
+
+	withCommandOutput(outputs, nil, func() {
+		if running, _ := IsServiceRunning("salt-minion"); running {
+			t.Error("salt-minion is expected to be reported as stopped")
+		}
+	})
+}
+
+func TestSysvServiceStatus(t *testing.T) {
+	stat = statOnly("/sbin/service", "/sbin/chkconfig")
+	defer func() {
+		stat = initOk
+	}()
+	outputs := map[string]string{
+		"/sbin/chkconfig --list salt-minion": "salt-minion    0:off 1:off 2:on 3:on 4:on 5:on 6:off",
+	}
+
+	withCommandOutput(outputs, nil, func() {
+		status, _ := GetServiceStatus("salt-minion")
+
+		if !status.Active || !status.Enabled || status.InitSystem != SYS_V_INIT.Name() {
+			t.Errorf("status not match: %s", status.String())
+		}
+	})
+
+	withCommandOutput(outputs, map[string]bool{"/sbin/service salt-minion status": true}, func() {
+		status, _ := GetServiceStatus("salt-minion")
+
+		if status.Active || status.State != "inactive" {
+			t.Errorf("status not match: %s", status.String())
+		}
+	})
+}
+
+func TestServiceStatusHandler(t *testing.T) {
+	outputs := map[string]string{
+		systemdShowCommand("salt-master"): "LoadState=loaded\nActiveState=active\nSubState=running\nUnitFileState=enabled\nMainPID=42",
+	}
+
+	withCommandOutput(outputs, nil, func() {
+		req := httptest.NewRequest("POST", "/saltboot/service/salt-master/status", nil)
+		req = mux.SetURLVars(req, map[string]string{"name": "salt-master"})
+		w := httptest.NewRecorder()
+
+		ServiceStatusHandler(w, req)
+
+		var resp struct {
+			Status  string        `json:"status"`
+			Payload ServiceStatus `json:"payload"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != 200 || resp.Status != "active" || resp.Payload.Pid != 42 {
+			t.Errorf("response not match: %d %v", w.Code, resp)
+		}
+	})
+}
+
+func TestServiceStatusHandlerInvalidName(t *testing.T) {
+	req := httptest.NewRequest("POST", "/saltboot/service/-all/status", nil)
+	req = mux.SetURLVars(req, map[string]string{"name": "--all"})
+	w := httptest.NewRecorder()
+
+	ServiceStatusHandler(w, req)
+
+	if w.Code != 400 {
+		t.Errorf("Wrong status code %d == %d", 400, w.Code)
+	}
+}
+
+func TestDistributeServiceStatusToTargets(t *testing.T) {
+	distributeRequest := func(clients []string, endpoint string, user string, pass string, requestBody RequestBody) <-chan model.Response {
+		c := make(chan model.Response, len(clients))
+		for _, client := range clients {
+			if endpoint != "/saltboot/service/salt-minion/status" {
+				t.Errorf("endpoint not match %s", endpoint)
+			}
+			c <- model.Response{StatusCode: 200, Address: client}
+		}
+		close(c)
+		return c
+	}
+	request := ServiceStatusRequest{Targets: []string{"address1", "address2"}}
+
+	resp := distributeToTargetsImpl(distributeRequest, request.Targets, serviceStatusEndpoint("salt-minion"), "user", "pass", RequestBody{})
+
+	if len(resp) != len(request.Targets) {
+		t.Errorf("size not match %d == %d", len(request.Targets), len(resp))
+	}
+}
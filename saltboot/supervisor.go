@@ -0,0 +1,202 @@
+package saltboot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	SUPERVISOR_INIT_SYSTEM = "supervisor"
+
+	supervisorStopTimeout = 10 * time.Second
+)
+
+var supervisorRestartDelay = 5 * time.Second
+
+// Commands launched by the built-in supervisor, used in container images without an init system when
+// SALTBOOT_INIT_SYSTEM is set to supervisor
+var supervisedServiceCommands = map[string][]string{
+	"salt-minion": {"salt-minion"},
+	"salt-master": {"salt-master"},
+	"salt-api":    {"salt-api"},
+}
+
+var processSupervisor = NewProcessSupervisor()
+
+type supervisedProcess struct {
+	cmd          *exec.Cmd
+	startedAt    time.Time
+	running      bool
+	stopping     bool
+	enabled      bool
+	lastExitCode *int
+	exited       chan struct{}
+}
+
+// ProcessSupervisor runs services as child processes of salt-bootstrap and restarts the enabled ones if they exit
+type ProcessSupervisor struct {
+	lock      sync.Mutex
+	processes map[string]*supervisedProcess
+}
+
+func NewProcessSupervisor() *ProcessSupervisor {
+	return &ProcessSupervisor{processes: make(map[string]*supervisedProcess)}
+}
+
+func (s *ProcessSupervisor) Name() string {
+	return SUPERVISOR_INIT_SYSTEM
+}
+
+func (s *ProcessSupervisor) Action(service string, action string) (string, error) {
+	switch action {
+	case START_ACTION:
+		return s.start(service)
+	case STOP_ACTION:
+		return s.stop(service)
+	case RESTART_ACTION:
+		if _, err := s.stop(service); err != nil {
+			return "", err
+		}
+		return s.start(service)
+	}
+	return "", fmt.Errorf("unsupported action: %s", action)
+}
+
+func (s *ProcessSupervisor) SetEnabled(service string, enable bool) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	process, ok := s.processes[service]
+	if !ok {
+		process = &supervisedProcess{}
+		s.processes[service] = process
+	}
+	process.enabled = enable
+	if enable {
+		return service + " is enabled", nil
+	}
+	return service + " is disabled", nil
+}
+
+func (s *ProcessSupervisor) Status(service string) (ServiceStatus, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	status := ServiceStatus{Name: service, InitSystem: s.Name(), State: "inactive"}
+	if _, ok := supervisedServiceCommands[service]; !ok {
+		return status, fmt.Errorf("service %s is not supervised", service)
+	}
+	process, ok := s.processes[service]
+	if !ok {
+		return status, nil
+	}
+	status.Enabled = process.enabled
+	status.LastExitCode = process.lastExitCode
+	if process.running {
+		status.Active = true
+		status.State = "active"
+		status.Pid = process.cmd.Process.Pid
+		status.UptimeSeconds = int64(time.Since(process.startedAt).Seconds())
+	} else if process.lastExitCode != nil && *process.lastExitCode != 0 {
+		status.State = "failed"
+	}
+	return status, nil
+}
+
+func (s *ProcessSupervisor) start(service string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	command, ok := supervisedServiceCommands[service]
+	if !ok {
+		return "", fmt.Errorf("service %s is not supervised", service)
+	}
+	process, ok := s.processes[service]
+	if !ok {
+		process = &supervisedProcess{}
+		s.processes[service] = process
+	}
+	if process.running {
+		return service + " is already running", nil
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	log.Printf("[ProcessSupervisor] start %s: %s", service, cmd.String())
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	process.cmd = cmd
+	process.startedAt = time.Now()
+	process.running = true
+	process.stopping = false
+	process.exited = make(chan struct{})
+	go s.wait(service, process, cmd)
+	return fmt.Sprintf("%s started with pid %d", service, cmd.Process.Pid), nil
+}
+
+func (s *ProcessSupervisor) wait(service string, process *supervisedProcess, cmd *exec.Cmd) {
+	err := cmd.Wait()
+	exitCode := cmd.ProcessState.ExitCode()
+
+	s.lock.Lock()
+	process.running = false
+	process.lastExitCode = &exitCode
+	restart := process.enabled && !process.stopping
+	close(process.exited)
+	s.lock.Unlock()
+
+	if err != nil {
+		log.Printf("[ProcessSupervisor] %s exited: %s", service, err.Error())
+	} else {
+		log.Printf("[ProcessSupervisor] %s exited with code %d", service, exitCode)
+	}
+	if restart {
+		log.Printf("[ProcessSupervisor] %s is enabled, restart it in %s", service, supervisorRestartDelay)
+		time.AfterFunc(supervisorRestartDelay, func() {
+			s.lock.Lock()
+			stillEnabled := process.enabled && !process.stopping && !process.running
+			s.lock.Unlock()
+			if stillEnabled {
+				if _, err := s.start(service); err != nil {
+					log.Printf("[ProcessSupervisor] [ERROR] unable to restart %s: %s", service, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func (s *ProcessSupervisor) stop(service string) (string, error) {
+	s.lock.Lock()
+	process, ok := s.processes[service]
+	if !ok || !process.running {
+		if ok {
+			process.stopping = true
+		}
+		s.lock.Unlock()
+		return service + " is not running", nil
+	}
+	process.stopping = true
+	cmd := process.cmd
+	exited := process.exited
+	s.lock.Unlock()
+
+	log.Printf("[ProcessSupervisor] stop %s with pid %d", service, cmd.Process.Pid)
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return "", err
+	}
+	select {
+	case <-exited:
+	case <-time.After(supervisorStopTimeout):
+		log.Printf("[ProcessSupervisor] %s did not stop in %s, kill it", service, supervisorStopTimeout)
+		if err := cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return "", err
+		}
+		<-exited
+	}
+	return service + " stopped", nil
+}
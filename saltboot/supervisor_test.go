@@ -0,0 +1,86 @@
+package saltboot
+
+import (
+	"testing"
+	"time"
+)
+
+func withSupervisedCommand(service string, command []string, test func()) {
+	supervisedServiceCommands[service] = command
+	defer delete(supervisedServiceCommands, service)
+	test()
+}
+
+func waitForSupervisedState(s *ProcessSupervisor, service string, active bool) ServiceStatus {
+	var status ServiceStatus
+	for i := 0; i < 50; i++ {
+		status, _ = s.Status(service)
+		if status.Active == active {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return status
+}
+
+func TestSupervisorStartStop(t *testing.T) {
+	withSupervisedCommand("test-sleeper", []string{"sleep", "30"}, func() {
+		s := NewProcessSupervisor()
+
+		if _, err := s.Action("test-sleeper", START_ACTION); err != nil {
+			t.Fatalf("unable to start: %s", err)
+		}
+		s.SetEnabled("test-sleeper", true)
+
+		status, _ := s.Status("test-sleeper")
+		if !status.Active || !status.Enabled || status.Pid == 0 || status.InitSystem != SUPERVISOR_INIT_SYSTEM {
+			t.Errorf("status not match: %s", status.String())
+		}
+
+		if _, err := s.Action("test-sleeper", STOP_ACTION); err != nil {
+			t.Fatalf("unable to stop: %s", err)
+		}
+		s.SetEnabled("test-sleeper", false)
+
+		status, _ = s.Status("test-sleeper")
+		if status.Active || status.Enabled || status.LastExitCode == nil {
+			t.Errorf("status not match: %s", status.String())
+		}
+	})
+}
+
+func TestSupervisorRestartsEnabledService(t *testing.T) {
+	supervisorRestartDelay = 10 * time.Millisecond
+	defer func() { supervisorRestartDelay = 5 * time.Second }()
+
+	withSupervisedCommand("test-crasher", []string{"sh", "-c", "sleep 0.2; exit 3"}, func() {
+		s := NewProcessSupervisor()
+		s.SetEnabled("test-crasher", true)
+		s.Action("test-crasher", START_ACTION)
+		first, _ := s.Status("test-crasher")
+
+		waitForSupervisedState(s, "test-crasher", false)
+		status := waitForSupervisedState(s, "test-crasher", true)
+
+		if !status.Active || status.Pid == first.Pid {
+			t.Errorf("service is expected to be restarted: %s", status.String())
+		}
+		if status.LastExitCode == nil || *status.LastExitCode != 3 {
+			t.Errorf("last exit code not match: %s", status.String())
+		}
+
+		s.SetEnabled("test-crasher", false)
+		s.Action("test-crasher", STOP_ACTION)
+	})
+}
+
+func TestSupervisorUnknownService(t *testing.T) {
+	s := NewProcessSupervisor()
+
+	if _, err := s.Action("unknown", START_ACTION); err == nil {
+		t.Error("error is expected for an unsupervised service")
+	}
+	if _, err := s.Status("unknown"); err == nil {
+		t.Error("error is expected for an unsupervised service")
+	}
+}
@@ -11,7 +11,6 @@ import (
 const (
 	dirName     = "test"
 	fileName    = "test.txt"
-	zipName     = "test.zip"
 	testContent = "test content"
 )
 
@@ -35,10 +34,7 @@ func executeTest(t *testing.T, file string) {
 		zipEntry, _ := zipWriter.Create(file)
 		zipEntry.Write(testBytes)
 		zipWriter.Close()
-		zipFileName := filepath.Join(tempDirName, zipName)
-		WriteFile(zipFileName, buf.Bytes(), 0600)
-
-		err := Unzip(zipFileName, tempDirName)
+		err := extractArchive(ARCHIVE_ZIP, bytes.NewReader(buf.Bytes()), int64(buf.Len()), tempDirName, nil)
 		if err != nil {
 			t.Errorf("Unable to decompress archive '%s'", err)
 			return
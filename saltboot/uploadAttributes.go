@@ -0,0 +1,257 @@
+package saltboot
+
+import (
+	"fmt"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"syscall"
+)
+
+var selinuxContextPattern = regexp.MustCompile(`^[A-Za-z0-9_.]+:[A-Za-z0-9_.]+:[A-Za-z0-9_.]+(:[A-Za-z0-9_.,:-]+)?$`)
+
+// FileUploadOptions are the form fields of an upload request besides the file itself
+type FileUploadOptions struct {
+	Path        string
+	Permissions string
+	Checksum    string
+	Mode        string
+	Extract     string
+	// user and group names are resolved on the target, numeric IDs are used as they are
+	Owner          string
+	Group          string
+	DirPermissions string
+	SELinuxContext string
+}
+
+func readFileUploadOptions(req *http.Request) FileUploadOptions {
+	return FileUploadOptions{
+		Path:           req.FormValue("path"),
+		Permissions:    req.FormValue("permissions"),
+		Checksum:       req.FormValue("checksum"),
+		Mode:           req.FormValue("mode"),
+		Extract:        req.FormValue("extract"),
+		Owner:          req.FormValue("owner"),
+		Group:          req.FormValue("group"),
+		DirPermissions: req.FormValue("dirPermissions"),
+		SELinuxContext: req.FormValue("selinuxContext"),
+	}
+}
+
+func (o FileUploadOptions) writeFields(w *multipart.Writer) error {
+	if err := w.WriteField("path", o.Path); err != nil {
+		return err
+	}
+	if err := w.WriteField("permissions", o.Permissions); err != nil {
+		return err
+	}
+	for name, value := range map[string]string{
+		"checksum":       o.Checksum,
+		"mode":           o.Mode,
+		"extract":        o.Extract,
+		"owner":          o.Owner,
+		"group":          o.Group,
+		"dirPermissions": o.DirPermissions,
+		"selinuxContext": o.SELinuxContext,
+	} {
+		if len(value) > 0 {
+			if err := w.WriteField(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// uploadAttributes are applied to the uploaded files and the created directories before they are moved in place,
+// the zero values keep the current attributes
+type uploadAttributes struct {
+	uid     int
+	gid     int
+	perm    fs.FileMode
+	dirMode fs.FileMode
+	context string
+}
+
+// attributes resolves the requested attributes, nil is returned if nothing was requested
+func (o FileUploadOptions) attributes() (*uploadAttributes, error) {
+	if len(o.Permissions) == 0 && len(o.Owner) == 0 && len(o.Group) == 0 && len(o.DirPermissions) == 0 && len(o.SELinuxContext) == 0 {
+		return nil, nil
+	}
+	perm, err := parseFileMode(o.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	dirMode, err := parseFileMode(o.DirPermissions)
+	if err != nil {
+		return nil, err
+	}
+	// the fields are not covered by the signature of the upload, so they cannot create setuid or setgid files
+	if (perm|dirMode)&(fs.ModeSetuid|fs.ModeSetgid) != 0 {
+		return nil, fmt.Errorf("setuid and setgid permissions are not allowed: %s %s", o.Permissions, o.DirPermissions)
+	}
+	uid, err := lookupUid(o.Owner)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := lookupGid(o.Group)
+	if err != nil {
+		return nil, err
+	}
+	if len(o.SELinuxContext) > 0 && !selinuxContextPattern.MatchString(o.SELinuxContext) {
+		return nil, fmt.Errorf("invalid SELinux context: %s", o.SELinuxContext)
+	}
+	return &uploadAttributes{uid: uid, gid: gid, perm: perm, dirMode: dirMode, context: o.SELinuxContext}, nil
+}
+
+// parseFileMode parses an octal mode, the setuid, setgid and sticky bits are converted to their file mode flags
+func parseFileMode(mode string) (fs.FileMode, error) {
+	if len(mode) == 0 {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 07777 {
+		return 0, fmt.Errorf("invalid permissions: %s", mode)
+	}
+	result := fs.FileMode(value & 0777)
+	for bit, flag := range map[uint64]fs.FileMode{04000: fs.ModeSetuid, 02000: fs.ModeSetgid, 01000: fs.ModeSticky} {
+		if value&bit != 0 {
+			result |= flag
+		}
+	}
+	return result, nil
+}
+
+func lookupUid(owner string) (int, error) {
+	if len(owner) == 0 {
+		return -1, nil
+	}
+	if uid, err := strconv.Atoi(owner); err == nil && uid >= 0 {
+		return uid, nil
+	}
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+func lookupGid(group string) (int, error) {
+	if len(group) == 0 {
+		return -1, nil
+	}
+	if gid, err := strconv.Atoi(group); err == nil && gid >= 0 {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// applyOwnership sets the owner, the group and the SELinux context, the mode is left as it is
+func (a *uploadAttributes) applyOwnership(path string) error {
+	if a == nil {
+		return nil
+	}
+	if a.uid >= 0 || a.gid >= 0 {
+		if err := os.Lchown(path, a.uid, a.gid); err != nil {
+			return err
+		}
+	}
+	if len(a.context) > 0 {
+		return setSELinuxContext(path, a.context)
+	}
+	return nil
+}
+
+// applyLinkOwnership sets the owner and the group of a symlink, the SELinux context of symlinks is not managed
+func (a *uploadAttributes) applyLinkOwnership(path string) error {
+	if a == nil || a.uid < 0 && a.gid < 0 {
+		return nil
+	}
+	return os.Lchown(path, a.uid, a.gid)
+}
+
+func (a *uploadAttributes) applyMode(path string, mode fs.FileMode) error {
+	if err := a.applyOwnership(path); err != nil {
+		return err
+	}
+	// chmod comes after chown, which clears the setuid and setgid bits
+	if mode != 0 {
+		return os.Chmod(path, mode)
+	}
+	return nil
+}
+
+func (a *uploadAttributes) applyFile(path string) error {
+	if a == nil {
+		return nil
+	}
+	return a.applyMode(path, a.perm)
+}
+
+func (a *uploadAttributes) applyDir(path string) error {
+	if a == nil {
+		return nil
+	}
+	return a.applyMode(path, a.dirMode)
+}
+
+// mkdirAll creates the missing directories of the path and applies the attributes to the created ones only
+func (a *uploadAttributes) mkdirAll(path string, perm fs.FileMode) error {
+	if a == nil {
+		return os.MkdirAll(path, perm)
+	}
+	if info, err := os.Stat(path); err == nil {
+		if !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if parent := filepath.Dir(path); parent != path {
+		if err := a.mkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(path, perm); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	return a.applyDir(path)
+}
+
+// matches tells whether the file already has the requested attributes
+func (a *uploadAttributes) matches(path string) (bool, error) {
+	if a == nil {
+		return true, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if a.perm != 0 && info.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) != a.perm {
+		return false, nil
+	}
+	if uid, gid, ok := fileOwner(info); ok {
+		if a.uid >= 0 && uid != a.uid || a.gid >= 0 && gid != a.gid {
+			return false, nil
+		}
+	}
+	if len(a.context) > 0 {
+		context, err := getSELinuxContext(path)
+		if err != nil {
+			return false, err
+		}
+		return context == a.context, nil
+	}
+	return true, nil
+}
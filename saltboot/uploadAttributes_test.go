@@ -0,0 +1,169 @@
+package saltboot
+
+import (
+	"bytes"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+func TestParseFileMode(t *testing.T) {
+	for mode, expected := range map[string]fs.FileMode{
+		"":     0,
+		"0640": 0640,
+		"755":  0755,
+		"4755": fs.ModeSetuid | 0755,
+		"3770": fs.ModeSetgid | fs.ModeSticky | 0770,
+	} {
+		if actual, err := parseFileMode(mode); err != nil || actual != expected {
+			t.Errorf("mode of %s not match %s == %s, %v", mode, expected, actual, err)
+		}
+	}
+	for _, mode := range []string{"0999", "rwx", "17777"} {
+		if _, err := parseFileMode(mode); err == nil {
+			t.Errorf("%s is expected to be rejected", mode)
+		}
+	}
+}
+
+func TestFileUploadOptionsAttributes(t *testing.T) {
+	if attributes, err := (FileUploadOptions{Path: "/tmp", Checksum: "abc"}).attributes(); err != nil || attributes != nil {
+		t.Errorf("no attributes are expected if none were requested: %v, %v", attributes, err)
+	}
+
+	attributes, err := FileUploadOptions{Owner: "1234", Group: "5678", DirPermissions: "0750", SELinuxContext: "system_u:object_r:cert_t:s0"}.attributes()
+	expected := uploadAttributes{uid: 1234, gid: 5678, dirMode: 0750, context: "system_u:object_r:cert_t:s0"}
+	if err != nil || *attributes != expected {
+		t.Errorf("attributes not match %v == %v, %v", expected, attributes, err)
+	}
+
+	for _, options := range []FileUploadOptions{
+		{Owner: "no-such-user-of-saltboot"},
+		{Group: "no-such-group-of-saltboot"},
+		{Permissions: "0888"},
+		{DirPermissions: "dir"},
+		{SELinuxContext: "cert_t; rm -rf /"},
+		{Permissions: "4755"},
+		{DirPermissions: "2770"},
+	} {
+		if _, err := options.attributes(); err == nil {
+			t.Errorf("%v is expected to be rejected", options)
+		}
+	}
+}
+
+func TestWriteFileWithPrepare(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "uploadattributestest")
+	defer os.RemoveAll(tempDirName)
+	file := filepath.Join(tempDirName, "keytab")
+
+	err := writeFileWith(file, []byte("secret"), 0644, func(tmp string) error {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Error("file is not expected to be visible before it is prepared")
+		}
+		return os.Chmod(tmp, 0600)
+	})
+
+	if info, _ := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("prepared mode is expected: %v, %v", info, err)
+	}
+}
+
+func TestUploadWithAttributes(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("current user is unknown: %s", err)
+	}
+	tempDirName, _ := os.MkdirTemp("", "uploadattributestest")
+	defer os.RemoveAll(tempDirName)
+	path := filepath.Join(tempDirName, "security", "keytabs")
+
+	for _, upload := range []struct {
+		permissions string
+		code        int
+		outcome     string
+	}{
+		{"0600", 201, UPLOAD_CREATED},
+		{"0600", 201, UPLOAD_UNCHANGED},
+		{"0640", 201, UPLOAD_UPDATED},
+	} {
+		req := newUploadRequest(path, "service.keytab", []byte("keytab"), "")
+		req.URL.RawQuery += "&owner=" + current.Username + "&group=" + current.Gid + "&dirPermissions=0750&permissions=" + upload.permissions
+		writer := httptest.NewRecorder()
+
+		FileUploadHandler(writer, req)
+
+		if writer.Code != upload.code || !bytes.HasSuffix(writer.Body.Bytes(), []byte(upload.outcome+".")) {
+			t.Errorf("upload with %s not match %d %s == %d %s", upload.permissions, upload.code, upload.outcome, writer.Code, writer.Body.String())
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(path, "service.keytab"))
+	if err != nil || info.Mode().Perm() != 0640 {
+		t.Fatalf("mode of the file not match: %v, %v", info, err)
+	}
+	if uid, gid, _ := fileOwner(info); strconv.Itoa(uid) != current.Uid || strconv.Itoa(gid) != current.Gid {
+		t.Errorf("ownership not match %s:%s == %d:%d", current.Uid, current.Gid, uid, gid)
+	}
+	for _, dir := range []string{path, filepath.Dir(path)} {
+		if info, _ := os.Stat(dir); info.Mode().Perm() != 0750 {
+			t.Errorf("mode of the created directory %s not match: %s", dir, info.Mode())
+		}
+	}
+}
+
+func TestUploadWithInvalidAttributes(t *testing.T) {
+	tempDirName, _ := os.MkdirTemp("", "uploadattributestest")
+	defer os.RemoveAll(tempDirName)
+	req := newUploadRequest(tempDirName, "test.txt", []byte("content"), "")
+	req.URL.RawQuery += "&owner=no-such-user-of-saltboot"
+	writer := httptest.NewRecorder()
+
+	FileUploadHandler(writer, req)
+
+	if writer.Code != 400 {
+		t.Errorf("Wrong status code %d == %d", 400, writer.Code)
+	}
+	if _, err := os.Stat(filepath.Join(tempDirName, "test.txt")); !os.IsNotExist(err) {
+		t.Error("file is not expected to be written")
+	}
+}
+
+func TestDistributeFileUploadRequestAttributes(t *testing.T) {
+	var mu sync.Mutex
+	received := FileUploadOptions{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = readFileUploadOptions(r)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	options := FileUploadOptions{Path: "/etc/security/keytabs", Permissions: "0600", Extract: EXTRACT_NEVER, Owner: "hdfs", Group: "hadoop",
+		DirPermissions: "0750", SELinuxContext: "system_u:object_r:cert_t:s0"}
+	file := &ReadSeekCloser{Reader: bytes.NewReader([]byte("keytab"))}
+
+	var responses []model.Response
+	for res := range DistributeFileUploadRequest("/upload", "user", "pass", []string{server.Listener.Addr().String()}, options, file,
+		&multipart.FileHeader{Filename: "hdfs.keytab"}, "test-signature") {
+		responses = append(responses, res)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(responses) != 1 || responses[0].StatusCode != http.StatusCreated {
+		t.Errorf("upload is expected to succeed: %v", responses)
+	}
+	if received != options {
+		t.Errorf("options not match %v == %v", options, received)
+	}
+}
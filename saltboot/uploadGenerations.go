@@ -0,0 +1,333 @@
+package saltboot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hortonworks/salt-bootstrap/saltboot/model"
+)
+
+const (
+	UPLOAD_MODE_MERGE   = "merge"
+	UPLOAD_MODE_REPLACE = "replace"
+
+	uploadGenerationsKey     = "SALTBOOT_UPLOAD_GENERATIONS"
+	defaultUploadGenerations = 3
+
+	// the fields of an upload are not covered by its signature, so only the directories under these roots can be
+	// replaced or rolled back
+	uploadReplaceRootsKey     = "SALTBOOT_UPLOAD_REPLACE_ROOTS"
+	defaultUploadReplaceRoots = "/srv"
+)
+
+var (
+	uploadModes             = []string{"", UPLOAD_MODE_MERGE, UPLOAD_MODE_REPLACE}
+	uploadGenerationPattern = regexp.MustCompile(`^[0-9]+$`)
+)
+
+type UploadRollbackRequest struct {
+	Path string `json:"path"`
+	// the latest generation is restored if it is not specified
+	Generation string   `json:"generation,omitempty"`
+	Targets    []string `json:"targets,omitempty"`
+}
+
+type UploadGenerations struct {
+	Path string `json:"path"`
+	// the restored generation of a rollback request
+	Restored    string   `json:"restored,omitempty"`
+	Generations []string `json:"generations"`
+}
+
+func determineUploadGenerations() int {
+	if generations, err := strconv.Atoi(os.Getenv(uploadGenerationsKey)); err == nil && generations >= 0 {
+		return generations
+	}
+	return defaultUploadGenerations
+}
+
+func determineUploadReplaceRoots() []string {
+	roots := os.Getenv(uploadReplaceRootsKey)
+	if len(strings.TrimSpace(roots)) == 0 {
+		roots = defaultUploadReplaceRoots
+	}
+	var result []string
+	for _, root := range strings.Split(roots, ",") {
+		if root = strings.TrimSpace(root); filepath.IsAbs(root) {
+			result = append(result, filepath.Clean(root))
+		}
+	}
+	return result
+}
+
+// isUnderRoot tells whether the path is strictly under the root
+func isUnderRoot(path string, root string) bool {
+	return path != root && strings.HasPrefix(path, strings.TrimSuffix(root, "/")+"/")
+}
+
+// resolveExistingPath resolves the symlinks of the deepest existing ancestor of the path, the missing part is appended
+// as it is, since it cannot contain symlinks yet
+func resolveExistingPath(path string) (string, error) {
+	existing := path
+	var missing []string
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		if filepath.Dir(existing) == existing {
+			break
+		}
+		missing = append([]string{filepath.Base(existing)}, missing...)
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{resolved}, missing...)...), nil
+}
+
+// isReplaceAllowed tells whether the path is under one of the allowed roots, the existing ancestors are resolved,
+// so a symlink cannot move the replaced directory outside of the root
+func isReplaceAllowed(path string) bool {
+	if !filepath.IsAbs(path) {
+		return false
+	}
+	path = filepath.Clean(path)
+	for _, root := range determineUploadReplaceRoots() {
+		if !isUnderRoot(path, root) {
+			continue
+		}
+		realParent, err := resolveExistingPath(filepath.Dir(path))
+		if err != nil {
+			continue
+		}
+		realRoot, err := resolveExistingPath(root)
+		if err == nil && (realParent == realRoot || isUnderRoot(realParent, realRoot)) {
+			return true
+		}
+	}
+	return false
+}
+
+// the previous trees are kept next to the directory, so they are on the same filesystem and can be renamed
+func uploadGenerationsDir(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".generations")
+}
+
+// listUploadGenerations returns the kept generations of the directory, the latest comes first
+func listUploadGenerations(path string) ([]string, error) {
+	entries, err := os.ReadDir(uploadGenerationsDir(path))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	generations := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && uploadGenerationPattern.MatchString(entry.Name()) {
+			generations = append(generations, entry.Name())
+		}
+	}
+	sort.Slice(generations, func(i, j int) bool {
+		a, _ := strconv.ParseInt(generations[i], 10, 64)
+		b, _ := strconv.ParseInt(generations[j], 10, 64)
+		return a > b
+	})
+	return generations, nil
+}
+
+func pruneUploadGenerations(path string, keep int) error {
+	generations, err := listUploadGenerations(path)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(generations); i++ {
+		log.Printf("[pruneUploadGenerations] remove generation %s of %s", generations[i], path)
+		if err := os.RemoveAll(filepath.Join(uploadGenerationsDir(path), generations[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// swapDirectory moves the replacement to the path and the current tree of the path to the replacement,
+// the exchange is atomic where the filesystem supports it
+func swapDirectory(path string, replacement string) error {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return os.Rename(replacement, path)
+	}
+	err := exchangePaths(replacement, path)
+	if err == nil || !(errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOSYS)) {
+		return err
+	}
+	log.Printf("[swapDirectory] atomic exchange is not supported, rename %s and %s", path, replacement)
+	previous := replacement + ".previous"
+	if err := os.Rename(path, previous); err != nil {
+		return err
+	}
+	if err := os.Rename(replacement, path); err != nil {
+		if restoreErr := os.Rename(previous, path); restoreErr != nil {
+			log.Printf("[swapDirectory] [ERROR] unable to restore %s: %s", path, restoreErr.Error())
+		}
+		return err
+	}
+	return os.Rename(previous, replacement)
+}
+
+// archiveGeneration keeps the swapped out tree as the latest generation, or removes it if no generations are kept
+func archiveGeneration(path string, previous string) error {
+	keep := determineUploadGenerations()
+	if _, err := os.Lstat(previous); os.IsNotExist(err) {
+		return nil
+	}
+	if keep == 0 {
+		return os.RemoveAll(previous)
+	}
+	generationsDir := uploadGenerationsDir(path)
+	if err := os.MkdirAll(generationsDir, 0700); err != nil {
+		return err
+	}
+	generation := strconv.FormatInt(time.Now().UnixNano(), 10)
+	log.Printf("[archiveGeneration] keep the previous tree of %s as generation %s", path, generation)
+	if err := os.Rename(previous, filepath.Join(generationsDir, generation)); err != nil {
+		return err
+	}
+	return pruneUploadGenerations(path, keep)
+}
+
+// replaceDirectory populates a staging sibling of the directory and swaps it in, the directory is not changed if
+// populating the staging directory fails. The requested attributes of the directory are applied before the swap.
+func replaceDirectory(path string, attributes *uploadAttributes, populate func(staging string) error) error {
+	path = filepath.Clean(path)
+	staging := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.staging-%d", filepath.Base(path), time.Now().UnixNano()))
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	if err := populate(staging); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		if err := os.Chmod(staging, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if err := attributes.applyDir(staging); err != nil {
+		return err
+	}
+
+	log.Printf("[replaceDirectory] swap %s with %s", path, staging)
+	if err := swapDirectory(path, staging); err != nil {
+		return err
+	}
+	return archiveGeneration(path, staging)
+}
+
+// rollbackDirectory swaps a kept generation back, the replaced tree is kept as the latest generation
+func rollbackDirectory(path string, generation string) (string, error) {
+	path = filepath.Clean(path)
+	if len(generation) == 0 {
+		generations, err := listUploadGenerations(path)
+		if err != nil {
+			return "", err
+		}
+		if len(generations) == 0 {
+			return "", fmt.Errorf("no generations of %s: %w", path, os.ErrNotExist)
+		}
+		generation = generations[0]
+	}
+	if !uploadGenerationPattern.MatchString(generation) {
+		return "", fmt.Errorf("invalid generation: %s", generation)
+	}
+	generationDir := filepath.Join(uploadGenerationsDir(path), generation)
+	if _, err := os.Stat(generationDir); err != nil {
+		return "", err
+	}
+
+	log.Printf("[rollbackDirectory] restore generation %s of %s", generation, path)
+	if err := swapDirectory(path, generationDir); err != nil {
+		return "", err
+	}
+	return generation, archiveGeneration(path, generationDir)
+}
+
+func UploadRollbackHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[UploadRollbackHandler] execute upload rollback request")
+
+	decoder := json.NewDecoder(req.Body)
+	var rollbackRequest UploadRollbackRequest
+	if err := decoder.Decode(&rollbackRequest); err != nil {
+		log.Printf("[UploadRollbackHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if !isReplaceAllowed(rollbackRequest.Path) {
+		log.Printf("[UploadRollbackHandler] [ERROR] invalid path: %s", rollbackRequest.Path)
+		model.Response{Status: "invalid path: " + rollbackRequest.Path}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(rollbackRequest.Generation) > 0 && !uploadGenerationPattern.MatchString(rollbackRequest.Generation) {
+		log.Printf("[UploadRollbackHandler] [ERROR] invalid generation: %s", rollbackRequest.Generation)
+		model.Response{Status: "invalid generation: " + rollbackRequest.Generation}.WriteBadRequestHttp(w)
+		return
+	}
+
+	restored, err := rollbackDirectory(rollbackRequest.Path, rollbackRequest.Generation)
+	if err != nil {
+		log.Printf("[UploadRollbackHandler] [ERROR] unable to roll back %s: %s", rollbackRequest.Path, err.Error())
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, os.ErrNotExist) {
+			statusCode = http.StatusNotFound
+		}
+		model.Response{ErrorText: err.Error(), StatusCode: statusCode}.WriteHttp(w)
+		return
+	}
+	generations, err := listUploadGenerations(filepath.Clean(rollbackRequest.Path))
+	if err != nil {
+		log.Printf("[UploadRollbackHandler] [ERROR] unable to list generations: %s", err.Error())
+	}
+	result := UploadGenerations{Path: rollbackRequest.Path, Restored: restored, Generations: generations}
+	model.Response{Status: fmt.Sprintf("%s is rolled back to generation %s", rollbackRequest.Path, restored), Payload: result}.WriteHttp(w)
+}
+
+func UploadRollbackDistributeHandler(w http.ResponseWriter, req *http.Request) {
+	log.Println("[UploadRollbackDistributeHandler] distribute upload rollback request")
+
+	decoder := json.NewDecoder(req.Body)
+	var rollbackRequest UploadRollbackRequest
+	if err := decoder.Decode(&rollbackRequest); err != nil {
+		log.Printf("[UploadRollbackDistributeHandler] [ERROR] couldn't decode json: %s", err.Error())
+		model.Response{Status: err.Error()}.WriteBadRequestHttp(w)
+		return
+	}
+	if len(rollbackRequest.Targets) == 0 {
+		log.Printf("[UploadRollbackDistributeHandler] [ERROR] no targets were specified in the request")
+		model.Response{Status: "no targets were specified in the request"}.WriteBadRequestHttp(w)
+		return
+	}
+
+	user, pass := GetAuthUserPass(req)
+	result := distributeToTargetsImpl(DistributeRequest, rollbackRequest.Targets, UploadRollbackEP, user, pass, GetSignedRequestBody(req))
+	cResp := model.Responses{Responses: result}
+	log.Printf("[UploadRollbackDistributeHandler] distribute upload rollback request executed: %s", cResp.String())
+	if err := json.NewEncoder(w).Encode(cResp); err != nil {
+		log.Printf("[UploadRollbackDistributeHandler] [ERROR] couldn't encode json: %s", err.Error())
+	}
+}
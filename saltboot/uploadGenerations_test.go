@@ -0,0 +1,172 @@
+package saltboot
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func uploadReplace(path string, content []byte) int {
+	req := newUploadRequest(path, "bundle.tar", content, "")
+	req.URL.RawQuery += "&mode=" + UPLOAD_MODE_REPLACE
+	writer := httptest.NewRecorder()
+	FileUploadHandler(writer, req)
+	return writer.Code
+}
+
+func TestReplaceDirectoryKeepsGenerations(t *testing.T) {
+	t.Setenv(uploadGenerationsKey, "2")
+	parent, _ := os.MkdirTemp("", "uploadgenerationstest")
+	defer os.RemoveAll(parent)
+	path := filepath.Join(parent, "salt")
+
+	for _, name := range []string{"v1.sls", "v2.sls", "v3.sls", "v4.sls"} {
+		err := replaceDirectory(path, nil, func(staging string) error {
+			return os.WriteFile(filepath.Join(staging, name), []byte(name), 0644)
+		})
+		if err != nil {
+			t.Fatalf("unable to replace directory: %s", err)
+		}
+	}
+
+	entries, _ := os.ReadDir(path)
+	if len(entries) != 1 || entries[0].Name() != "v4.sls" {
+		t.Errorf("directory is expected to contain the latest tree only: %v", entries)
+	}
+	generations, _ := listUploadGenerations(path)
+	if len(generations) != 2 {
+		t.Fatalf("generations are expected to be pruned to 2: %v", generations)
+	}
+	if _, err := os.Stat(filepath.Join(uploadGenerationsDir(path), generations[0], "v3.sls")); err != nil {
+		t.Errorf("latest generation is expected to be the previous tree: %s", err)
+	}
+	siblings, _ := os.ReadDir(parent)
+	if len(siblings) != 2 {
+		t.Errorf("staging directories are expected to be removed: %v", siblings)
+	}
+}
+
+func TestReplaceDirectoryFailureKeepsTree(t *testing.T) {
+	parent, _ := os.MkdirTemp("", "uploadgenerationstest")
+	defer os.RemoveAll(parent)
+	path := filepath.Join(parent, "salt")
+	os.MkdirAll(path, 0755)
+	os.WriteFile(filepath.Join(path, "top.sls"), []byte("base"), 0644)
+	failure := errors.New("extraction failed")
+
+	err := replaceDirectory(path, nil, func(staging string) error {
+		os.WriteFile(filepath.Join(staging, "half.sls"), []byte("half"), 0644)
+		return failure
+	})
+
+	if !errors.Is(err, failure) {
+		t.Errorf("failure is expected to be returned: %v", err)
+	}
+	entries, _ := os.ReadDir(path)
+	if len(entries) != 1 || entries[0].Name() != "top.sls" {
+		t.Errorf("directory is not expected to be changed: %v", entries)
+	}
+	if siblings, _ := os.ReadDir(parent); len(siblings) != 1 {
+		t.Errorf("staging directory is expected to be removed: %v", siblings)
+	}
+}
+
+func TestUploadReplaceAndRollback(t *testing.T) {
+	parent, _ := os.MkdirTemp("", "uploadgenerationstest")
+	defer os.RemoveAll(parent)
+	t.Setenv(uploadReplaceRootsKey, parent)
+	path := filepath.Join(parent, "salt")
+
+	first := buildTar([]tarEntry{{name: "old.sls", typeflag: tar.TypeReg, mode: 0644, content: "old"}})
+	second := buildTar([]tarEntry{{name: "new.sls", typeflag: tar.TypeReg, mode: 0644, content: "new"}})
+	if code := uploadReplace(path, first); code != 201 {
+		t.Fatalf("Wrong status code %d == %d", 201, code)
+	}
+	if code := uploadReplace(path, second); code != 201 {
+		t.Fatalf("Wrong status code %d == %d", 201, code)
+	}
+	if _, err := os.Stat(filepath.Join(path, "old.sls")); !os.IsNotExist(err) {
+		t.Error("files removed upstream are expected to be removed")
+	}
+
+	body, _ := json.Marshal(UploadRollbackRequest{Path: path})
+	writer := httptest.NewRecorder()
+	UploadRollbackHandler(writer, httptest.NewRequest("POST", UploadRollbackEP, bytes.NewReader(body)))
+
+	if writer.Code != 200 {
+		t.Fatalf("Wrong status code %d == %d", 200, writer.Code)
+	}
+	if b, _ := os.ReadFile(filepath.Join(path, "old.sls")); string(b) != "old" {
+		t.Errorf("previous tree is expected to be restored: %s", b)
+	}
+	generations, _ := listUploadGenerations(path)
+	if len(generations) != 1 {
+		t.Fatalf("rolled back tree is expected to be kept: %v", generations)
+	}
+	if _, err := os.Stat(filepath.Join(uploadGenerationsDir(path), generations[0], "new.sls")); err != nil {
+		t.Errorf("rolled back tree is expected to be the latest generation: %s", err)
+	}
+}
+
+func TestUploadReplaceRejectsPlainFile(t *testing.T) {
+	parent, _ := os.MkdirTemp("", "uploadgenerationstest")
+	defer os.RemoveAll(parent)
+	t.Setenv(uploadReplaceRootsKey, parent)
+
+	if code := uploadReplace(filepath.Join(parent, "salt"), []byte("plain")); code != 400 {
+		t.Errorf("Wrong status code %d == %d", 400, code)
+	}
+}
+
+func TestUploadRollbackHandlerMissingGeneration(t *testing.T) {
+	parent, _ := os.MkdirTemp("", "uploadgenerationstest")
+	defer os.RemoveAll(parent)
+	t.Setenv(uploadReplaceRootsKey, parent)
+
+	for _, rollback := range []struct {
+		request UploadRollbackRequest
+		code    int
+	}{
+		{UploadRollbackRequest{Path: filepath.Join(parent, "salt")}, 404},
+		{UploadRollbackRequest{Path: filepath.Join(parent, "salt"), Generation: "../x"}, 400},
+		{UploadRollbackRequest{Path: "/"}, 400},
+	} {
+		body, _ := json.Marshal(rollback.request)
+		writer := httptest.NewRecorder()
+
+		UploadRollbackHandler(writer, httptest.NewRequest("POST", UploadRollbackEP, bytes.NewReader(body)))
+
+		if writer.Code != rollback.code {
+			t.Errorf("status code of %v not match %d == %d", rollback.request, rollback.code, writer.Code)
+		}
+	}
+}
+
+func TestUploadReplaceOutsideOfRoots(t *testing.T) {
+	parent, _ := os.MkdirTemp("", "uploadgenerationstest")
+	defer os.RemoveAll(parent)
+	os.MkdirAll(filepath.Join(parent, "srv"), 0755)
+	os.MkdirAll(filepath.Join(parent, "etc"), 0755)
+	os.Symlink(filepath.Join(parent, "etc"), filepath.Join(parent, "srv", "link"))
+	t.Setenv(uploadReplaceRootsKey, filepath.Join(parent, "srv"))
+	bundle := buildTar([]tarEntry{{name: "evil.sls", typeflag: tar.TypeReg, mode: 0644, content: "evil"}})
+
+	for _, path := range []string{filepath.Join(parent, "etc", "salt"), filepath.Join(parent, "srv"), filepath.Join(parent, "srv", "link", "salt"),
+		filepath.Join(parent, "srv", "link", "missing", "salt"),
+		filepath.Join(parent, "srv", "..", "etc", "salt"), "salt"} {
+		if code := uploadReplace(path, bundle); code != 400 {
+			t.Errorf("replace of %s is expected to be rejected, status code %d == %d", path, 400, code)
+		}
+	}
+	if code := uploadReplace(filepath.Join(parent, "srv", "salt"), bundle); code != 201 {
+		t.Errorf("Wrong status code %d == %d", 201, code)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(parent, "etc")); len(entries) != 0 {
+		t.Errorf("nothing is expected to be written outside of the roots: %v", entries)
+	}
+}
@@ -96,7 +96,7 @@ func generatePasswordHashWithSalt(password string, salt string) (string, error)
 }
 
 func shouldUseUserAdd(os *Os) bool {
-	return isOs(os, UBUNTU) || isOs(os, DEBIAN) || isOs(os, SUSE, SLES12)
+	return DetectOs(os).IsFamily(OS_FAMILY_DEBIAN, OS_FAMILY_SUSE)
 }
 
 func ChangeUserPassword(saltMaster SaltMaster) (resp model.Response, err error) {
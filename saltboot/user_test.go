@@ -20,14 +20,29 @@ func TestCreateUser(t *testing.T) {
 
 	checkExecutedCommands([]string{
 		"grep saltuser /etc/passwd",
-		"grep Ubuntu /etc/issue",
-		"grep Debian /etc/issue",
-		"grep SUSE /etc/issue",
-		"grep sles12 /etc/issue",
 		"^adduser --no-create-home -G wheel -s /sbin/nologin --password \\$6\\$([a-zA-Z\\$0-9/.]+) saltuser",
 	}, t)
 }
 
+func TestCreateUserOnDebianFamily(t *testing.T) {
+	mockFunctions()
+	defer endMockFunctions()
+
+	master := SaltMaster{
+		Auth: SaltAuth{Password: "passwd"},
+	}
+
+	withOsReleaseFixture("ubuntu22", func() {
+		go CreateUser(master, nil)
+
+		checkExecutedCommands([]string{
+			"grep saltuser /etc/passwd",
+			"groupadd -r wheel",
+			"^useradd --no-create-home -G wheel -s /sbin/nologin --password \\$6\\$([a-zA-Z\\$0-9/.]+) saltuser",
+		}, t)
+	})
+}
+
 func TestChangePasswordToNewPassword(t *testing.T) {
 	mockFunctions()
 	defer endMockFunctions()
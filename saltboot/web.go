@@ -29,6 +29,43 @@ const (
 	HostnameEP                 = RootPath + "/hostname"
 	UploadEP                   = RootPath + "/file"
 	FileDistributeEP           = UploadEP + "/distribute"
+
+	SaltMinionDecommissionEP        = SaltMinionEp + "/" + DECOMMISSION_ACTION
+	SaltMinionGrainsEP              = SaltMinionEp + "/grains"
+	SaltMinionGrainsDistEP          = SaltMinionGrainsEP + "/distribute"
+	SaltMinionPrewarmedRolesEP      = SaltMinionEp + "/prewarmed-roles"
+	SaltMinionPrewarmedRolesSetEP   = SaltMinionPrewarmedRolesEP + "/set"
+	SaltMinionPrewarmedRolesClearEP = SaltMinionPrewarmedRolesEP + "/clear"
+	SaltMinionPrewarmedRolesDistEP  = SaltMinionPrewarmedRolesEP + "/distribute"
+	SaltMinionKeyInstallEP          = SaltMinionEp + "/key/install"
+	SaltMinionKeyRotateEP           = SaltMinionEp + "/key/rotate"
+	SaltMinionKeyRotateDistEP       = SaltMinionKeyRotateEP + "/distribute"
+
+	SaltServerDecommissionEP  = SaltServerEp + "/" + DECOMMISSION_ACTION
+	SaltServerKeyAcceptEP     = SaltServerEp + "/key/accept"
+	SaltServerKeyPreseedEP    = SaltServerEp + "/key/preseed"
+	SaltServerFingerprintEP   = SaltServerEp + "/fingerprint"
+	SaltServerKeyDeleteEP     = SaltServerEp + "/key/delete"
+	SaltServerKeyDeleteDistEP = SaltServerKeyDeleteEP + "/distribute"
+
+	SaltPillarTopEP           = SaltPillarEP + "/top"
+	SaltPillarTopDistributeEP = SaltPillarTopEP + "/distribute"
+	SaltPillarListEP          = SaltPillarEP + "/list"
+	SaltPillarGetEP           = SaltPillarEP + "/get"
+	SaltPillarDeleteEP        = SaltPillarEP + "/delete"
+	SaltPillarRollbackEP      = SaltPillarEP + "/rollback"
+	SaltPillarKeyRotateEP     = SaltPillarEP + "/key/rotate"
+
+	HostsCleanupEP             = RootPath + "/hosts/cleanup"
+	FileChecksumEP             = UploadEP + "/checksum"
+	FileChecksumDistributeEP   = FileChecksumEP + "/distribute"
+	UploadRollbackEP           = UploadEP + "/rollback"
+	UploadRollbackDistributeEP = UploadRollbackEP + "/distribute"
+
+	FactsEP                   = RootPath + "/facts"
+	FactsDistributeEP         = FactsEP + "/distribute"
+	ServiceStatusEP           = RootPath + "/service/{name}/status"
+	ServiceStatusDistributeEP = ServiceStatusEP + "/distribute"
 )
 
 func NewCloudbreakBootstrapWeb() {
@@ -45,21 +82,56 @@ func NewCloudbreakBootstrapWeb() {
 
 	r.Handle(SaltMinionRunEP, authenticator.Wrap(SaltMinionRunRequestHandler, SIGNED)).Methods("POST")
 	r.Handle(SaltMinionStopEP, authenticator.Wrap(SaltMinionStopRequestHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltMinionDecommissionEP, authenticator.Wrap(SaltMinionDecommissionRequestHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltMinionGrainsEP, authenticator.Wrap(SaltMinionGrainsRequestHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltMinionGrainsDistEP, authenticator.Wrap(SaltMinionGrainsDistributeHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltMinionPrewarmedRolesEP, authenticator.Wrap(SaltMinionPrewarmedRolesHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltMinionPrewarmedRolesSetEP, authenticator.Wrap(SaltMinionPrewarmedRolesSetHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltMinionPrewarmedRolesClearEP, authenticator.Wrap(SaltMinionPrewarmedRolesClearHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltMinionPrewarmedRolesDistEP, authenticator.Wrap(SaltMinionPrewarmedRolesDistributeHandler, SIGNED)).Methods("POST")
 	r.Handle(SaltMinionKeyEP, authenticator.Wrap(SaltMinionKeyHandler, SIGNED)).Methods("POST")
 	r.Handle(SaltMinionKeyDistributeEP, authenticator.Wrap(SaltMinionKeyDistributionHandler, SIGNED)).Methods("POST")
+	// sent by the master, the handler checks the signed grant of the orchestrator and the key signed by the master
+	r.Handle(SaltMinionKeyInstallEP, authenticator.Wrap(SaltMinionKeyInstallHandler, OPEN)).Methods("POST")
+	r.Handle(SaltMinionKeyRotateEP, authenticator.Wrap(SaltMinionKeyRotateHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltMinionKeyRotateDistEP, authenticator.Wrap(SaltMinionKeyRotateDistributeHandler, SIGNED)).Methods("POST")
 
 	r.Handle(SaltServerRunEP, authenticator.Wrap(SaltServerRunRequestHandler, SIGNED)).Methods("POST")
 	r.Handle(SaltServerStopEP, authenticator.Wrap(SaltServerStopRequestHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltServerDecommissionEP, authenticator.Wrap(SaltServerDecommissionRequestHandler, SIGNED)).Methods("POST")
 	r.Handle(SaltServerChangePasswordEP, authenticator.Wrap(SaltServerChangePasswordHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltServerKeyAcceptEP, authenticator.Wrap(SaltMasterKeyAcceptHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltServerKeyPreseedEP, authenticator.Wrap(SaltMasterKeyPreseedHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltServerFingerprintEP, authenticator.Wrap(SaltMasterFingerprintHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltServerKeyDeleteEP, authenticator.Wrap(SaltMasterKeyDeleteHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltServerKeyDeleteDistEP, authenticator.Wrap(SaltMasterKeyDeleteDistributeHandler, SIGNED)).Methods("POST")
 
 	r.Handle(SaltPillarEP, authenticator.Wrap(SaltPillarRequestHandler, SIGNED)).Methods("POST")
 	r.Handle(SaltPillarDistributeEP, authenticator.Wrap(SaltPillarDistributeRequestHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltPillarTopEP, authenticator.Wrap(SaltPillarTopRequestHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltPillarListEP, authenticator.Wrap(SaltPillarListRequestHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltPillarGetEP, authenticator.Wrap(SaltPillarGetRequestHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltPillarDeleteEP, authenticator.Wrap(SaltPillarDeleteRequestHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltPillarRollbackEP, authenticator.Wrap(SaltPillarRollbackRequestHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltPillarKeyRotateEP, authenticator.Wrap(SaltPillarKeyRotateRequestHandler, SIGNED)).Methods("POST")
+	r.Handle(SaltPillarTopDistributeEP, authenticator.Wrap(SaltPillarTopDistributeRequestHandler, SIGNED)).Methods("POST")
 
 	r.Handle(HostnameDistributeEP, authenticator.Wrap(ClientHostnameDistributionHandler, SIGNED)).Methods("POST")
 	r.Handle(HostnameEP, authenticator.Wrap(ClientHostnameHandler, OPEN)).Methods("POST")
+	r.Handle(HostsCleanupEP, authenticator.Wrap(HostsCleanupRequestHandler, SIGNED)).Methods("POST")
 
 	r.Handle(UploadEP, authenticator.Wrap(FileUploadHandler, SIGNED)).Methods("POST")
 	r.Handle(FileDistributeEP, authenticator.Wrap(FileUploadDistributeHandler, SIGNED)).Methods("POST")
+	r.Handle(FileChecksumEP, authenticator.Wrap(FileChecksumHandler, SIGNED)).Methods("POST")
+	r.Handle(UploadRollbackEP, authenticator.Wrap(UploadRollbackHandler, SIGNED)).Methods("POST")
+	r.Handle(UploadRollbackDistributeEP, authenticator.Wrap(UploadRollbackDistributeHandler, SIGNED)).Methods("POST")
+	r.Handle(FileChecksumDistributeEP, authenticator.Wrap(FileChecksumDistributeHandler, SIGNED)).Methods("POST")
+
+	r.Handle(FactsEP, authenticator.Wrap(NodeFactsHandler, SIGNED)).Methods("POST")
+	r.Handle(FactsDistributeEP, authenticator.Wrap(NodeFactsDistributeHandler, SIGNED)).Methods("POST")
+
+	r.Handle(ServiceStatusEP, authenticator.Wrap(ServiceStatusHandler, SIGNED)).Methods("POST")
+	r.Handle(ServiceStatusDistributeEP, authenticator.Wrap(ServiceStatusDistributeHandler, SIGNED)).Methods("POST")
 
 	httpsEnabled := HttpsEnabled()
 	httpsServerExit := make(chan bool)